@@ -6,3 +6,40 @@ const (
 	WHITELIST FliterMode = "WhiteList" // 白名单
 	BLACKLIST FliterMode = "BlackList" // 黑名单
 )
+
+type ClientFilterAction string // 客户端过滤规则动作
+
+const (
+	ClientFilterAllow ClientFilterAction = "Allow" // 放行
+	ClientFilterDeny  ClientFilterAction = "Deny"  // 拦截
+	ClientFilterLog   ClientFilterAction = "Log"   // 仅记录日志，继续匹配后续规则
+)
+
+type ACMEChallenge string // ACME 验证方式
+
+const (
+	ACMEHTTP01    ACMEChallenge = "HTTP-01"     // 通过 80 端口的 HTTP 请求验证
+	ACMETLSALPN01 ACMEChallenge = "TLS-ALPN-01" // 通过 443 端口的 TLS 握手验证
+)
+
+type LogFormat string // 日志格式
+
+const (
+	LogFormatText LogFormat = "Text" // 文本格式
+	LogFormatJSON LogFormat = "JSON" // JSON 格式
+)
+
+type HostRewrite string // 转发至上游服务器的 Host 请求头
+
+const (
+	HostClient   HostRewrite = "Client"   // 保留客户端请求的 Host
+	HostUpstream HostRewrite = "Upstream" // 使用媒体服务器地址中的主机名和端口
+	HostCustom   HostRewrite = "Custom"   // 使用 Forwarded.CustomHost
+)
+
+type BalanceStrategy string // 媒体服务器地址池的选择策略
+
+const (
+	BalanceFailover BalanceStrategy = "Failover" // 按顺序使用第一个可用的地址
+	BalanceSticky   BalanceStrategy = "Sticky"   // 按设备 ID 固定使用其中一个可用的地址
+)
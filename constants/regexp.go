@@ -2,6 +2,9 @@ package constants
 
 import "regexp"
 
+var StreamRouteRegexp = regexp.MustCompile(`(?i)^(/emby)?/(videos|audio)/`)                              // 视频流、音频流请求（包括 HLS 分片和字幕），用于区分 Web 请求和视频流请求
+var AuthRouteRegexp = regexp.MustCompile(`(?i)^(/emby)?/Users/(AuthenticateByName|[^/]+/Authenticate)$`) // 媒体服务器登录接口
+
 type EmbyRegexps struct {
 	Router RouterRegexps
 	Others OthersRegexps
@@ -13,6 +16,8 @@ type RouterRegexps struct {
 	ModifyIndex          *regexp.Regexp // Web 首页
 	ModifyPlaybackInfo   *regexp.Regexp // 播放信息处理接口
 	ModifySubtitles      *regexp.Regexp // 字幕处理接口
+	PlayingReport        *regexp.Regexp // 播放进度报告接口
+	WebSocket            *regexp.Regexp // WebSocket 接口
 }
 
 type OthersRegexps struct {
@@ -26,6 +31,8 @@ var EmbyRegexp = &EmbyRegexps{
 		ModifyIndex:          regexp.MustCompile(`^/web/index.html$`),
 		ModifyPlaybackInfo:   regexp.MustCompile(`(?i)^(/emby)?/Items/\d+/PlaybackInfo$`),
 		ModifySubtitles:      regexp.MustCompile(`(?i)^(/emby)?/Videos/\d+/\w+/subtitles$`),
+		PlayingReport:        regexp.MustCompile(`(?i)^(/emby)?/Sessions/Playing(/Progress|/Stopped|/Ping)?$`),
+		WebSocket:            regexp.MustCompile(`(?i)^(/emby)?/embywebsocket$`),
 	},
 	Others: OthersRegexps{
 		VideoRedirectReg: regexp.MustCompile(`(?i)^(/emby)?/videos/(.*)/stream/(.*)`),
@@ -37,6 +44,8 @@ type JellyfinRouterRegexps struct {
 	ModifyIndex        *regexp.Regexp // Web 首页
 	ModifyPlaybackInfo *regexp.Regexp // 播放信息处理接口
 	ModifySubtitles    *regexp.Regexp // 字幕处理接口
+	PlayingReport      *regexp.Regexp // 播放进度报告接口
+	WebSocket          *regexp.Regexp // WebSocket 接口
 }
 type JellyfinRegexps struct {
 	Router JellyfinRouterRegexps
@@ -48,5 +57,7 @@ var JellyfinRegexp = &JellyfinRegexps{
 		ModifyIndex:        regexp.MustCompile(`^/web/$`),
 		ModifyPlaybackInfo: regexp.MustCompile(`^/Items/\w+$`),
 		ModifySubtitles:    regexp.MustCompile(`/Videos/\d+/\w+/subtitles$`),
+		PlayingReport:      regexp.MustCompile(`(?i)^/Sessions/Playing(/Progress|/Stopped|/Ping)?$`),
+		WebSocket:          regexp.MustCompile(`(?i)^/socket$`),
 	},
 }
@@ -7,3 +7,62 @@ var (
 	AlistStrm   StrmFileType = "AlistStrm"
 	UnknownStrm StrmFileType = "UnknownStrm"
 )
+
+type StrmScanStatus string // Strm 文件扫描结果
+
+const (
+	StrmScanOK           StrmScanStatus = "OK"           // 正常
+	StrmScanBroken       StrmScanStatus = "Broken"       // 失效
+	StrmScanSlow         StrmScanStatus = "Slow"         // 解析缓慢
+	StrmScanRedirectLoop StrmScanStatus = "RedirectLoop" // 循环重定向
+	StrmScanSkipped      StrmScanStatus = "Skipped"      // 未匹配任何 Strm 规则，跳过检测
+)
+
+type PathRewriteType string // Strm 内容重写方式
+
+const (
+	PathRewritePrefix    PathRewriteType = "Prefix"    // 前缀替换
+	PathRewriteRegexp    PathRewriteType = "Regexp"    // 正则替换
+	PathRewriteURLDecode PathRewriteType = "URLDecode" // URL 解码
+)
+
+type URLSignType string // URL 签名方式
+
+const (
+	URLSignHMAC            URLSignType = "HMAC"            // HMAC-SHA256 查询参数签名
+	URLSignNginxSecureLink URLSignType = "NginxSecureLink" // Nginx secure_link 模块
+	URLSignAlist           URLSignType = "Alist"           // Alist 签名
+)
+
+type PlaybackState string // 播放会话状态
+
+const (
+	PlaybackPreparing PlaybackState = "Preparing" // 已请求播放信息，尚未开始播放
+	PlaybackPlaying   PlaybackState = "Playing"   // 播放中
+	PlaybackPaused    PlaybackState = "Paused"    // 已暂停
+	PlaybackStopped   PlaybackState = "Stopped"   // 已停止
+)
+
+type PlaybackOutcome string // Strm 播放结果
+
+const (
+	PlaybackRedirected PlaybackOutcome = "Redirected" // 已重定向至 Strm 目标地址
+	PlaybackFailed     PlaybackOutcome = "Failed"     // 解析 Strm 目标地址失败
+)
+
+type PolicyAction string // Strm 播放访问策略动作
+
+const (
+	PolicyAllow     PolicyAction = "Allow"     // 按配置处理
+	PolicyDeny      PolicyAction = "Deny"      // 禁止播放
+	PolicyRedirect  PolicyAction = "Redirect"  // 强制直链播放并重定向（忽略 TransCode 设置）
+	PolicyProxy     PolicyAction = "Proxy"     // 由 MediaWarp 代理视频流，不向客户端暴露直链
+	PolicyTranscode PolicyAction = "Transcode" // 强制由媒体服务器转码，不提供直链
+)
+
+type QuotaPeriod string // 流量配额周期
+
+const (
+	QuotaDaily   QuotaPeriod = "Daily"   // 每天零点重置
+	QuotaMonthly QuotaPeriod = "Monthly" // 每月一日零点重置
+)
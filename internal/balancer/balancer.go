@@ -0,0 +1,279 @@
+package balancer
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/logging"
+	"MediaWarp/internal/metrics"
+	"MediaWarp/utils"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const failureCooldown = 10 * time.Second // 请求失败后暂停使用该地址的时间，期间健康检查成功时立即恢复
+
+var ErrNoBackend = errors.New("媒体服务器地址池为空")
+
+// 媒体服务器地址
+type Backend struct {
+	URL       *url.URL
+	healthy   atomic.Bool  // 最近一次健康检查的结果
+	downUntil atomic.Int64 // 请求失败后暂停使用的截止时间（Unix 纳秒）
+}
+
+// 判断地址是否可用
+func (backend *Backend) Available() bool {
+	return backend.healthy.Load() && time.Now().UnixNano() >= backend.downUntil.Load()
+}
+
+// 媒体服务器地址池
+//
+// 由 ADDR 和 Backups 组成，实现 http.RoundTripper：将请求转发至选择的地址，连接失败时切换至下一个可用的地址
+// 反向代理和媒体服务器 API 客户端共用同一个地址池
+type Pool struct {
+	name      string // 媒体服务器名称，用于日志
+	backends  []*Backend
+	strategy  constants.BalanceStrategy
+	setting   config.HealthCheckSetting
+	transport http.RoundTripper
+}
+
+// 创建地址池
+//
+// 所有地址初始时均视为可用
+func New(name string, setting config.MediaServerSetting) (*Pool, error) {
+	addrs := append([]string{setting.ADDR}, setting.Backups...)
+	pool := &Pool{
+		name:      name,
+		backends:  make([]*Backend, 0, len(addrs)),
+		strategy:  setting.Balance,
+		setting:   setting.HealthCheck,
+		transport: http.DefaultTransport,
+	}
+	for _, addr := range addrs {
+		target, err := url.Parse(utils.GetEndpoint(addr))
+		if err != nil {
+			return nil, fmt.Errorf("错误的媒体服务器地址 %s：%w", addr, err)
+		}
+		backend := &Backend{URL: target}
+		backend.healthy.Store(true)
+		pool.backends = append(pool.backends, backend)
+	}
+	if len(pool.backends) == 0 {
+		return nil, ErrNoBackend
+	}
+	return pool, nil
+}
+
+// 所有地址
+//
+// 第一个为 ADDR，其余按 Backups 的配置顺序排列
+func (pool *Pool) Backends() []*Backend {
+	return pool.backends
+}
+
+// 按尝试顺序排列的地址
+//
+// Failover 按配置顺序排列；Sticky 按设备 ID 的哈希值（rendezvous hashing）排列，某个地址不可用时只影响原本使用该地址的设备
+// 可用的地址在前，全部不可用时仍依次尝试
+func (pool *Pool) candidates(key string) []*Backend {
+	backends := slices.Clone(pool.backends)
+	if pool.strategy == constants.BalanceSticky && key != "" && len(backends) > 1 {
+		weights := make(map[*Backend]uint64, len(backends))
+		for _, backend := range backends {
+			hash := fnv.New64a()
+			io.WriteString(hash, backend.URL.String())
+			io.WriteString(hash, key)
+			weights[backend] = fmix64(hash.Sum64())
+		}
+		slices.SortStableFunc(backends, func(a, b *Backend) int { return cmp.Compare(weights[b], weights[a]) })
+	}
+	available := make(map[*Backend]bool, len(backends))
+	for _, backend := range backends {
+		available[backend] = backend.Available()
+	}
+	slices.SortStableFunc(backends, func(a, b *Backend) int {
+		switch {
+		case available[a] == available[b]:
+			return 0
+		case available[a]:
+			return -1
+		default:
+			return 1
+		}
+	})
+	return backends
+}
+
+// 选择请求使用的地址
+//
+// key 为客户端的设备 ID，为空时按 Failover 选择
+func (pool *Pool) Pick(key string) *Backend {
+	return pool.candidates(key)[0]
+}
+
+// 将请求的目标地址修改为 backend
+//
+// Host 请求头与 ADDR 相同时（Forwarded.Host 为 Upstream）一并修改
+func (pool *Pool) Rewrite(req *http.Request, backend *Backend) {
+	if req.Host == pool.backends[0].URL.Host {
+		req.Host = backend.URL.Host
+	}
+	req.URL.Scheme = backend.URL.Scheme
+	req.URL.Host = backend.URL.Host
+}
+
+// 按客户端的设备 ID 选择地址并修改请求
+//
+// 用于 WebSocket 等无法在连接失败时重试的请求
+func (pool *Pool) Route(req *http.Request) {
+	pool.Rewrite(req, pool.Pick(utils.GetClientInfo(req).DeviceID))
+}
+
+// 转发请求
+//
+// 按客户端的设备 ID 选择地址，请求失败时暂停使用该地址并依次尝试其余地址
+// 仅无请求体的 GET、HEAD、OPTIONS 请求在任意错误后重试，其余请求只在建立连接失败（请求尚未发送）时重试
+func (pool *Pool) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(pool.backends) == 1 {
+		return pool.transport.RoundTrip(req)
+	}
+	retryable := isSafeMethod(req.Method) && (req.Body == nil || req.Body == http.NoBody)
+	var err error
+	for _, backend := range pool.candidates(utils.GetClientInfo(req).DeviceID) {
+		outReq := req.Clone(req.Context())
+		pool.Rewrite(outReq, backend)
+		var resp *http.Response
+		resp, err = pool.transport.RoundTrip(outReq)
+		if err == nil {
+			return resp, nil
+		}
+		if req.Context().Err() != nil { // 客户端取消请求，与地址是否可用无关
+			return nil, err
+		}
+		pool.markDown(backend, err)
+		if !retryable && !isDialError(err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// 判断请求方法是否可以安全重放
+func isSafeMethod(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// 判断是否为建立连接失败
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// 获取使用地址池的 HTTP 客户端
+func (pool *Pool) Client() *http.Client {
+	return &http.Client{Transport: pool}
+}
+
+// 请求失败后暂停使用地址
+func (pool *Pool) markDown(backend *Backend, err error) {
+	if backend.Available() {
+		logging.Warningf("媒体服务器%s地址 %s 请求失败，暂停使用 %s：%s", pool.displayName(), backend.URL, failureCooldown, err)
+	}
+	backend.downUntil.Store(time.Now().Add(failureCooldown).UnixNano())
+	metrics.MediaServerFailovers.Inc(backend.URL.String())
+}
+
+// 日志中的媒体服务器名称
+func (pool *Pool) displayName() string {
+	if pool.name == "" {
+		return ""
+	}
+	return " " + pool.name + " "
+}
+
+// 定期检查所有地址是否可用
+//
+// 只有一个地址时不检查；ctx 取消时停止
+func (pool *Pool) RunHealthCheck(ctx context.Context) {
+	if len(pool.backends) < 2 {
+		return
+	}
+	ticker := time.NewTicker(pool.setting.Interval)
+	defer ticker.Stop()
+	for {
+		pool.checkAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 检查所有地址
+func (pool *Pool) checkAll(ctx context.Context) {
+	for _, backend := range pool.backends {
+		err := pool.check(ctx, backend)
+		healthy := err == nil
+		if healthy {
+			backend.downUntil.Store(0)
+		}
+		if backend.healthy.Swap(healthy) != healthy {
+			if healthy {
+				logging.Infof("媒体服务器%s地址 %s 已恢复", pool.displayName(), backend.URL)
+			} else {
+				logging.Warningf("媒体服务器%s地址 %s 健康检查失败：%s", pool.displayName(), backend.URL, err)
+			}
+		}
+	}
+}
+
+// 检查地址是否可用
+//
+// 请求无需认证的 /System/Info/Public 接口
+func (pool *Pool) check(ctx context.Context, backend *Backend) error {
+	ctx, cancel := context.WithTimeout(ctx, pool.setting.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(backend.URL.String(), "/")+"/System/Info/Public", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := pool.transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("/System/Info/Public 响应状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// 打散哈希值（MurmurHash3 的 fmix64）
+//
+// FNV 的雪崩效应较差，地址只有端口不同时各设备会选择同一个地址
+func fmix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
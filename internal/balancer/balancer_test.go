@@ -0,0 +1,165 @@
+package balancer_test
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/balancer"
+	"MediaWarp/internal/config"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 返回服务器名称的测试服务器
+func newServer(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		io.WriteString(rw, name)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func get(t *testing.T, pool *balancer.Pool, target string, deviceID string) string {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, target+"/System/Info", nil)
+	if deviceID != "" {
+		req.Header.Set("X-Emby-Device-Id", deviceID)
+	}
+	resp, err := pool.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestFailover(t *testing.T) {
+	primary := newServer(t, "primary")
+	backup := newServer(t, "backup")
+	pool, err := balancer.New("", config.MediaServerSetting{
+		ADDR:    primary.URL,
+		Backups: []string{backup.URL},
+		Balance: constants.BalanceFailover,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := get(t, pool, primary.URL, ""); got != "primary" {
+		t.Errorf("响应 %s，期望 primary", got)
+	}
+	primary.Close()
+	if got := get(t, pool, primary.URL, ""); got != "backup" {
+		t.Errorf("主地址不可用时响应 %s，期望 backup", got)
+	}
+	if pool.Backends()[0].Available() {
+		t.Error("请求失败后应暂停使用主地址")
+	}
+}
+
+// 只有可以安全重放的请求在响应中途失败后重试
+func TestRetryAfterResponseFailure(t *testing.T) {
+	cases := []struct {
+		method string
+		retry  bool
+	}{
+		{method: http.MethodGet, retry: true},
+		{method: http.MethodDelete, retry: false},
+		{method: http.MethodPost, retry: false},
+	}
+	for _, c := range cases {
+		t.Run(c.method, func(t *testing.T) {
+			var primaryHits, backupHits atomic.Int32
+			primary := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				primaryHits.Add(1)
+				conn, _, err := rw.(http.Hijacker).Hijack() // 已收到请求，未响应即断开连接
+				if err == nil {
+					conn.Close()
+				}
+			}))
+			t.Cleanup(primary.Close)
+			backup := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				backupHits.Add(1)
+			}))
+			t.Cleanup(backup.Close)
+			pool, err := balancer.New("", config.MediaServerSetting{
+				ADDR:    primary.URL,
+				Backups: []string{backup.URL},
+				Balance: constants.BalanceFailover,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, _ := http.NewRequest(c.method, primary.URL+"/Items/1", nil)
+			resp, err := pool.Client().Do(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if c.retry != (err == nil) {
+				t.Errorf("请求错误为 %v，期望重试 %t", err, c.retry)
+			}
+			if got := backupHits.Load(); got != 0 != c.retry {
+				t.Errorf("备用地址收到 %d 次请求，期望重试 %t", got, c.retry)
+			}
+			if got := primaryHits.Load(); got != 1 {
+				t.Errorf("主地址收到 %d 次请求，期望 1 次", got)
+			}
+		})
+	}
+}
+
+func TestSticky(t *testing.T) {
+	servers := []*httptest.Server{newServer(t, "a"), newServer(t, "b"), newServer(t, "c")}
+	pool, err := balancer.New("", config.MediaServerSetting{
+		ADDR:    servers[0].URL,
+		Backups: []string{servers[1].URL, servers[2].URL},
+		Balance: constants.BalanceSticky,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	used := make(map[string]bool)
+	for i := range 20 {
+		deviceID := fmt.Sprintf("device-%d", i)
+		first := get(t, pool, servers[0].URL, deviceID)
+		if got := get(t, pool, servers[0].URL, deviceID); got != first {
+			t.Errorf("设备 %s 先后使用了 %s 和 %s", deviceID, first, got)
+		}
+		used[first] = true
+	}
+	if len(used) < 2 {
+		t.Errorf("20 个设备只使用了 %d 个地址", len(used))
+	}
+}
+
+func TestRunHealthCheckStops(t *testing.T) {
+	pool, err := balancer.New("", config.MediaServerSetting{
+		ADDR:        newServer(t, "a").URL,
+		Backups:     []string{newServer(t, "b").URL},
+		HealthCheck: config.HealthCheckSetting{Interval: time.Hour, Timeout: time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pool.RunHealthCheck(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("ctx 取消后健康检查未停止")
+	}
+}
@@ -0,0 +1,275 @@
+package clientfilter
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/logging"
+	"MediaWarp/utils"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	ErrInvalidMode    = errors.New("错误的客户端过滤模式，可选值：WhiteList、BlackList")
+	ErrInvalidAction  = errors.New("错误的客户端过滤规则动作，可选值：Allow、Deny、Log")
+	ErrInvalidVersion = errors.New("错误的客户端版本号")
+	ErrEmptyRule      = errors.New("客户端过滤规则未设置任何条件")
+
+	ErrRuleDenied     = errors.New("客户端被过滤规则拦截")
+	ErrNotInWhiteList = errors.New("客户端不在白名单中")
+	ErrInBlackList    = errors.New("客户端在黑名单中")
+	ErrUnknownClient  = errors.New("未提供 User-Agent 和客户端名称")
+)
+
+// 客户端过滤规则
+type Rule struct {
+	userAgent  *regexp.Regexp
+	client     *regexp.Regexp
+	device     *regexp.Regexp
+	deviceID   *regexp.Regexp
+	minVersion []int
+	maxVersion []int
+	action     constants.ClientFilterAction
+}
+
+// 编译客户端过滤规则
+func NewRule(setting config.ClientFilterRule) (Rule, error) {
+	rule := Rule{action: setting.Action}
+	switch setting.Action {
+	case constants.ClientFilterAllow, constants.ClientFilterDeny, constants.ClientFilterLog:
+	default:
+		return rule, fmt.Errorf("%w：%s", ErrInvalidAction, setting.Action)
+	}
+
+	patterns := []struct {
+		target  **regexp.Regexp
+		pattern string
+	}{
+		{&rule.userAgent, setting.UserAgent},
+		{&rule.client, setting.Client},
+		{&rule.device, setting.Device},
+		{&rule.deviceID, setting.DeviceID},
+	}
+	for _, item := range patterns {
+		if item.pattern == "" {
+			continue
+		}
+		reg, err := regexp.Compile("(?i)" + item.pattern)
+		if err != nil {
+			return rule, fmt.Errorf("错误的正则表达式 %s：%w", item.pattern, err)
+		}
+		*item.target = reg
+	}
+
+	var err error
+	if setting.MinVersion != "" {
+		if rule.minVersion, err = parseVersion(setting.MinVersion); err != nil {
+			return rule, err
+		}
+	}
+	if setting.MaxVersion != "" {
+		if rule.maxVersion, err = parseVersion(setting.MaxVersion); err != nil {
+			return rule, err
+		}
+	}
+	if rule.userAgent == nil && rule.client == nil && rule.device == nil && rule.deviceID == nil && rule.minVersion == nil && rule.maxVersion == nil {
+		return rule, ErrEmptyRule
+	}
+	return rule, nil
+}
+
+// 判断请求是否匹配规则
+//
+// 设置了版本范围但客户端未提供版本号或版本号无法解析时不匹配
+func (rule Rule) match(userAgent string, client utils.ClientInfo) bool {
+	if rule.userAgent != nil && !rule.userAgent.MatchString(userAgent) {
+		return false
+	}
+	if rule.client != nil && !rule.client.MatchString(client.Client) {
+		return false
+	}
+	if rule.device != nil && !rule.device.MatchString(client.Device) {
+		return false
+	}
+	if rule.deviceID != nil && !rule.deviceID.MatchString(client.DeviceID) {
+		return false
+	}
+	if rule.minVersion == nil && rule.maxVersion == nil {
+		return true
+	}
+	version, err := parseVersion(client.Version)
+	if err != nil {
+		return false
+	}
+	if rule.minVersion != nil && compareVersion(version, rule.minVersion) < 0 {
+		return false
+	}
+	if rule.maxVersion != nil && compareVersion(version, rule.maxVersion) > 0 {
+		return false
+	}
+	return true
+}
+
+// 解析版本号
+//
+// 按 . 分割，每段取开头的数字，如 4.8.0.80-beta 解析为 [4 8 0 80]
+func parseVersion(version string) ([]int, error) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if version == "" {
+		return nil, ErrInvalidVersion
+	}
+	parts := strings.Split(version, ".")
+	result := make([]int, 0, len(parts))
+	for _, part := range parts {
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("%w：%s", ErrInvalidVersion, version)
+		}
+		n, err := strconv.Atoi(part[:end])
+		if err != nil {
+			return nil, fmt.Errorf("%w：%s", ErrInvalidVersion, version)
+		}
+		result = append(result, n)
+		if end < len(part) { // 忽略预发布标识等后缀
+			break
+		}
+	}
+	return result, nil
+}
+
+// 比较版本号
+//
+// 缺少的段视为 0
+func compareVersion(a, b []int) int {
+	for i := 0; i < max(len(a), len(b)); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// 客户端过滤器
+type Filter struct {
+	mode         constants.FliterMode
+	clientList   []string
+	allowUnknown bool
+	rules        []Rule
+}
+
+// 创建客户端过滤器
+func New(setting config.ClientFilterSetting) (*Filter, error) {
+	switch setting.Mode {
+	case constants.WHITELIST, constants.BLACKLIST:
+	default:
+		return nil, fmt.Errorf("%w：%s", ErrInvalidMode, setting.Mode)
+	}
+	filter := &Filter{
+		mode:         setting.Mode,
+		clientList:   setting.ClientList,
+		allowUnknown: setting.AllowUnknown,
+		rules:        make([]Rule, 0, len(setting.Rules)),
+	}
+	for i, ruleSetting := range setting.Rules {
+		rule, err := NewRule(ruleSetting)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 条客户端过滤规则：%w", i+1, err)
+		}
+		filter.rules = append(filter.rules, rule)
+	}
+	return filter, nil
+}
+
+// 检查客户端是否允许访问
+//
+// 按顺序匹配规则，Allow、Deny 规则匹配后立即返回结果，Log 规则仅记录日志
+// 未匹配 Allow、Deny 规则时，检查 User-Agent 和客户端名称是否在名单中
+func (filter *Filter) Check(userAgent string, client utils.ClientInfo) error {
+	for i, rule := range filter.rules {
+		if !rule.match(userAgent, client) {
+			continue
+		}
+		switch rule.action {
+		case constants.ClientFilterAllow:
+			return nil
+		case constants.ClientFilterDeny:
+			return fmt.Errorf("%w：第 %d 条规则", ErrRuleDenied, i+1)
+		case constants.ClientFilterLog:
+			logging.Infof("客户端匹配第 %d 条过滤规则，User-Agent：%s，客户端：%s %s，设备：%s（%s）", i+1, userAgent, client.Client, client.Version, client.Device, client.DeviceID)
+		}
+	}
+
+	if userAgent == "" && client.Client == "" {
+		if filter.allowUnknown {
+			return nil
+		}
+		return ErrUnknownClient
+	}
+	listed := false
+	for _, item := range filter.clientList {
+		if strings.Contains(userAgent, item) || (client.Client != "" && strings.Contains(client.Client, item)) {
+			listed = true
+			break
+		}
+	}
+	switch {
+	case filter.mode == constants.WHITELIST && !listed:
+		return ErrNotInWhiteList
+	case filter.mode == constants.BLACKLIST && listed:
+		return ErrInBlackList
+	}
+	return nil
+}
+
+var current atomic.Pointer[Filter] // 全局客户端过滤器
+
+// 初始化全局客户端过滤器
+func Init() error {
+	filter, err := Build(config.Get())
+	if err != nil {
+		return err
+	}
+	Set(filter)
+	return nil
+}
+
+// 按配置创建客户端过滤器
+//
+// 未启用时返回 nil
+func Build(settings *config.Settings) (*Filter, error) {
+	if !settings.ClientFilter.Enable {
+		return nil, nil
+	}
+	return New(settings.ClientFilter)
+}
+
+// 替换全局客户端过滤器
+//
+// 重新加载或修改配置时调用以更新规则
+func Set(filter *Filter) {
+	current.Store(filter)
+}
+
+// 获取全局客户端过滤器
+//
+// 未启用时返回 nil
+func Get() *Filter {
+	return current.Load()
+}
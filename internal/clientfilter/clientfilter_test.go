@@ -0,0 +1,69 @@
+package clientfilter_test
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/clientfilter"
+	"MediaWarp/internal/config"
+	"MediaWarp/utils"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestFilterCheck(t *testing.T) {
+	filter, err := clientfilter.New(config.ClientFilterSetting{
+		Mode:       constants.BLACKLIST,
+		ClientList: []string{"Fileball"},
+		Rules: []config.ClientFilterRule{
+			{DeviceID: "^trusted-device$", Action: constants.ClientFilterAllow},
+			{Client: "^Infuse", MaxVersion: "7.7", Action: constants.ClientFilterDeny},
+			{UserAgent: "curl", Action: constants.ClientFilterLog},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		userAgent     string
+		authorization string
+		want          error
+	}{
+		"旧版本 Infuse 被规则拦截": {"", `MediaBrowser Client="Infuse-Direct", Device="iPhone", DeviceId="abc", Version="7.6.3"`, clientfilter.ErrRuleDenied},
+		"新版本 Infuse 放行":    {"", `MediaBrowser Client="Infuse-Direct", Device="iPhone", DeviceId="abc", Version="7.8.1"`, nil},
+		"可信设备优先放行":         {"Fileball/1.0", `MediaBrowser Client="Fileball", DeviceId="trusted-device", Version="1.0"`, nil},
+		"黑名单匹配客户端名称":       {"", `MediaBrowser Client="Fileball", DeviceId="abc", Version="1.0"`, clientfilter.ErrInBlackList},
+		"仅记录日志的规则继续匹配":     {"curl/8.0", "", nil},
+		"未知客户端":            {"", "", clientfilter.ErrUnknownClient},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "http://localhost/emby/Items", nil)
+			if tt.authorization != "" {
+				req.Header.Set("X-Emby-Authorization", tt.authorization)
+			}
+			if got := filter.Check(tt.userAgent, utils.GetClientInfo(req)); !errors.Is(got, tt.want) {
+				t.Errorf("Check() = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewInvalidSetting(t *testing.T) {
+	tests := map[string]struct {
+		setting config.ClientFilterSetting
+		want    error
+	}{
+		"错误的模式":  {config.ClientFilterSetting{Mode: "GreyList"}, clientfilter.ErrInvalidMode},
+		"错误的动作":  {config.ClientFilterSetting{Mode: constants.BLACKLIST, Rules: []config.ClientFilterRule{{Client: "Infuse", Action: "Block"}}}, clientfilter.ErrInvalidAction},
+		"错误的版本号": {config.ClientFilterSetting{Mode: constants.BLACKLIST, Rules: []config.ClientFilterRule{{MinVersion: "latest", Action: constants.ClientFilterDeny}}}, clientfilter.ErrInvalidVersion},
+		"空规则":    {config.ClientFilterSetting{Mode: constants.BLACKLIST, Rules: []config.ClientFilterRule{{Action: constants.ClientFilterDeny}}}, clientfilter.ErrEmptyRule},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := clientfilter.New(tt.setting); !errors.Is(err, tt.want) {
+				t.Errorf("New() = %v，期望 %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -2,10 +2,15 @@ package config
 
 import (
 	"MediaWarp/constants"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/spf13/viper"
@@ -21,14 +26,33 @@ var (
 		Arch:       runtime.GOARCH,
 	}
 
-	Port         int                 // MediaWarp开放端口
-	MediaServer  MediaServerSetting  // 上游媒体服务器设置
-	Logger       LoggerSetting       // 日志设置
-	Web          WebSetting          // Web服务器设置
-	ClientFilter ClientFilterSetting // 客户端过滤设置
-	HTTPStrm     HTTPStrmSetting     // HTTPSTRM设置
-	AlistStrm    AlistStrmSetting    // AlistStrm设置
-	Subtitle     SubtitleSetting     // 字幕设置
+	current atomic.Pointer[Settings] // 当前生效的配置
+)
+
+// 需要重启才能生效的顶级配置项
+//
+// 重新加载配置时保持运行中的值
+var restartRequiredKeys = []string{"Port", "Listeners", "TLS", "MediaServer", "Upstreams", "Logger", "Web", "Subtitle", "History", "Forwarded"}
+
+var (
+	ErrAdminCredentialsMissing = errors.New("已启用管理后台，但未设置 Admin.Username 或 Admin.Password")
+	ErrInvalidPolicyAction     = errors.New("错误的访问策略动作，可选值：Allow、Deny、Redirect、Proxy、Transcode")
+	ErrInvalidQuotaPeriod      = errors.New("错误的流量配额周期，可选值：Daily、Monthly")
+	ErrInvalidACMEChallenge    = errors.New("错误的 ACME 验证方式，可选值：HTTP-01、TLS-ALPN-01")
+	ErrACMEDomainsMissing      = errors.New("已启用 ACME，但未设置 TLS.ACME.Domains")
+	ErrTLSCertificateMissing   = errors.New("已启用 HTTPS 监听，但未设置 TLS.CertFile 和 TLS.KeyFile，也未启用 TLS.ACME")
+	ErrInvalidHostRewrite      = errors.New("错误的 Host 请求头设置，可选值：Client、Upstream、Custom")
+	ErrCustomHostMissing       = errors.New("Forwarded.Host 为 Custom，但未设置 Forwarded.CustomHost")
+	ErrUpstreamNameMissing     = errors.New("未设置上游媒体服务器的 Name")
+	ErrUpstreamNameDuplicate   = errors.New("上游媒体服务器的 Name 重复")
+	ErrUpstreamMatchMissing    = errors.New("未设置上游媒体服务器的匹配条件（Hosts、Ports、PathPrefix）")
+	ErrInvalidBalanceStrategy  = errors.New("错误的媒体服务器地址选择策略，可选值：Failover、Sticky")
+	ErrEmptyBackupAddress      = errors.New("媒体服务器的 Backups 中包含空地址")
+)
+
+const (
+	defaultHealthCheckInterval = 30 * time.Second // 媒体服务器健康检查的默认间隔
+	defaultHealthCheckTimeout  = 5 * time.Second  // 媒体服务器健康检查的默认超时时间
 )
 
 // 获取版本信息
@@ -63,22 +87,37 @@ func LogDir() string {
 	return filepath.Join(RootDir(), "logs")
 }
 
-// 获取日志目录
+// 数据目录
 //
-// 带有日期
-// ./logs/2024-9-29
-func LogDirWithDate() string {
-	return filepath.Join(LogDir(), time.Now().Format("2006-1-2"))
+// 存放播放历史等持久化数据
+// ./data
+func DataDir() string {
+	return filepath.Join(RootDir(), "data")
+}
+
+// 播放历史数据库路径
+func HistoryDBPath() string {
+	return filepath.Join(DataDir(), "history.db")
+}
+
+// IP 地理位置数据库路径
+//
+// 未设置时返回空字符串
+func GeoIPDatabasePath(setting IPFilterSetting) string {
+	if setting.GeoIPDatabase == "" || filepath.IsAbs(setting.GeoIPDatabase) {
+		return setting.GeoIPDatabase
+	}
+	return filepath.Join(DataDir(), setting.GeoIPDatabase)
 }
 
 // 访问日志文件路径
 func AccessLogPath() string {
-	return filepath.Join(LogDirWithDate(), "access.log")
+	return filepath.Join(LogDir(), "access.log")
 }
 
 // 服务日志文件路径
 func ServiceLogPath() string {
-	return filepath.Join(LogDirWithDate(), "service.log")
+	return filepath.Join(LogDir(), "service.log")
 }
 
 // 静态资源文件目录
@@ -92,14 +131,35 @@ func CostomDir() string {
 //
 // 监听所有网卡
 func ListenAddr() string {
-	return fmt.Sprintf(":%d", Port)
+	return fmt.Sprintf(":%d", Get().Port)
+}
+
+// MediaWarp监听设置
+//
+// 未设置 Listeners 时使用 HTTP 监听 ListenAddr()
+func ListenerSettings() []ListenerSetting {
+	if listeners := Get().Listeners; len(listeners) > 0 {
+		return listeners
+	}
+	return []ListenerSetting{{Addr: ListenAddr()}}
+}
+
+// ACME 证书缓存目录
+func ACMECacheDir() string {
+	cacheDir := Get().TLS.ACME.CacheDir
+	if filepath.IsAbs(cacheDir) {
+		return cacheDir
+	}
+	return filepath.Join(DataDir(), cacheDir)
 }
 
 // 初始化configManager
 func Init(path string) error {
-	if err := loadConfig(path); err != nil {
+	settings, err := loadConfig(path)
+	if err != nil {
 		return err
 	}
+	Set(settings)
 	if err := createDir(); err != nil {
 		return err
 	}
@@ -107,7 +167,7 @@ func Init(path string) error {
 }
 
 // 读取并解析配置文件
-func loadConfig(path string) error {
+func loadConfig(path string) (*Settings, error) {
 	if path != "" {
 		viper.SetConfigFile(path)
 	} else {
@@ -115,36 +175,336 @@ func loadConfig(path string) error {
 		viper.SetConfigName("config")
 	}
 
+	setDefault()
 	if err := viper.ReadInConfig(); err != nil {
-		return fmt.Errorf("读取配置文件失败: %v", err)
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	if err := viper.MergeConfigMap(viper.AllSettings()); err != nil { // UnmarshalKey 不会合并已存在配置项下的嵌套默认值，需将默认值合并到配置中
+		return nil, fmt.Errorf("合并默认配置失败: %v", err)
+	}
+
+	settings := &Settings{Port: viper.GetInt("Port")}
+	if err := viper.UnmarshalKey("Listeners", &settings.Listeners); err != nil {
+		return nil, fmt.Errorf("ListenerSetting  解析失败, %v", err)
+	}
+	if err := viper.UnmarshalKey("TLS", &settings.TLS); err != nil {
+		return nil, fmt.Errorf("TLSSetting  解析失败, %v", err)
+	}
+	if err := checkTLS(settings); err != nil {
+		return nil, err
+	}
+	if err := viper.UnmarshalKey("Shutdown", &settings.Shutdown); err != nil {
+		return nil, fmt.Errorf("ShutdownSetting  解析失败, %v", err)
+	}
+	if err := viper.UnmarshalKey("MediaServer", &settings.MediaServer); err != nil {
+		return nil, fmt.Errorf("MediaServerSetting  解析失败, %v", err)
+	}
+	if err := checkMediaServer(&settings.MediaServer); err != nil {
+		return nil, err
+	}
+	if err := viper.UnmarshalKey("Upstreams", &settings.Upstreams); err != nil {
+		return nil, fmt.Errorf("UpstreamSetting  解析失败, %v", err)
+	}
+	if err := checkUpstreams(settings.Upstreams); err != nil {
+		return nil, err
+	}
+
+	if err := viper.UnmarshalKey("Logger", &settings.Logger); err != nil {
+		return nil, fmt.Errorf("LoggerSetting 解析失败, %v", err)
+	}
+	if err := viper.UnmarshalKey("Web", &settings.Web); err != nil {
+		return nil, fmt.Errorf("WebSetting  解析失败, %v", err)
+	}
+	if err := viper.UnmarshalKey("ClientFilter", &settings.ClientFilter); err != nil {
+		return nil, fmt.Errorf("ClientFilterSetting  解析失败, %v", err)
+	}
+	if err := viper.UnmarshalKey("HTTPStrm", &settings.HTTPStrm); err != nil {
+		return nil, fmt.Errorf("HTTPStrmSetting  解析失败, %v", err)
+	}
+	if err := viper.UnmarshalKey("AlistStrm", &settings.AlistStrm); err != nil {
+		return nil, fmt.Errorf("AlistStrmSetting  解析失败, %v", err)
+	}
+	if err := viper.UnmarshalKey("Subtitle", &settings.Subtitle); err != nil {
+		return nil, fmt.Errorf("SubtitleSetting  解析失败, %v", err)
+	}
+	if err := viper.UnmarshalKey("StrmScan", &settings.StrmScan); err != nil {
+		return nil, fmt.Errorf("StrmScanSetting  解析失败, %v", err)
+	}
+	if err := viper.UnmarshalKey("History", &settings.History); err != nil {
+		return nil, fmt.Errorf("HistorySetting  解析失败, %v", err)
+	}
+	if err := viper.UnmarshalKey("Admin", &settings.Admin); err != nil {
+		return nil, fmt.Errorf("AdminSetting  解析失败, %v", err)
+	}
+	if err := viper.UnmarshalKey("API", &settings.API); err != nil {
+		return nil, fmt.Errorf("APISetting  解析失败, %v", err)
+	}
+	if err := viper.UnmarshalKey("Auth", &settings.Auth); err != nil {
+		return nil, fmt.Errorf("AuthSetting  解析失败, %v", err)
+	}
+	if err := viper.UnmarshalKey("Policy", &settings.Policy); err != nil {
+		return nil, fmt.Errorf("PolicySetting  解析失败, %v", err)
+	}
+	if err := checkPolicy(settings.Policy); err != nil {
+		return nil, err
+	}
+	if err := viper.UnmarshalKey("IPFilter", &settings.IPFilter); err != nil {
+		return nil, fmt.Errorf("IPFilterSetting  解析失败, %v", err)
+	}
+	if err := viper.UnmarshalKey("RateLimit", &settings.RateLimit); err != nil {
+		return nil, fmt.Errorf("RateLimitSetting  解析失败, %v", err)
+	}
+	if err := viper.UnmarshalKey("WebSocket", &settings.WebSocket); err != nil {
+		return nil, fmt.Errorf("WebSocketSetting  解析失败, %v", err)
+	}
+	if err := viper.UnmarshalKey("Forwarded", &settings.Forwarded); err != nil {
+		return nil, fmt.Errorf("ForwardedSetting  解析失败, %v", err)
 	}
+	if len(settings.Forwarded.TrustedProxies) == 0 { // 兼容旧版本的 IPFilter.TrustedProxies
+		settings.Forwarded.TrustedProxies = settings.IPFilter.TrustedProxies
+	}
+	if err := checkForwarded(settings.Forwarded); err != nil {
+		return nil, err
+	}
+	if err := viper.UnmarshalKey("Limit", &settings.Limit); err != nil {
+		return nil, fmt.Errorf("LimitSetting  解析失败, %v", err)
+	}
+	switch settings.Limit.QuotaPeriod {
+	case constants.QuotaDaily, constants.QuotaMonthly:
+	default:
+		return nil, fmt.Errorf("%w：%s", ErrInvalidQuotaPeriod, settings.Limit.QuotaPeriod)
+	}
+	if settings.Admin.Enable && (settings.Admin.Username == "" || settings.Admin.Password == "") {
+		return nil, ErrAdminCredentialsMissing
+	}
+	return settings, nil
+}
+
+// 重新读取配置文件
+//
+// 返回解析并检查通过的新配置，不会发布，由调用方在依赖配置的组件初始化成功后调用 Set 发布
+// 需要重启才能生效的配置项保持运行中的值，被修改的配置项在 restartRequired 中返回
+func Reload() (settings *Settings, restartRequired []string, err error) {
+	if settings, err = loadConfig(viper.ConfigFileUsed()); err != nil {
+		return nil, nil, err
+	}
+	running, next := reflect.ValueOf(Get()).Elem(), reflect.ValueOf(settings).Elem()
+	restartRequired = []string{}
+	for _, key := range restartRequiredKeys {
+		if !reflect.DeepEqual(running.FieldByName(key).Interface(), next.FieldByName(key).Interface()) {
+			restartRequired = append(restartRequired, key)
+			next.FieldByName(key).Set(running.FieldByName(key))
+		}
+	}
+	return settings, restartRequired, nil
+}
+
+// 获取当前生效的配置
+//
+// 返回的配置不可修改，修改配置时应先调用 Clone 复制；未初始化时返回空配置
+func Get() *Settings {
+	if settings := current.Load(); settings != nil {
+		return settings
+	}
+	return &Settings{}
+}
+
+// 发布配置
+//
+// 之后调用 Get 的读取方获取到新的配置
+func Set(settings *Settings) {
+	current.Store(settings)
+}
+
+// 复制当前生效的配置
+//
+// 浅复制，修改切片、map 类型的配置项时需整体替换
+func Clone() *Settings {
+	settings := *Get()
+	return &settings
+}
+
+// 当前生效的配置
+//
+// 键为配置文件中的顶级配置项
+func Snapshot() map[string]any {
+	return Get().Snapshot()
+}
+
+// 配置快照
+//
+// 键为配置文件中的顶级配置项
+func (settings *Settings) Snapshot() map[string]any {
+	return map[string]any{
+		"Port":         settings.Port,
+		"Listeners":    settings.Listeners,
+		"TLS":          settings.TLS,
+		"Shutdown":     settings.Shutdown,
+		"MediaServer":  settings.MediaServer,
+		"Upstreams":    settings.Upstreams,
+		"Logger":       settings.Logger,
+		"Web":          settings.Web,
+		"ClientFilter": settings.ClientFilter,
+		"HTTPStrm":     settings.HTTPStrm,
+		"AlistStrm":    settings.AlistStrm,
+		"Subtitle":     settings.Subtitle,
+		"StrmScan":     settings.StrmScan,
+		"History":      settings.History,
+		"Admin":        settings.Admin,
+		"API":          settings.API,
+		"Auth":         settings.Auth,
+		"Policy":       settings.Policy,
+		"Limit":        settings.Limit,
+		"IPFilter":     settings.IPFilter,
+		"RateLimit":    settings.RateLimit,
+		"WebSocket":    settings.WebSocket,
+		"Forwarded":    settings.Forwarded,
+	}
+}
 
-	Port = viper.GetInt("Port")
-	MediaServer.Type = constants.MediaServerType(viper.GetString("MediaServer.Type"))
-	MediaServer.ADDR = viper.GetString("MediaServer.ADDR")
-	MediaServer.AUTH = viper.GetString("MediaServer.AUTH")
+// 检查 TLS 设置是否完整
+func checkTLS(settings *Settings) error {
+	tls := settings.TLS
+	if tls.ACME.Enable {
+		switch tls.ACME.Challenge {
+		case constants.ACMEHTTP01, constants.ACMETLSALPN01:
+		default:
+			return fmt.Errorf("%w：%s", ErrInvalidACMEChallenge, tls.ACME.Challenge)
+		}
+		if len(tls.ACME.Domains) == 0 {
+			return ErrACMEDomainsMissing
+		}
+		return nil
+	}
+	for _, listener := range settings.Listeners {
+		if listener.TLS && (tls.CertFile == "" || tls.KeyFile == "") {
+			return ErrTLSCertificateMissing
+		}
+	}
+	return nil
+}
 
-	if err := viper.UnmarshalKey("Logger", &Logger); err != nil {
-		return fmt.Errorf("LoggerSetting 解析失败, %v", err)
+// 检查上游媒体服务器设置是否合法
+//
+// 同时规范化路径前缀：以 / 开头，不以 / 结尾
+func checkUpstreams(upstreams []UpstreamSetting) error {
+	names := make(map[string]struct{}, len(upstreams))
+	for i := range upstreams {
+		upstream := &upstreams[i]
+		if upstream.Name == "" {
+			return ErrUpstreamNameMissing
+		}
+		if _, ok := names[upstream.Name]; ok {
+			return fmt.Errorf("%w：%s", ErrUpstreamNameDuplicate, upstream.Name)
+		}
+		names[upstream.Name] = struct{}{}
+		if prefix := strings.Trim(upstream.PathPrefix, "/"); prefix != "" {
+			upstream.PathPrefix = "/" + prefix
+		} else {
+			upstream.PathPrefix = ""
+		}
+		if len(upstream.Hosts) == 0 && len(upstream.Ports) == 0 && upstream.PathPrefix == "" {
+			return fmt.Errorf("%w：%s", ErrUpstreamMatchMissing, upstream.Name)
+		}
+		if err := checkMediaServer(&upstream.MediaServerSetting); err != nil {
+			return fmt.Errorf("上游媒体服务器 %s：%w", upstream.Name, err)
+		}
 	}
-	if err := viper.UnmarshalKey("Web", &Web); err != nil {
-		return fmt.Errorf("WebSetting  解析失败, %v", err)
+	return nil
+}
+
+// 检查媒体服务器地址池设置是否合法
+//
+// 未设置的选择策略和健康检查设置使用默认值
+func checkMediaServer(setting *MediaServerSetting) error {
+	if setting.Balance == "" {
+		setting.Balance = constants.BalanceFailover
 	}
-	if err := viper.UnmarshalKey("ClientFilter", &ClientFilter); err != nil {
-		return fmt.Errorf("ClientFilterSetting  解析失败, %v", err)
+	switch setting.Balance {
+	case constants.BalanceFailover, constants.BalanceSticky:
+	default:
+		return fmt.Errorf("%w：%s", ErrInvalidBalanceStrategy, setting.Balance)
 	}
-	if err := viper.UnmarshalKey("HTTPStrm", &HTTPStrm); err != nil {
-		return fmt.Errorf("HTTPStrmSetting  解析失败, %v", err)
+	if slices.Contains(setting.Backups, "") {
+		return ErrEmptyBackupAddress
 	}
-	if err := viper.UnmarshalKey("AlistStrm", &AlistStrm); err != nil {
-		return fmt.Errorf("AlistStrmSetting  解析失败, %v", err)
+	if setting.HealthCheck.Interval <= 0 {
+		setting.HealthCheck.Interval = defaultHealthCheckInterval
 	}
-	if err := viper.UnmarshalKey("Subtitle", &Subtitle); err != nil {
-		return fmt.Errorf("SubtitleSetting  解析失败, %v", err)
+	if setting.HealthCheck.Timeout <= 0 {
+		setting.HealthCheck.Timeout = defaultHealthCheckTimeout
 	}
 	return nil
 }
 
+// 检查转发请求头设置是否合法
+func checkForwarded(forwarded ForwardedSetting) error {
+	switch forwarded.Host {
+	case constants.HostClient, constants.HostUpstream:
+	case constants.HostCustom:
+		if forwarded.CustomHost == "" {
+			return ErrCustomHostMissing
+		}
+	default:
+		return fmt.Errorf("%w：%s", ErrInvalidHostRewrite, forwarded.Host)
+	}
+	return nil
+}
+
+// 检查访问策略中的动作是否合法
+func checkPolicy(policy PolicySetting) error {
+	actions := []constants.PolicyAction{policy.Default}
+	for _, rule := range policy.Rules {
+		actions = append(actions, rule.Action)
+	}
+	for _, action := range actions {
+		switch action {
+		case constants.PolicyAllow, constants.PolicyDeny, constants.PolicyRedirect, constants.PolicyProxy, constants.PolicyTranscode:
+		default:
+			return fmt.Errorf("%w：%s", ErrInvalidPolicyAction, action)
+		}
+	}
+	return nil
+}
+
+// 设置配置项默认值
+//
+// 配置文件中未填写的配置项使用默认值
+func setDefault() {
+	viper.SetDefault("Shutdown.Delay", "5s")
+	viper.SetDefault("Shutdown.DrainTimeout", "30s")
+	viper.SetDefault("TLS.ACME.Challenge", constants.ACMETLSALPN01)
+	viper.SetDefault("TLS.ACME.CacheDir", "acme")
+	viper.SetDefault("Logger.Level", "Info")
+	viper.SetDefault("Logger.Format", constants.LogFormatText)
+	viper.SetDefault("Logger.Rotate.MaxSize", 100)
+	viper.SetDefault("Logger.Rotate.Interval", "24h")
+	viper.SetDefault("Logger.Rotate.MaxBackups", 7)
+	viper.SetDefault("Logger.Rotate.MaxAge", "168h")
+	viper.SetDefault("Logger.Rotate.Compress", true)
+	viper.SetDefault("ClientFilter.Mode", constants.BLACKLIST)
+	viper.SetDefault("HTTPStrm.FinalURLCache.DefaultTTL", "5m")
+	viper.SetDefault("HTTPStrm.FinalURLCache.MaxTTL", "1h")
+	viper.SetDefault("StrmScan.Concurrency", 8)
+	viper.SetDefault("StrmScan.SlowThreshold", "3s")
+	viper.SetDefault("History.Enable", true)
+	viper.SetDefault("History.Retention", "2160h")
+	viper.SetDefault("Policy.Default", constants.PolicyAllow)
+	viper.SetDefault("Limit.QuotaPeriod", constants.QuotaDaily)
+	viper.SetDefault("RateLimit.Global.Period", "1s")
+	viper.SetDefault("RateLimit.PerIP.Period", "1s")
+	viper.SetDefault("RateLimit.Auth.PerIP.Requests", 10)
+	viper.SetDefault("RateLimit.Auth.PerIP.Period", "1m")
+	viper.SetDefault("RateLimit.Auth.PerUsername.Requests", 5)
+	viper.SetDefault("RateLimit.Auth.PerUsername.Period", "1m")
+	viper.SetDefault("RateLimit.Ban.MaxFailures", 10)
+	viper.SetDefault("RateLimit.Ban.Window", "10m")
+	viper.SetDefault("RateLimit.Ban.Duration", "1h")
+	viper.SetDefault("WebSocket.IdleTimeout", "5m")
+	viper.SetDefault("Forwarded.Host", constants.HostClient)
+	viper.SetDefault("Auth.PublicPaths", []string{"/MediaWarp/version", "/MediaWarp/static"})
+}
+
 // 创建文件夹
 func createDir() error {
 	if err := os.MkdirAll(ConfigDir(), os.ModePerm); err != nil {
@@ -156,5 +516,8 @@ func createDir() error {
 	if err := os.MkdirAll(CostomDir(), os.ModePerm); err != nil {
 		return fmt.Errorf("创建自定义静态资源文件夹失败: %v", err)
 	}
+	if err := os.MkdirAll(DataDir(), os.ModePerm); err != nil {
+		return fmt.Errorf("创建数据文件夹失败: %v", err)
+	}
 	return nil
 }
@@ -0,0 +1,80 @@
+package config_test
+
+import (
+	"MediaWarp/internal/config"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeConfig(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `Port: 9000
+MediaServer:
+  Type: Emby
+  ADDR: http://127.0.0.1:8096
+Policy:
+  Enable: True
+  Groups:
+    vip: [alice]
+    kids: [bob]
+`)
+	if err := config.Init(path); err != nil {
+		t.Fatal(err)
+	}
+	running := config.Get()
+
+	t.Run("检查失败时保持原配置", func(t *testing.T) {
+		writeConfig(t, path, `Port: 9000
+Policy:
+  Enable: False
+Limit:
+  QuotaPeriod: Weekly
+`)
+		if _, _, err := config.Reload(); err == nil {
+			t.Fatal("错误的配置应返回错误")
+		}
+		if config.Get() != running || !config.Get().Policy.Enable {
+			t.Error("重新加载失败后配置不应改变")
+		}
+	})
+
+	t.Run("重新加载", func(t *testing.T) {
+		writeConfig(t, path, `Port: 9100
+MediaServer:
+  Type: Emby
+  ADDR: http://127.0.0.1:8097
+Policy:
+  Enable: True
+  Groups:
+    vip: [alice, carol]
+`)
+		settings, restartRequired, err := config.Reload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Get() != running {
+			t.Error("Reload 不应发布新配置")
+		}
+		if !slices.Equal(restartRequired, []string{"Port", "MediaServer"}) {
+			t.Errorf("需要重启的配置项为 %v，期望 [Port MediaServer]", restartRequired)
+		}
+		if settings.Port != 9000 || settings.MediaServer.ADDR != "http://127.0.0.1:8096" {
+			t.Errorf("需要重启的配置项应保持运行中的值，实际为 %d、%s", settings.Port, settings.MediaServer.ADDR)
+		}
+		if _, ok := settings.Policy.Groups["kids"]; ok {
+			t.Error("已删除的用户组不应保留")
+		}
+		if got := settings.Policy.Groups["vip"]; !slices.Equal(got, []string{"alice", "carol"}) {
+			t.Errorf("用户组 vip 为 %v，期望 [alice carol]", got)
+		}
+	})
+}
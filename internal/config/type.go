@@ -1,6 +1,9 @@
 package config
 
-import "MediaWarp/constants"
+import (
+	"MediaWarp/constants"
+	"time"
+)
 
 // 程序版本信息
 type VersionInfo struct {
@@ -12,17 +15,67 @@ type VersionInfo struct {
 	Arch       string //  架构
 }
 
+// MediaWarp 配置
+//
+// 字段为配置文件中的顶级配置项；重新加载配置时解析为新的实例，检查通过后整体替换，不修改已发布的实例
+type Settings struct {
+	Port         int                 // MediaWarp开放端口
+	Listeners    []ListenerSetting   // 监听设置，为空时监听所有网卡的 Port 端口
+	TLS          TLSSetting          // TLS 证书设置
+	Shutdown     ShutdownSetting     // 优雅退出设置
+	MediaServer  MediaServerSetting  // 上游媒体服务器设置
+	Upstreams    []UpstreamSetting   // 其他上游媒体服务器设置，均未匹配时使用 MediaServer
+	Logger       LoggerSetting       // 日志设置
+	Web          WebSetting          // Web服务器设置
+	ClientFilter ClientFilterSetting // 客户端过滤设置
+	HTTPStrm     HTTPStrmSetting     // HTTPSTRM设置
+	AlistStrm    AlistStrmSetting    // AlistStrm设置
+	Subtitle     SubtitleSetting     // 字幕设置
+	StrmScan     StrmScanSetting     // Strm 文件扫描设置
+	History      HistorySetting      // 播放历史设置
+	Admin        AdminSetting        // 管理后台设置
+	API          APISetting          // 管理 API 设置
+	Auth         AuthSetting         // 用户认证设置
+	Policy       PolicySetting       // Strm 播放访问策略设置
+	Limit        LimitSetting        // Strm 播放限制设置
+	IPFilter     IPFilterSetting     // IP 访问控制设置
+	RateLimit    RateLimitSetting    // 请求频率限制设置
+	WebSocket    WebSocketSetting    // WebSocket 代理设置
+	Forwarded    ForwardedSetting    // 转发请求头设置
+}
+
 // 上游媒体服务器相关设置
 type MediaServerSetting struct {
-	Type constants.MediaServerType // 媒体服务器类型
-	ADDR string                    // 地址
-	AUTH string                    // 认证授权KEY
+	Type        constants.MediaServerType // 媒体服务器类型
+	ADDR        string                    // 地址
+	AUTH        string                    // 认证授权KEY
+	Backups     []string                  // 备用地址，与 ADDR 组成地址池，ADDR 不可用时切换
+	Balance     constants.BalanceStrategy // 地址池的选择策略
+	HealthCheck HealthCheckSetting        // 地址池的健康检查设置
+}
+
+// 媒体服务器健康检查设置
+type HealthCheckSetting struct {
+	Interval time.Duration // 检查间隔
+	Timeout  time.Duration // 单次检查的超时时间
 }
 
 // 日志设置
 type LoggerSetting struct {
-	AccessLogger  BaseLoggerSetting // 访问日志相关配置
-	ServiceLogger BaseLoggerSetting // 服务日志相关配置
+	Level         string              // 服务日志级别（Debug、Info、Warning、Error），-debug 参数优先
+	Format        constants.LogFormat // 日志格式（Text、JSON）
+	AccessLogger  BaseLoggerSetting   // 访问日志相关配置
+	ServiceLogger BaseLoggerSetting   // 服务日志相关配置
+	Rotate        LogRotateSetting    // 日志文件切割设置
+}
+
+// 日志文件切割设置
+type LogRotateSetting struct {
+	MaxSize    int           // 单个日志文件的最大大小（MB），超过后切割，0 表示不按大小切割
+	Interval   time.Duration // 按时间切割的周期（如 24h 表示每天零点切割），0 表示不按时间切割
+	MaxBackups int           // 最多保留的历史日志文件数量，0 表示不限制
+	MaxAge     time.Duration // 历史日志文件的最长保留时间，0 表示不限制
+	Compress   bool          // 是否使用 gzip 压缩历史日志文件
 }
 
 // 基础日志配置字段
@@ -47,26 +100,85 @@ type WebSetting struct {
 
 // 客户端User-Agent过滤设置
 type ClientFilterSetting struct {
-	Enable     bool
-	Mode       constants.FliterMode
-	ClientList []string
+	Enable       bool
+	Mode         constants.FliterMode
+	ClientList   []string           // User-Agent 或客户端名称包含其中任意一项即视为命中名单
+	AllowUnknown bool               // 是否放行未提供 User-Agent 和客户端名称的请求
+	Rules        []ClientFilterRule // 客户端过滤规则，优先于名单按顺序匹配
+}
+
+// 客户端过滤规则
+//
+// 所有非空条件均满足时规则匹配，正则表达式不区分大小写
+// 客户端名称、设备名称、设备 ID、版本依次从 X-Emby-Authorization 请求头、X-Emby-Client 等请求头、查询参数中获取
+type ClientFilterRule struct {
+	UserAgent  string                       // User-Agent 正则表达式
+	Client     string                       // 客户端名称正则表达式
+	Device     string                       // 设备名称正则表达式
+	DeviceID   string                       // 设备 ID 正则表达式
+	MinVersion string                       // 最低客户端版本（包含）
+	MaxVersion string                       // 最高客户端版本（包含）
+	Action     constants.ClientFilterAction // 规则匹配后的动作
+}
+
+// Strm 内容重写规则
+type PathRewriteSetting struct {
+	Type constants.PathRewriteType // 重写方式
+	From string                    // Prefix：被替换的前缀；Regexp：正则表达式；URLDecode：无需填写
+	To   string                    // 替换后的内容，Regexp 可使用 $1 等引用捕获组
+}
+
+// URL 签名设置
+type URLSignSetting struct {
+	Type        constants.URLSignType // 签名方式，为空则不签名
+	Secret      string                // 签名密钥（Alist 签名方式为 Alist 的 Token）
+	Expire      time.Duration         // 签名有效期
+	SignParam   string                // HMAC 签名方式中签名的查询参数名，默认为 sign
+	ExpireParam string                // HMAC 签名方式中过期时间的查询参数名，默认为 expires
+}
+
+// HTTPStrm URL 处理规则
+//
+// 依次替换主机、套用模板、签名
+type HTTPStrmURLSetting struct {
+	Hosts    []string       // 仅处理主机匹配的 URL，为空则处理所有 URL
+	Host     string         // 替换 URL 的主机（可包含端口）
+	Template string         // URL 模板（Go text/template），可用字段：URL、Scheme、Host、Path、RawQuery
+	Sign     URLSignSetting // URL 签名设置
+}
+
+// 最终 URL 缓存设置
+type FinalURLCacheSetting struct {
+	DefaultTTL time.Duration // 上游未提供过期时间时的缓存时间
+	MaxTTL     time.Duration // 最长缓存时间，为 0 时不缓存
 }
 
 // HTTPStrm播放设置
 type HTTPStrmSetting struct {
-	Enable     bool
-	TransCode  bool // false->强制关闭转码 true->保持原有转码设置
-	FinalURL   bool // 对 URL 进行重定向判断，找到非重定向地址再重定向给客户端，减少客户端重定向次数
-	PrefixList []string
+	Enable        bool
+	TransCode     bool                 // false->强制关闭转码 true->保持原有转码设置
+	FinalURL      bool                 // 对 URL 进行重定向判断，找到非重定向地址再重定向给客户端，减少客户端重定向次数
+	FinalURLCache FinalURLCacheSetting // 最终 URL 缓存设置
+	PrefixList    []string
+	RewriteRules  []PathRewriteSetting // Strm 内容重写规则
+	URLRules      []HTTPStrmURLSetting // URL 处理规则，使用第一条匹配的规则
+}
+
+// Alist 元信息密码设置
+type AlistMetaPassword struct {
+	Path     string // Alist 上的路径，以该路径为前缀的文件都会使用该密码
+	Password string // 元信息密码
 }
 
 // AlistStrm具体设置
 type AlistSetting struct {
-	ADDR       string
-	Username   string
-	Password   string
-	Token      *string
-	PrefixList []string
+	ADDR          string
+	Username      string
+	Password      string
+	Token         *string
+	PrefixList    []string
+	MetaPasswords []AlistMetaPassword  // 受密码保护的路径
+	RewriteRules  []PathRewriteSetting // Strm 内容重写规则
 }
 
 // AlistStrm播放设置
@@ -77,6 +189,89 @@ type AlistStrmSetting struct {
 	List      []AlistSetting
 }
 
+// Strm 文件扫描设置
+type StrmScanSetting struct {
+	Concurrency   int           // 同时检测的 Strm 条目数量
+	SlowThreshold time.Duration // 解析耗时超过该值的 Strm 条目视为缓慢
+}
+
+// 播放历史设置
+type HistorySetting struct {
+	Enable    bool          // 是否记录 Strm 播放历史
+	Retention time.Duration // 播放历史保留时间，0 表示永久保留
+}
+
+// 管理 API 设置
+type APISetting struct {
+	Key       string // 访问管理 API 的密钥，为空时仅允许媒体服务器管理员访问
+	WriteBack bool   // 是否将通过管理 API 修改的配置写回配置文件，未启用时拒绝修改配置
+}
+
+// Strm 播放访问策略设置
+type PolicySetting struct {
+	Enable  bool
+	Default constants.PolicyAction // 未匹配任何规则时的动作
+	Groups  map[string][]string    // 用户组，键为用户组名称，值为用户 ID 或用户名列表
+	Rules   []PolicyRule           // 访问策略规则，按顺序匹配，使用第一条匹配的规则
+}
+
+// Strm 播放访问策略规则
+type PolicyRule struct {
+	Users     []string               // 用户 ID 或用户名
+	Groups    []string               // 用户组名称
+	Libraries []string               // 媒体库名称或 ID
+	Action    constants.PolicyAction // 动作
+}
+
+// Strm 播放限制设置
+type LimitSetting struct {
+	Enable              bool
+	MaxStreamsPerUser   int                   // 每个用户同时播放的 Strm 数量上限，0 表示不限制
+	MaxStreamsPerDevice int                   // 每个设备同时播放的 Strm 数量上限，0 表示不限制
+	QuotaPeriod         constants.QuotaPeriod // 流量配额周期
+	Quota               int                   // 每个用户在一个周期内由 MediaWarp 代理的流量上限（GB），0 表示不限制
+	Overrides           []LimitOverride       // 按用户或用户组覆盖上述限制，使用第一条匹配的规则
+}
+
+// 按用户覆盖的播放限制
+type LimitOverride struct {
+	Users               []string // 用户 ID 或用户名
+	Groups              []string // 用户组名称（Policy.Groups 中定义）
+	MaxStreamsPerUser   int
+	MaxStreamsPerDevice int
+	Quota               int
+}
+
+// IP 访问控制设置
+type IPFilterSetting struct {
+	Enable         bool
+	TrustedProxies []string     // 已弃用，请使用 Forwarded.TrustedProxies
+	GeoIPDatabase  string       // MaxMind 格式（.mmdb）的 IP 地理位置数据库文件路径，相对路径相对于数据文件夹
+	Web            IPFilterRule // Web 页面和 API 请求的规则
+	Stream         IPFilterRule // 视频流请求的规则
+}
+
+// IP 访问控制规则
+type IPFilterRule struct {
+	Allow          []string // 允许的 IP 或 CIDR，为空时允许所有地址
+	Deny           []string // 禁止的 IP 或 CIDR，优先于 Allow
+	AllowCountries []string // 允许的国家或地区代码（ISO 3166-1，如 CN），为空时不限制
+	DenyCountries  []string // 禁止的国家或地区代码
+}
+
+// 用户认证设置
+type AuthSetting struct {
+	Enable      bool     // 是否要求 /MediaWarp 接口和视频流请求携带媒体服务器用户的访问令牌
+	PublicPaths []string // 无需认证的 /MediaWarp 路径前缀
+}
+
+// 管理后台设置
+type AdminSetting struct {
+	Enable   bool   // 是否启用管理后台（/MediaWarp/admin）
+	Username string // 管理后台账号
+	Password string // 管理后台密码
+}
+
 // 字幕设置
 type SubtitleSetting struct {
 	Enable   bool
@@ -84,3 +279,90 @@ type SubtitleSetting struct {
 	ASSStyle []string
 	SubSet   bool // ASS 字幕字体子集化
 }
+
+// 请求频率限制设置
+type RateLimitSetting struct {
+	Enable bool
+	Global RateLimitRule     // 所有请求共享的频率限制
+	PerIP  RateLimitRule     // 每个 IP 的频率限制
+	Auth   AuthRateLimitRule // 登录接口的频率限制
+	Ban    BanSetting        // 登录失败封禁设置
+}
+
+// 请求频率限制规则
+//
+// 令牌桶算法，每个周期补充 Requests 个令牌，允许突发 Requests 个请求
+type RateLimitRule struct {
+	Requests int           // 每个周期允许的请求数，为 0 时不限制
+	Period   time.Duration // 周期
+}
+
+// 登录接口的频率限制
+type AuthRateLimitRule struct {
+	PerIP       RateLimitRule // 每个 IP 的频率限制
+	PerUsername RateLimitRule // 每个用户名的频率限制
+}
+
+// 登录失败封禁设置
+type BanSetting struct {
+	MaxFailures int           // 时间窗口内登录失败次数达到该值时封禁 IP，为 0 时不封禁
+	Window      time.Duration // 统计登录失败次数的时间窗口
+	Duration    time.Duration // 封禁时长
+}
+
+// 监听设置
+type ListenerSetting struct {
+	Addr          string // 监听地址，如 :9000、192.168.1.2:9000
+	TLS           bool   // 是否启用 HTTPS，证书使用 TLS 设置
+	H2C           bool   // 是否允许未加密的 HTTP/2（h2c），HTTPS 监听始终通过 ALPN 协商 HTTP/2
+	ProxyProtocol bool   // 是否接受 PROXY protocol（v1、v2）头，来自可信代理的连接必须发送该头，未设置可信代理时所有连接都必须发送
+}
+
+// TLS 证书设置
+type TLSSetting struct {
+	CertFile string      // 证书文件路径，文件修改后自动重新加载
+	KeyFile  string      // 私钥文件路径
+	ACME     ACMESetting // 自动申请证书，启用后忽略 CertFile 和 KeyFile
+}
+
+// ACME 自动申请证书设置
+type ACMESetting struct {
+	Enable       bool
+	Domains      []string                // 申请证书的域名，仅为这些域名申请证书
+	Email        string                  // 联系邮箱，用于接收证书过期通知
+	Challenge    constants.ACMEChallenge // 验证方式
+	CacheDir     string                  // 证书和账户密钥缓存目录，相对路径相对于数据文件夹
+	DirectoryURL string                  // ACME 服务器目录地址，为空时使用 Let's Encrypt
+}
+
+// 优雅退出设置
+type ShutdownSetting struct {
+	Delay        time.Duration // 收到退出信号后先将就绪状态设置为未就绪，等待该时长后再停止接受新连接
+	DrainTimeout time.Duration // 等待正在处理的请求（包括正在代理的视频流）完成的最长时间，超时后强制关闭连接
+}
+
+// WebSocket 代理设置
+type WebSocketSetting struct {
+	IdleTimeout time.Duration // 两个方向均无数据时关闭连接的时间，为 0 时不限制
+}
+
+// 转发请求头设置
+type ForwardedSetting struct {
+	TrustedProxies []string              // 可信代理的 IP 或 CIDR，仅信任来自这些地址的 X-Forwarded-* 和 X-Real-IP 请求头
+	Host           constants.HostRewrite // 转发至上游服务器的 Host 请求头
+	CustomHost     string                // Host 为 Custom 时使用的主机名（可包含端口）
+}
+
+// 上游媒体服务器设置
+//
+// 按 Host 请求头、监听端口和路径前缀选择，设置的条件需全部满足
+type UpstreamSetting struct {
+	Name               string                   // 名称，用于日志和管理接口，不可重复
+	MediaServerSetting `mapstructure:",squash"` // 媒体服务器类型、地址等设置，与 MediaServer 相同
+	Hosts              []string                 // 匹配的 Host 请求头（不含端口，忽略大小写）
+	Ports              []int                    // 匹配的 MediaWarp 监听端口
+	PathPrefix         string                   // 匹配的路径前缀，转发至上游服务器前去除该前缀
+	Web                *WebSetting              // Web 页面修改设置，为空时使用全局 Web 设置
+	HTTPStrm           *HTTPStrmSetting         // HTTPStrm 设置，为空时使用全局 HTTPStrm 设置
+	AlistStrm          *AlistStrmSetting        // AlistStrm 设置，为空时使用全局 AlistStrm 设置
+}
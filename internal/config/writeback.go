@@ -0,0 +1,129 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// 将配置项写回配置文件
+//
+// key 为以 . 分隔的配置项路径（如 AlistStrm.List），不区分大小写
+// 仅替换对应的配置项，保留配置文件中的注释和其他配置项
+func WriteBack(key string, value any) error {
+	path := viper.ConfigFileUsed()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败：%w", err)
+	}
+	var document yaml.Node
+	if err = yaml.Unmarshal(content, &document); err != nil {
+		return fmt.Errorf("解析配置文件失败：%w", err)
+	}
+	if len(document.Content) == 0 {
+		document = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+
+	valueNode, err := encodeValue(value)
+	if err != nil {
+		return err
+	}
+	if err = setNode(document.Content[0], strings.Split(key, "."), valueNode); err != nil {
+		return fmt.Errorf("写入配置项 %s 失败：%w", key, err)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(&document); err != nil {
+		return fmt.Errorf("序列化配置文件失败：%w", err)
+	}
+	if err = encoder.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// 将配置值编码为 YAML 节点
+//
+// 先经过 JSON 转换，使结构体字段名与配置文件中的键保持一致，并省略值为空的字段
+func encodeValue(value any) (*yaml.Node, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var plain any
+	if err = json.Unmarshal(data, &plain); err != nil {
+		return nil, err
+	}
+	node := &yaml.Node{}
+	if err = node.Encode(omitEmpty(plain)); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// 在映射节点中按路径设置值
+//
+// 路径中不存在的键会被创建
+func setNode(mapping *yaml.Node, path []string, value *yaml.Node) error {
+	if mapping.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s 不是映射类型", path[0])
+	}
+	for i := 0; i < len(mapping.Content); i += 2 {
+		keyNode := mapping.Content[i]
+		if !strings.EqualFold(keyNode.Value, path[0]) {
+			continue
+		}
+		if len(path) == 1 {
+			value.HeadComment = mapping.Content[i+1].HeadComment // 保留原有的注释
+			value.LineComment = mapping.Content[i+1].LineComment
+			mapping.Content[i+1] = value
+			return nil
+		}
+		return setNode(mapping.Content[i+1], path[1:], value)
+	}
+
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: path[0]}
+	if len(path) == 1 {
+		mapping.Content = append(mapping.Content, keyNode, value)
+		return nil
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	mapping.Content = append(mapping.Content, keyNode, child)
+	return setNode(child, path[1:], value)
+}
+
+// 递归移除映射中值为 null、空字符串或空列表的字段
+func omitEmpty(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			switch field := field.(type) {
+			case nil:
+				delete(v, key)
+			case string:
+				if field == "" {
+					delete(v, key)
+				}
+			case []any:
+				if len(field) == 0 {
+					delete(v, key)
+				}
+			}
+			if field, ok := v[key]; ok {
+				v[key] = omitEmpty(field)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = omitEmpty(item)
+		}
+	}
+	return value
+}
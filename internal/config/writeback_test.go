@@ -0,0 +1,52 @@
+package config_test
+
+import (
+	"MediaWarp/internal/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestWriteBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `Port: 9000 # 监听端口
+
+HTTPStrm:                   # HTTPStrm 设置
+  Enable: True
+  PrefixList:               # 前缀列表
+    - /media/strm/http
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	viper.SetConfigFile(path)
+
+	if err := config.WriteBack("httpstrm.PrefixList", []string{"/a", "/b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.WriteBack("ClientFilter.ClientList", []string{"Infuse"}); err != nil { // 不存在的配置项
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := string(data)
+	for _, want := range []string{
+		"Port: 9000 # 监听端口",
+		"HTTPStrm: # HTTPStrm 设置",
+		"PrefixList: # 前缀列表\n    - /a\n    - /b\n",
+		"ClientFilter:\n  ClientList:\n    - Infuse\n",
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("写回结果中缺少 %q：\n%s", want, result)
+		}
+	}
+	if strings.Contains(result, "/media/strm/http") {
+		t.Errorf("写回结果中仍包含旧的配置：\n%s", result)
+	}
+}
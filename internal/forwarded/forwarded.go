@@ -0,0 +1,137 @@
+package forwarded
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/config"
+	"MediaWarp/utils"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// 来自不可信地址时删除的请求头
+var forwardedHeaders = []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Real-IP", "Forwarded"}
+
+var current atomic.Pointer[Forwarder] // 当前生效的转发请求头设置
+
+// 转发请求头处理器
+//
+// 解析客户端的真实 IP，并为转发至上游服务器的请求设置 X-Forwarded-* 和 X-Real-IP 请求头
+// 与 gin 的 ClientIP 使用相同的规则：仅当连接来自可信代理时才信任 X-Forwarded-For，从右向左取第一个不可信的地址
+type Forwarder struct {
+	trusted    []netip.Prefix
+	host       constants.HostRewrite
+	customHost string
+}
+
+// 创建转发请求头处理器
+func New(setting config.ForwardedSetting) (*Forwarder, error) {
+	trusted, err := utils.ParsePrefixes(setting.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("可信代理：%w", err)
+	}
+	return &Forwarder{trusted: trusted, host: setting.Host, customHost: setting.CustomHost}, nil
+}
+
+// 判断地址是否为可信代理
+func (forwarder *Forwarder) IsTrusted(addr netip.Addr) bool {
+	return utils.ContainsAddr(forwarder.trusted, addr.Unmap())
+}
+
+// 获取客户端的真实 IP
+func (forwarder *Forwarder) ClientIP(req *http.Request) string {
+	remote, ok := remoteAddr(req)
+	if !ok {
+		return req.RemoteAddr
+	}
+	if !forwarder.IsTrusted(remote) {
+		return remote.String()
+	}
+
+	items := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(items) - 1; i >= 0; i-- {
+		item := strings.TrimSpace(items[i])
+		if item == "" {
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			break
+		}
+		if i == 0 || !forwarder.IsTrusted(addr) {
+			return addr.Unmap().String()
+		}
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSpace(req.Header.Get("X-Real-IP"))); err == nil {
+		return addr.Unmap().String()
+	}
+	return remote.String()
+}
+
+// 设置转发至上游服务器的请求
+//
+// 在 httputil.ReverseProxy 的 Director 中调用，req 为转发至上游服务器的请求，target 为上游服务器地址
+// 连接来自不可信地址时删除客户端伪造的转发请求头；X-Forwarded-For 由 httputil.ReverseProxy 和 wsproxy 在此之后追加连接的来源地址
+func (forwarder *Forwarder) Rewrite(req *http.Request, target *url.URL) {
+	clientIP := forwarder.ClientIP(req)
+	if remote, ok := remoteAddr(req); !ok || !forwarder.IsTrusted(remote) {
+		for _, key := range forwardedHeaders {
+			req.Header.Del(key)
+		}
+	}
+
+	if req.Header.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+		req.Header.Set("X-Forwarded-Proto", proto)
+	}
+	if req.Header.Get("X-Forwarded-Host") == "" {
+		req.Header.Set("X-Forwarded-Host", req.Host)
+	}
+	req.Header.Set("X-Real-IP", clientIP)
+
+	switch forwarder.host {
+	case constants.HostUpstream:
+		req.Host = target.Host
+	case constants.HostCustom:
+		req.Host = forwarder.customHost
+	}
+}
+
+// 解析连接的来源地址
+func remoteAddr(req *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// 初始化全局转发请求头处理器
+//
+// 可信代理同时用于 gin 解析客户端 IP，修改后需重启
+func Init() error {
+	forwarder, err := New(config.Get().Forwarded)
+	if err != nil {
+		return err
+	}
+	current.Store(forwarder)
+	return nil
+}
+
+// 获取全局转发请求头处理器
+//
+// 未初始化时返回 nil
+func Get() *Forwarder {
+	return current.Load()
+}
@@ -0,0 +1,115 @@
+package forwarded_test
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/forwarded"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func newForwarder(t *testing.T, host constants.HostRewrite) *forwarded.Forwarder {
+	t.Helper()
+	forwarder, err := forwarded.New(config.ForwardedSetting{
+		TrustedProxies: []string{"10.0.0.0/8", "127.0.0.1"},
+		Host:           host,
+		CustomHost:     "emby.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return forwarder
+}
+
+func TestClientIP(t *testing.T) {
+	forwarder := newForwarder(t, constants.HostClient)
+	tests := map[string]struct {
+		remoteAddr string
+		xff        string
+		realIP     string
+		want       string
+	}{
+		"不可信地址忽略 X-Forwarded-For": {"203.0.113.1:1234", "198.51.100.1", "", "203.0.113.1"},
+		"可信代理":                    {"127.0.0.1:1234", "198.51.100.1", "", "198.51.100.1"},
+		"跳过链路中的可信代理":              {"127.0.0.1:1234", "198.51.100.1, 203.0.113.9, 10.0.0.2", "", "203.0.113.9"},
+		"全部为可信代理时取最左侧地址":          {"127.0.0.1:1234", "10.0.0.3, 10.0.0.2", "", "10.0.0.3"},
+		"X-Real-IP": {"10.1.2.3:1234", "", "198.51.100.7", "198.51.100.7"},
+		"IPv4 映射地址": {"[::ffff:127.0.0.1]:1234", "198.51.100.1", "", "198.51.100.1"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := forwarder.ClientIP(req); got != tt.want {
+				t.Errorf("ClientIP() = %s，期望 %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	target, _ := url.Parse("http://192.168.1.5:8096")
+
+	t.Run("不可信地址", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://mediawarp.example.com/emby/System/Info", nil)
+		req.RemoteAddr = "203.0.113.1:1234"
+		req.Header.Set("X-Forwarded-For", "1.1.1.1")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Real-IP", "1.1.1.1")
+		newForwarder(t, constants.HostUpstream).Rewrite(req, target)
+
+		if got := req.Header.Get("X-Forwarded-For"); got != "" {
+			t.Errorf("X-Forwarded-For = %s，期望删除伪造的请求头", got)
+		}
+		if got := req.Header.Get("X-Real-IP"); got != "203.0.113.1" {
+			t.Errorf("X-Real-IP = %s，期望 203.0.113.1", got)
+		}
+		if got := req.Header.Get("X-Forwarded-Proto"); got != "http" {
+			t.Errorf("X-Forwarded-Proto = %s，期望 http", got)
+		}
+		if got := req.Header.Get("X-Forwarded-Host"); got != "mediawarp.example.com" {
+			t.Errorf("X-Forwarded-Host = %s，期望 mediawarp.example.com", got)
+		}
+		if req.Host != "192.168.1.5:8096" {
+			t.Errorf("Host = %s，期望 192.168.1.5:8096", req.Host)
+		}
+	})
+
+	t.Run("可信代理", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://mediawarp.example.com/emby/System/Info", nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", "198.51.100.1")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "emby.example.org")
+		newForwarder(t, constants.HostCustom).Rewrite(req, target)
+
+		if got := req.Header.Get("X-Forwarded-For"); got != "198.51.100.1" {
+			t.Errorf("X-Forwarded-For = %s，期望保留 198.51.100.1", got)
+		}
+		if got := req.Header.Get("X-Real-IP"); got != "198.51.100.1" {
+			t.Errorf("X-Real-IP = %s，期望 198.51.100.1", got)
+		}
+		if got := req.Header.Get("X-Forwarded-Proto"); got != "https" {
+			t.Errorf("X-Forwarded-Proto = %s，期望保留 https", got)
+		}
+		if got := req.Header.Get("X-Forwarded-Host"); got != "emby.example.org" {
+			t.Errorf("X-Forwarded-Host = %s，期望保留 emby.example.org", got)
+		}
+		if req.Host != "emby.example.com" {
+			t.Errorf("Host = %s，期望 emby.example.com", req.Host)
+		}
+	})
+}
+
+func TestNewInvalidTrustedProxies(t *testing.T) {
+	if _, err := forwarded.New(config.ForwardedSetting{TrustedProxies: []string{"not-an-ip"}}); err == nil {
+		t.Error("错误的可信代理应返回错误")
+	}
+}
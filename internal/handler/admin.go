@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"MediaWarp/internal/clientfilter"
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/ipfilter"
+	"MediaWarp/internal/logging"
+	"MediaWarp/internal/service"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maskedValue = "******" // 敏感配置项展示时使用的值
+
+// 敏感配置项的键（小写）
+//
+// 键名包含其中任意一项的配置项在管理后台中都会被隐藏
+var secretKeys = []string{"password", "token", "secret", "auth", "apikey"}
+
+// 敏感配置项的完整键名（小写）
+//
+// 键名与其中任意一项相同的配置项也会被隐藏，如 API.Key
+var secretExactKeys = []string{"key"}
+
+var configMutex sync.Mutex // 保证同一时间只有一个重新加载或修改配置的任务
+
+// 隐藏敏感配置项
+//
+// v 为 JSON 反序列化得到的 map、slice，仅隐藏非空的字符串值
+func maskSecrets(v any) any {
+	switch value := v.(type) {
+	case map[string]any:
+		for key, item := range value {
+			if text, ok := item.(string); ok && text != "" && isSecretKey(key) {
+				value[key] = maskedValue
+				continue
+			}
+			value[key] = maskSecrets(item)
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = maskSecrets(item)
+		}
+	}
+	return v
+}
+
+// 判断配置项的键是否为敏感配置项
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	if slices.Contains(secretExactKeys, key) {
+		return true
+	}
+	for _, secretKey := range secretKeys {
+		if strings.Contains(key, secretKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// 获取隐藏敏感信息后的当前配置
+func maskedConfig() (map[string]any, error) {
+	data, err := json.Marshal(config.Snapshot())
+	if err != nil {
+		return nil, err
+	}
+	var settings map[string]any
+	if err = json.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+	maskSecrets(settings)
+	return settings, nil
+}
+
+// 重新加载配置结果
+type configReloadResult struct {
+	RestartRequired []string `json:"RestartRequired"` // 已修改但需要重启才能生效的配置项
+}
+
+// 重新加载配置
+//
+// 重新读取配置文件，更新 Strm 规则、IP 访问控制、客户端过滤器和 Alist 服务器
+// 新配置和依赖配置的组件全部初始化成功后才整体替换，失败时保持原配置
+// 客户端过滤名单、Strm 相关开关等在处理请求时读取的配置项立即生效
+func reloadConfig() (configReloadResult, error) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	before := config.Get()
+	after, restartRequired, err := config.Reload()
+	if err != nil {
+		return configReloadResult{}, err
+	}
+	rules, err := buildStrmRules(after)
+	if err != nil {
+		return configReloadResult{}, err
+	}
+	ipFilter, err := ipfilter.Build(after)
+	if err != nil {
+		return configReloadResult{}, err
+	}
+	clientFilter, err := clientfilter.Build(after)
+	if err != nil {
+		return configReloadResult{}, err
+	}
+	config.Set(after)
+	setStrmRules(rules)
+	ipfilter.Set(ipFilter)
+	clientfilter.Set(clientFilter)
+	service.InitAlistSerer()
+
+	result := configReloadResult{RestartRequired: restartRequired}
+	if before.ClientFilter.Enable != after.ClientFilter.Enable { // 客户端过滤中间件在启动时注册
+		result.RestartRequired = append(result.RestartRequired, "ClientFilter.Enable")
+	}
+	if before.Admin.Enable != after.Admin.Enable { // 管理后台路由在启动时注册
+		result.RestartRequired = append(result.RestartRequired, "Admin.Enable")
+	}
+	if before.IPFilter.Enable != after.IPFilter.Enable { // IP 访问控制中间件在启动时注册
+		result.RestartRequired = append(result.RestartRequired, "IPFilter.Enable")
+	}
+	if before.RateLimit.Enable != after.RateLimit.Enable { // 请求频率限制中间件在启动时注册
+		result.RestartRequired = append(result.RestartRequired, "RateLimit.Enable")
+	}
+	logging.Info("配置已重新加载")
+	if len(result.RestartRequired) > 0 {
+		logging.Warning("以下配置项需要重启 MediaWarp 才能生效：", strings.Join(result.RestartRequired, "、"))
+	}
+	return result, nil
+}
+
+// Alist 服务器状态
+type alistServerStatus struct {
+	Endpoint  string `json:"Endpoint"`
+	Username  string `json:"Username"`
+	Available bool   `json:"Available"`
+	Latency   int64  `json:"Latency"` // /ping 响应耗时（毫秒）
+	Error     string `json:"Error,omitempty"`
+}
+
+// 检测所有 Alist 服务器状态
+func alistServerStatuses() []alistServerStatus {
+	servers := service.ListAlistServers()
+	statuses := make([]alistServerStatus, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status := alistServerStatus{Endpoint: server.GetEndpoint(), Username: server.GetUsername()}
+			latency, err := server.Ping()
+			if err != nil {
+				status.Error = err.Error()
+			} else {
+				status.Available = true
+				status.Latency = latency.Milliseconds()
+			}
+			statuses[i] = status
+		}()
+	}
+	wg.Wait()
+	return statuses
+}
+
+// 获取当前配置（隐藏敏感信息）
+//
+// GET /MediaWarp/admin/api/config
+func AdminConfigHandler(ctx *gin.Context) {
+	settings, err := maskedConfig()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, settings)
+}
+
+// 重新加载配置
+//
+// POST /MediaWarp/admin/api/reload
+func AdminReloadHandler(ctx *gin.Context) {
+	result, err := reloadConfig()
+	if err != nil {
+		logging.Warning("重新加载配置失败：", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, result)
+}
+
+// 获取 Alist 服务器状态
+//
+// GET /MediaWarp/admin/api/alist
+func AdminAlistHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, alistServerStatuses())
+}
+
+// 获取最近的 Strm 重定向和错误日志
+//
+// GET /MediaWarp/admin/api/logs
+func AdminLogsHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"Redirects": logging.RecentRedirects(),
+		"Errors":    logging.RecentErrors(),
+	})
+}
+
+// 获取缓存统计信息
+//
+// GET /MediaWarp/admin/api/cache
+func AdminCacheHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"FinalURL": finalURLResolver.Stats(),
+	})
+}
+
+// 测试解析条目的 Strm 内容
+//
+// GET /MediaWarp/admin/api/resolve?itemId=条目ID&upstream=上游媒体服务器名称
+// 不读取缓存，返回每个媒体源的解析结果；未指定 upstream 时查询 MediaServer
+func AdminResolveHandler(ctx *gin.Context) {
+	itemID := ctx.Query("itemid")
+	if itemID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "缺少 itemId 参数"})
+		return
+	}
+	if mediaServerHandler == nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": ErrMediaServerMissing.Error()})
+		return
+	}
+	mediaServer, ok := mediaServerByName(ctx.Query("upstream"))
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "上游媒体服务器不存在"})
+		return
+	}
+	items, err := mediaServer.GetStrmItems(itemID)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if len(items) == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "条目不存在或不是 Strm 文件"})
+		return
+	}
+
+	entries := make([]StrmScanEntry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, checkStrmItem(item))
+	}
+	ctx.JSON(http.StatusOK, entries)
+}
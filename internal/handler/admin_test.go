@@ -0,0 +1,204 @@
+package handler_test
+
+import (
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/handler"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 调用 handlerFunc 处理请求
+func serve(t *testing.T, handlerFunc gin.HandlerFunc, method string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = httptest.NewRequest(method, "/", strings.NewReader(body))
+	handlerFunc(ctx)
+	return recorder
+}
+
+// 写入配置文件并初始化配置
+func initConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Init(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const baseConfig = `MediaServer:
+  Type: Emby
+  ADDR: http://127.0.0.1:8096
+`
+
+func TestAdminConfigHandler(t *testing.T) {
+	token := "alist-token"
+	config.Set(&config.Settings{
+		API:   config.APISetting{Key: "api-key"},
+		Admin: config.AdminSetting{Username: "admin", Password: "admin-password"},
+		TLS:   config.TLSSetting{KeyFile: "/etc/mediawarp/key.pem"},
+		AlistStrm: config.AlistStrmSetting{List: []config.AlistSetting{
+			{ADDR: "http://127.0.0.1:5244", Username: "admin", Password: "alist-password", Token: &token},
+		}},
+	})
+
+	recorder := serve(t, handler.AdminConfigHandler, http.MethodGet, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("响应 %d：%s", recorder.Code, recorder.Body)
+	}
+	var settings struct {
+		API       config.APISetting
+		Admin     config.AdminSetting
+		TLS       config.TLSSetting
+		AlistStrm struct{ List []map[string]any }
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.API.Key != "******" {
+		t.Errorf("API.Key 为 %q，应被隐藏", settings.API.Key)
+	}
+	if settings.Admin.Password != "******" || settings.Admin.Username != "admin" {
+		t.Errorf("管理后台账号为 %q、密码为 %q，应仅隐藏密码", settings.Admin.Username, settings.Admin.Password)
+	}
+	if settings.TLS.KeyFile != "/etc/mediawarp/key.pem" {
+		t.Errorf("TLS.KeyFile 为 %q，不应被隐藏", settings.TLS.KeyFile)
+	}
+	for _, key := range []string{"Password", "Token"} {
+		if value := settings.AlistStrm.List[0][key]; value != "******" {
+			t.Errorf("Alist 服务器的 %s 为 %v，应被隐藏", key, value)
+		}
+	}
+	if strings.Contains(recorder.Body.String(), "api-key") {
+		t.Error("响应中不应包含管理 API 密钥")
+	}
+}
+
+func TestAdminReloadHandler(t *testing.T) {
+	path := initConfig(t, baseConfig+`HTTPStrm:
+  PrefixList: [/media/strm]
+`)
+
+	t.Run("重新加载", func(t *testing.T) {
+		if err := os.WriteFile(path, []byte(baseConfig+`HTTPStrm:
+  PrefixList: [/media/http]
+ClientFilter:
+  Enable: True
+`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		recorder := serve(t, handler.AdminReloadHandler, http.MethodPost, "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("响应 %d：%s", recorder.Code, recorder.Body)
+		}
+		var result struct{ RestartRequired []string }
+		if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(result.RestartRequired, []string{"ClientFilter.Enable"}) {
+			t.Errorf("需要重启的配置项为 %v，期望 [ClientFilter.Enable]", result.RestartRequired)
+		}
+		if got := config.Get().HTTPStrm.PrefixList; !slices.Equal(got, []string{"/media/http"}) {
+			t.Errorf("HTTPStrm.PrefixList 为 %v，期望 [/media/http]", got)
+		}
+	})
+
+	t.Run("错误的配置", func(t *testing.T) {
+		running := config.Get()
+		if err := os.WriteFile(path, []byte(baseConfig+`ClientFilter:
+  Enable: True
+  Rules:
+    - Client: "("
+      Action: Deny
+`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		recorder := serve(t, handler.AdminReloadHandler, http.MethodPost, "")
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("响应 %d，期望 %d", recorder.Code, http.StatusBadRequest)
+		}
+		if config.Get() != running {
+			t.Error("重新加载失败后配置不应改变")
+		}
+	})
+}
+
+func TestUpdateConfig(t *testing.T) {
+	t.Run("未启用写回", func(t *testing.T) {
+		initConfig(t, baseConfig+`HTTPStrm:
+  PrefixList: [/media/strm]
+`)
+		running := config.Get()
+		recorder := serve(t, handler.UpdateHTTPStrmPrefixesHandler, http.MethodPut, `{"PrefixList": ["/media/http"]}`)
+		if recorder.Code != http.StatusConflict {
+			t.Errorf("响应 %d，期望 %d", recorder.Code, http.StatusConflict)
+		}
+		if config.Get() != running {
+			t.Error("拒绝修改后配置不应改变")
+		}
+	})
+
+	path := initConfig(t, baseConfig+`API:
+  WriteBack: True
+HTTPStrm:
+  PrefixList: [/media/strm]
+`)
+
+	t.Run("修改并写回", func(t *testing.T) {
+		recorder := serve(t, handler.UpdateHTTPStrmPrefixesHandler, http.MethodPut, `{"PrefixList": ["/media/http"]}`)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("响应 %d：%s", recorder.Code, recorder.Body)
+		}
+		if got := config.Get().HTTPStrm.PrefixList; !slices.Equal(got, []string{"/media/http"}) {
+			t.Errorf("HTTPStrm.PrefixList 为 %v，期望 [/media/http]", got)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), "/media/http") {
+			t.Errorf("配置文件未写回修改：\n%s", content)
+		}
+	})
+
+	t.Run("错误的规则", func(t *testing.T) {
+		running := config.Get()
+		recorder := serve(t, handler.UpdateClientFilterHandler, http.MethodPut, `{"Mode": "BlackList", "Rules": [{"Client": "(", "Action": "Deny"}]}`)
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("响应 %d，期望 %d", recorder.Code, http.StatusBadRequest)
+		}
+		if config.Get() != running {
+			t.Error("修改失败后配置不应改变")
+		}
+	})
+
+	t.Run("写回失败", func(t *testing.T) {
+		running := config.Get()
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(path, 0755); err != nil { // 配置文件路径为文件夹时写回失败
+			t.Fatal(err)
+		}
+		recorder := serve(t, handler.UpdateHTTPStrmPrefixesHandler, http.MethodPut, `{"PrefixList": ["/media/other"]}`)
+		if recorder.Code != http.StatusInternalServerError {
+			t.Errorf("响应 %d，期望 %d", recorder.Code, http.StatusInternalServerError)
+		}
+		if config.Get() != running {
+			t.Error("写回失败后配置不应改变")
+		}
+	})
+}
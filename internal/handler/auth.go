@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/logging"
+	"MediaWarp/utils"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	userCacheTTL         = 5 * time.Minute  // 访问令牌验证结果的缓存时间
+	invalidTokenCacheTTL = 30 * time.Second // 无效访问令牌的缓存时间，避免无效令牌反复请求上游服务器
+
+	authUserContextKey = "MediaWarp-AuthUser" // 请求上下文中已认证的用户
+)
+
+var (
+	ErrTokenMissing = errors.New("缺少访问令牌")
+	ErrInvalidToken = errors.New("访问令牌无效")
+)
+
+// 媒体服务器用户
+type MediaServerUser struct {
+	ID              string `json:"Id"`
+	Name            string `json:"Name"`
+	IsAdministrator bool   `json:"IsAdministrator"`
+}
+
+// 使用媒体服务器 API Key 访问时的用户
+var apiKeyUser = &MediaServerUser{Name: "API Key", IsAdministrator: true}
+
+type userCacheEntry struct {
+	user     *MediaServerUser
+	err      error
+	expireAt time.Time
+}
+
+// 访问令牌验证结果缓存
+var userCache = struct {
+	mutex   sync.Mutex
+	entries map[string]userCacheEntry
+}{entries: make(map[string]userCacheEntry)}
+
+// 验证访问令牌
+//
+// 通过 MediaServer 验证，见 authenticateToken
+func AuthenticateToken(token string) (*MediaServerUser, error) {
+	return authenticateToken(mediaServerHandler, token)
+}
+
+// 通过指定的媒体服务器验证访问令牌
+//
+// 通过媒体服务器的 /Users/Me 验证，结果按媒体服务器和令牌缓存
+// 令牌与配置中该媒体服务器的 API Key 相同时视为管理员
+// 令牌无效或用户被禁用时返回 ErrInvalidToken，上游服务器不可用等错误不缓存
+func authenticateToken(mediaServer MediaServerHandler, token string) (*MediaServerUser, error) {
+	if token == "" {
+		return nil, ErrTokenMissing
+	}
+	if mediaServer == nil {
+		return nil, ErrMediaServerMissing
+	}
+	apiKey := config.Get().MediaServer.AUTH
+	if setting, ok := upstreamSetting(mediaServer.Name()); ok {
+		apiKey = setting.AUTH
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(apiKey)) == 1 {
+		return apiKeyUser, nil
+	}
+
+	key := mediaServer.Name() + "\x00" + token
+	now := time.Now()
+	userCache.mutex.Lock()
+	entry, ok := userCache.entries[key]
+	userCache.mutex.Unlock()
+	if ok && now.Before(entry.expireAt) {
+		return entry.user, entry.err
+	}
+
+	user, err := mediaServer.GetUser(token)
+	switch {
+	case errors.Is(err, ErrInvalidToken):
+		entry = userCacheEntry{err: ErrInvalidToken, expireAt: now.Add(invalidTokenCacheTTL)}
+	case err != nil:
+		return nil, err
+	default:
+		entry = userCacheEntry{user: user, expireAt: now.Add(userCacheTTL)}
+	}
+
+	userCache.mutex.Lock()
+	defer userCache.mutex.Unlock()
+	for key, cached := range userCache.entries { // 顺便清理过期的缓存
+		if !now.Before(cached.expireAt) {
+			delete(userCache.entries, key)
+		}
+	}
+	userCache.entries[key] = entry
+	return entry.user, entry.err
+}
+
+// 验证请求中的访问令牌
+//
+// 访问令牌从 X-Emby-Authorization 请求头、X-Emby-Token 请求头、api_key 查询参数等位置获取
+// 通过处理该请求的媒体服务器验证，验证通过后将用户保存至请求上下文
+func AuthenticateRequest(ctx *gin.Context) (*MediaServerUser, error) {
+	user, err := authenticateToken(MediaServerOf(ctx.Request), utils.GetClientInfo(ctx.Request).Token)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Set(authUserContextKey, user)
+	ctx.Set(logging.UserContextKey, user.Name)
+	return user, nil
+}
+
+// 获取请求上下文中已认证的用户
+func GetAuthenticatedUser(ctx *gin.Context) (*MediaServerUser, bool) {
+	value, ok := ctx.Get(authUserContextKey)
+	if !ok {
+		return nil, false
+	}
+	user, ok := value.(*MediaServerUser)
+	return user, ok
+}
+
+// 要求请求携带有效的访问令牌
+//
+// 未启用用户认证时直接放行
+// 验证失败时中止请求（访问令牌缺失或无效响应 401，上游服务器不可用响应 502）并返回 false
+func RequireAuth(ctx *gin.Context) bool {
+	if !config.Get().Auth.Enable {
+		return true
+	}
+	_, err := AuthenticateRequest(ctx)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrTokenMissing), errors.Is(err, ErrInvalidToken):
+		logging.Warningf("拒绝未认证的请求：%s，IP：%s，原因：%s", ctx.Request.URL.Path, ctx.ClientIP(), err)
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	default:
+		logging.Warning("验证访问令牌失败：", err)
+		ctx.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	}
+	return false
+}
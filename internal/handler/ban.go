@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"MediaWarp/internal/ratelimit"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 查询因多次登录失败被封禁的 IP
+//
+// GET /MediaWarp/api/bans
+func ListBansHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"Items": ratelimit.Get().Bans()})
+}
+
+// 解除 IP 的封禁
+//
+// DELETE /MediaWarp/api/bans?ip=IP地址
+func UnbanHandler(ctx *gin.Context) {
+	ip := ctx.Query("ip")
+	if !ratelimit.Get().Unban(ip) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "IP 地址未被封禁：" + ip})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"Items": ratelimit.Get().Bans()})
+}
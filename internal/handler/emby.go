@@ -2,19 +2,25 @@ package handler
 
 import (
 	"MediaWarp/constants"
+	"MediaWarp/internal/balancer"
 	"MediaWarp/internal/config"
 	"MediaWarp/internal/logging"
+	"MediaWarp/internal/metrics"
 	"MediaWarp/internal/service"
 	"MediaWarp/internal/service/emby"
+	"MediaWarp/internal/wsproxy"
 	"MediaWarp/utils"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"path"
+	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -22,62 +28,90 @@ import (
 
 // Emby服务器处理器
 type EmbyServerHandler struct {
+	name        string                 // 上游媒体服务器名称，MediaServer 为空字符串
 	server      *emby.EmbyServer       // Emby 服务器
 	routerRules []RegexpRouteRule      // 正则路由规则
 	proxy       *httputil.ReverseProxy // 反向代理
+	webSocket   *wsproxy.Proxy         // WebSocket 代理
 }
 
 // 初始化
-func NewEmbyServerHandler(addr string, apiKey string) (*EmbyServerHandler, error) {
-	var embyServerHandler = EmbyServerHandler{}
-	embyServerHandler.server = emby.New(addr, apiKey)
+//
+// ctx 取消时停止地址池的健康检查
+func NewEmbyServerHandler(ctx context.Context, name string, setting config.MediaServerSetting) (*EmbyServerHandler, error) {
+	var embyServerHandler = EmbyServerHandler{name: name}
+	pool, err := balancer.New(name, setting)
+	if err != nil {
+		return nil, err
+	}
+	go pool.RunHealthCheck(ctx)
+	embyServerHandler.server = emby.New(setting.ADDR, setting.AUTH, pool.Client())
 	target, err := url.Parse(embyServerHandler.server.GetEndpoint())
 	if err != nil {
 		return nil, err
 	}
-	embyServerHandler.proxy = httputil.NewSingleHostReverseProxy(target)
+	embyServerHandler.proxy = newReverseProxy(target, pool)
+	embyServerHandler.webSocket = newWebSocketProxy(target, func(req *http.Request) {
+		embyServerHandler.proxy.Director(req)
+		pool.Route(req)
+	})
 
 	{ // 初始化路由规则
 		embyServerHandler.routerRules = []RegexpRouteRule{
 			{
+				Name:    "WebSocket",
+				Regexp:  constants.EmbyRegexp.Router.WebSocket,
+				Handler: webSocketHandler(embyServerHandler.webSocket, embyServerHandler.ReverseProxy),
+			},
+			{
+				Name:    "VideosHandler",
 				Regexp:  constants.EmbyRegexp.Router.VideosHandler,
 				Handler: embyServerHandler.VideosHandler,
 			},
 			{
+				Name:    "PlayingReport",
+				Regexp:  constants.EmbyRegexp.Router.PlayingReport,
+				Handler: playingReportHandler(embyServerHandler.name, embyServerHandler.ReverseProxy),
+			},
+			{
+				Name:   "ModifyPlaybackInfo",
 				Regexp: constants.EmbyRegexp.Router.ModifyPlaybackInfo,
 				Handler: responseModifyCreater(
-					&httputil.ReverseProxy{Director: embyServerHandler.proxy.Director},
+					&httputil.ReverseProxy{Director: embyServerHandler.proxy.Director, Transport: embyServerHandler.proxy.Transport},
 					embyServerHandler.ModifyPlaybackInfo,
 				),
 			},
 			{
+				Name:   "ModifyBaseHtmlPlayer",
 				Regexp: constants.EmbyRegexp.Router.ModifyBaseHtmlPlayer,
 				Handler: responseModifyCreater(
-					&httputil.ReverseProxy{Director: embyServerHandler.proxy.Director},
+					&httputil.ReverseProxy{Director: embyServerHandler.proxy.Director, Transport: embyServerHandler.proxy.Transport},
 					embyServerHandler.ModifyBaseHtmlPlayer,
 				),
 			},
 		}
 
-		if config.Web.Enable {
-			if config.Web.Index || config.Web.Head != "" || config.Web.ExternalPlayerUrl || config.Web.VideoTogether {
+		if web := webSetting(name); web.Enable {
+			if web.Index || web.Head != "" || web.ExternalPlayerUrl || web.VideoTogether {
 				embyServerHandler.routerRules = append(embyServerHandler.routerRules,
 					RegexpRouteRule{
+						Name:   "ModifyIndex",
 						Regexp: constants.EmbyRegexp.Router.ModifyIndex,
 						Handler: responseModifyCreater(
-							&httputil.ReverseProxy{Director: embyServerHandler.proxy.Director},
+							&httputil.ReverseProxy{Director: embyServerHandler.proxy.Director, Transport: embyServerHandler.proxy.Transport},
 							embyServerHandler.ModifyIndex,
 						),
 					},
 				)
 			}
 		}
-		if config.Subtitle.Enable && config.Subtitle.SRT2ASS {
+		if config.Get().Subtitle.Enable && config.Get().Subtitle.SRT2ASS {
 			embyServerHandler.routerRules = append(embyServerHandler.routerRules,
 				RegexpRouteRule{
+					Name:   "ModifySubtitles",
 					Regexp: constants.EmbyRegexp.Router.ModifySubtitles,
 					Handler: responseModifyCreater(
-						&httputil.ReverseProxy{Director: embyServerHandler.proxy.Director},
+						&httputil.ReverseProxy{Director: embyServerHandler.proxy.Director, Transport: embyServerHandler.proxy.Transport},
 						embyServerHandler.ModifySubtitles,
 					),
 				},
@@ -87,6 +121,11 @@ func NewEmbyServerHandler(addr string, apiKey string) (*EmbyServerHandler, error
 	return &embyServerHandler, nil
 }
 
+// 上游媒体服务器名称
+func (embyServerHandler *EmbyServerHandler) Name() string {
+	return embyServerHandler.name
+}
+
 // 转发请求至上游服务器
 func (embyServerHandler *EmbyServerHandler) ReverseProxy(rw http.ResponseWriter, req *http.Request) {
 	embyServerHandler.proxy.ServeHTTP(rw, req)
@@ -97,10 +136,106 @@ func (embyServerHandler *EmbyServerHandler) GetRegexpRouteRules() []RegexpRouteR
 	return embyServerHandler.routerRules
 }
 
+// 分页获取 Strm 条目
+//
+// 返回当前页中的 Strm 条目以及媒体库中视频条目的总数
+func (embyServerHandler *EmbyServerHandler) ListStrmItems(startIndex int, limit int) ([]StrmItem, int, error) {
+	itemResponse, err := embyServerHandler.server.ItemsServiceQueryVideoItems(startIndex, limit, "Path,MediaSources")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if itemResponse.TotalRecordCount != nil {
+		total = int(*itemResponse.TotalRecordCount)
+	}
+	return embyStrmItems(embyServerHandler.name, itemResponse.Items), total, nil
+}
+
+// 获取指定条目的 Strm 条目
+//
+// 条目不是 Strm 文件时返回空列表
+func (embyServerHandler *EmbyServerHandler) GetStrmItems(itemID string) ([]StrmItem, error) {
+	itemResponse, err := embyServerHandler.server.ItemsServiceQueryItem(itemID, 1, "Path,MediaSources")
+	if err != nil {
+		return nil, err
+	}
+	return embyStrmItems(embyServerHandler.name, itemResponse.Items), nil
+}
+
+// 从条目列表中筛选出 Strm 条目
+//
+// 每个媒体源对应一个 Strm 条目
+func embyStrmItems(upstream string, items []emby.BaseItemDto) []StrmItem {
+	var strmItems []StrmItem
+	for _, item := range items {
+		if item.ID == nil || item.Path == nil || !strings.HasSuffix(strings.ToLower(*item.Path), ".strm") {
+			continue
+		}
+		var name string
+		if item.Name != nil {
+			name = *item.Name
+		}
+		for _, mediasource := range item.MediaSources {
+			if mediasource.Path == nil {
+				continue
+			}
+			strmItems = append(strmItems, StrmItem{
+				Upstream: upstream,
+				ItemID:   *item.ID,
+				Name:     name,
+				Path:     *item.Path,
+				Target:   *mediasource.Path,
+			})
+		}
+	}
+	return strmItems
+}
+
+// 获取媒体库列表
+func (embyServerHandler *EmbyServerHandler) ListLibraries() ([]Library, error) {
+	virtualFolders, err := embyServerHandler.server.LibraryServiceGetVirtualFolders()
+	if err != nil {
+		return nil, err
+	}
+
+	libraries := make([]Library, 0, len(virtualFolders))
+	for _, folder := range virtualFolders {
+		libraries = append(libraries, Library{
+			ID:        utils.Deref(folder.ItemID),
+			Name:      utils.Deref(folder.Name),
+			Locations: folder.Locations,
+		})
+	}
+	return libraries, nil
+}
+
+// 获取访问令牌对应的用户
+//
+// 令牌无效或用户被禁用时返回 ErrInvalidToken
+func (embyServerHandler *EmbyServerHandler) GetUser(token string) (*MediaServerUser, error) {
+	user, err := embyServerHandler.server.UsersServiceGetMe(token)
+	if errors.Is(err, emby.ErrUnauthorized) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Policy != nil && user.Policy.IsDisabled {
+		return nil, ErrInvalidToken
+	}
+	return &MediaServerUser{
+		ID:              utils.Deref(user.ID),
+		Name:            utils.Deref(user.Name),
+		IsAdministrator: user.Policy != nil && user.Policy.IsAdministrator,
+	}, nil
+}
+
 // 修改播放信息请求
 //
 // /Items/:itemId/PlaybackInfo
 // 强制将 HTTPStrm 设置为支持直链播放和转码、AlistStrm 设置为支持直链播放并且禁止转码
+// 启用访问策略时按策略移除禁止播放的媒体源、强制转码或强制直链播放
 func (embyServerHandler *EmbyServerHandler) ModifyPlaybackInfo(rw *http.Response) error {
 	defer rw.Body.Close()
 	body, err := readBody(rw)
@@ -115,6 +250,15 @@ func (embyServerHandler *EmbyServerHandler) ModifyPlaybackInfo(rw *http.Response
 		return err
 	}
 
+	var (
+		user          policyUser
+		client        = utils.GetClientInfo(rw.Request)
+		deniedSources = make(map[string]bool) // 访问策略禁止播放的媒体源
+		limitErr      error                   // 超过播放限制的原因
+	)
+	if config.Get().Policy.Enable || config.Get().Limit.Enable {
+		user = requestUser(rw.Request)
+	}
 	for index, mediasource := range playbackInfoResponse.MediaSources {
 		logging.Debug("请求 ItemsServiceQueryItem：" + *mediasource.ID)
 		itemResponse, err := embyServerHandler.server.ItemsServiceQueryItem(strings.Replace(*mediasource.ID, "mediasource_", "", 1), 1, "Path,MediaSources") // 查询 item 需要去除前缀仅保留数字部分
@@ -123,10 +267,35 @@ func (embyServerHandler *EmbyServerHandler) ModifyPlaybackInfo(rw *http.Response
 			continue
 		}
 		item := itemResponse.Items[0]
-		strmFileType, opt := recgonizeStrmFileType(*item.Path)
+		strmFileType, rule := recgonizeStrmFileType(embyServerHandler.name, *item.Path)
+		action := constants.PolicyAllow
+		if strmFileType != constants.UnknownStrm {
+			action = evaluatePolicy(embyServerHandler, user, *item.Path)
+		}
+		switch action {
+		case constants.PolicyDeny:
+			logging.Infof("访问策略禁止用户 %s（%s）播放：%s", user.Name, user.ID, *mediasource.Name)
+			deniedSources[*mediasource.ID] = true
+			continue
+		case constants.PolicyTranscode:
+			*playbackInfoResponse.MediaSources[index].SupportsDirectPlay = false
+			*playbackInfoResponse.MediaSources[index].SupportsDirectStream = false
+			logging.Infof("访问策略要求用户 %s（%s）由媒体服务器转码播放：%s", user.Name, user.ID, *mediasource.Name)
+			continue
+		}
+		if strmFileType != constants.UnknownStrm {
+			if err := checkStreamLimit(embyServerHandler.name, user, client, utils.Deref(playbackInfoResponse.PlaySessionID), *mediasource.ID, action); err != nil {
+				logging.Infof("用户 %s（%s）设备 %s 播放 %s 失败：%s", user.Name, user.ID, client.Device, *mediasource.Name, err)
+				limitErr = err
+				continue
+			}
+		}
+		if strmFileType != constants.UnknownStrm && playbackInfoResponse.PlaySessionID != nil {
+			sessionTracker.preparing(embyServerHandler.name, client, user, *playbackInfoResponse.PlaySessionID, newEmbySessionMedia(item, mediasource, strmFileType))
+		}
 		switch strmFileType {
 		case constants.HTTPStrm: // HTTPStrm 设置支持直链播放并且支持转码
-			if !config.HTTPStrm.TransCode {
+			if !httpStrmSetting(embyServerHandler.name).TransCode || action == constants.PolicyRedirect {
 				*playbackInfoResponse.MediaSources[index].SupportsDirectPlay = true
 				*playbackInfoResponse.MediaSources[index].SupportsDirectStream = true
 				playbackInfoResponse.MediaSources[index].TranscodingURL = nil
@@ -139,13 +308,14 @@ func (embyServerHandler *EmbyServerHandler) ModifyPlaybackInfo(rw *http.Response
 						continue
 					}
 					directStreamURL := fmt.Sprintf("/videos/%s/stream?MediaSourceId=%s&Static=true&%s", *mediasource.ItemID, *mediasource.ID, apikeypair)
+					directStreamURL = withSessionParams(directStreamURL, *mediasource.DirectStreamURL)
 					playbackInfoResponse.MediaSources[index].DirectStreamURL = &directStreamURL
 					logging.Infof("%s 强制禁止转码，直链播放链接为：%s", *mediasource.Name, directStreamURL)
 				}
 			}
 
 		case constants.AlistStrm: // AlistStm 设置支持直链播放并且禁止转码
-			if !config.AlistStrm.TransCode {
+			if !alistStrmSetting(embyServerHandler.name).TransCode || action == constants.PolicyRedirect {
 				*playbackInfoResponse.MediaSources[index].SupportsDirectPlay = true
 				*playbackInfoResponse.MediaSources[index].SupportsDirectStream = true
 				*playbackInfoResponse.MediaSources[index].SupportsTranscoding = false
@@ -158,6 +328,7 @@ func (embyServerHandler *EmbyServerHandler) ModifyPlaybackInfo(rw *http.Response
 					continue
 				}
 				directStreamURL := fmt.Sprintf("/videos/%s/stream?MediaSourceId=%s&Static=true&%s", *mediasource.ItemID, *mediasource.ID, apikeypair)
+				directStreamURL = withSessionParams(directStreamURL, *mediasource.DirectStreamURL)
 				playbackInfoResponse.MediaSources[index].DirectStreamURL = &directStreamURL
 				container := strings.TrimPrefix(path.Ext(*mediasource.Path), ".")
 				playbackInfoResponse.MediaSources[index].Container = &container
@@ -167,12 +338,12 @@ func (embyServerHandler *EmbyServerHandler) ModifyPlaybackInfo(rw *http.Response
 			}
 
 			if playbackInfoResponse.MediaSources[index].Size == nil {
-				alistServer, err := service.GetAlistServer(opt.(string))
+				alistServer, err := service.GetAlistServer(rule.alistAddr)
 				if err != nil {
 					logging.Warning("获取 AlistServer 失败：", err)
 					continue
 				}
-				fsGetData, err := alistServer.FsGet(*mediasource.Path)
+				fsGetData, err := alistServer.FsGet(rule.rewrite(*mediasource.Path))
 				if err != nil {
 					logging.Warning("请求 FsGet 失败：", err)
 					continue
@@ -183,6 +354,23 @@ func (embyServerHandler *EmbyServerHandler) ModifyPlaybackInfo(rw *http.Response
 		}
 	}
 
+	if limitErr != nil {
+		errorCode := emby.RateLimitExceeded
+		if errors.Is(limitErr, ErrQuotaExceeded) {
+			errorCode = emby.NotAllowed
+		}
+		playbackInfoResponse.ErrorCode = &errorCode
+		playbackInfoResponse.MediaSources = nil
+	} else if len(deniedSources) > 0 {
+		playbackInfoResponse.MediaSources = slices.DeleteFunc(playbackInfoResponse.MediaSources, func(mediasource emby.MediaSourceInfo) bool {
+			return deniedSources[*mediasource.ID]
+		})
+		if len(playbackInfoResponse.MediaSources) == 0 {
+			errorCode := emby.NotAllowed
+			playbackInfoResponse.ErrorCode = &errorCode
+		}
+	}
+
 	body, err = json.Marshal(playbackInfoResponse)
 	if err != nil {
 		logging.Warning("序列化 emby.PlaybackInfoResponse Json 错误：", err)
@@ -202,13 +390,16 @@ func (embyServerHandler *EmbyServerHandler) VideosHandler(ctx *gin.Context) {
 		logging.Debug("VideosHandler 不处理 HEAD 请求，转发至上游服务器")
 		return
 	}
+	if !RequireAuth(ctx) { // 在解析 Strm 之前拒绝未认证的请求
+		return
+	}
 
 	orginalPath := ctx.Request.URL.Path
 	matches := constants.EmbyRegexp.Others.VideoRedirectReg.FindStringSubmatch(orginalPath)
 	if len(matches) == 2 {
 		redirectPath := fmt.Sprintf("/videos/%s/stream", matches[0])
 		logging.Debugf("%s 重定向至：%s", orginalPath, redirectPath)
-		ctx.Redirect(http.StatusFound, redirectPath)
+		ctx.Redirect(http.StatusFound, withPathPrefix(ctx.Request, redirectPath))
 		return
 	}
 
@@ -232,16 +423,20 @@ func (embyServerHandler *EmbyServerHandler) VideosHandler(ctx *gin.Context) {
 		return
 	}
 
-	strmFileType, opt := recgonizeStrmFileType(*item.Path)
+	strmFileType, rule := recgonizeStrmFileType(embyServerHandler.name, *item.Path)
 	for _, mediasource := range item.MediaSources {
 		if *mediasource.ID == mediaSourceID { // EmbyServer >= 4.9 返回的ID带有前缀mediasource_
+			decision, ok := applyStreamPolicy(ctx, newEmbySessionMedia(item, mediasource, strmFileType), embyServerHandler.ReverseProxy)
+			if !ok {
+				return
+			}
 			switch strmFileType {
 			case constants.HTTPStrm:
 				if *mediasource.Protocol == emby.HTTP {
-					redirectURL := *mediasource.Path
-					if config.HTTPStrm.FinalURL {
+					redirectURL := rule.httpStrmURL(*mediasource.Path)
+					if setting := httpStrmSetting(embyServerHandler.name); setting.FinalURL {
 						logging.Debug("HTTPStrm 启用获取最终 URL，开始尝试获取最终 URL")
-						if finalURL, err := getFinalURL(redirectURL, ctx.Request.UserAgent()); err != nil {
+						if finalURL, err := getFinalURL(redirectURL, ctx.Request.UserAgent(), setting.FinalURLCache); err != nil {
 							logging.Warning("获取最终 URL 失败，使用原始 URL：", err)
 						} else {
 							redirectURL = finalURL
@@ -250,32 +445,39 @@ func (embyServerHandler *EmbyServerHandler) VideosHandler(ctx *gin.Context) {
 						logging.Debug("HTTPStrm 未启用获取最终 URL，直接使用原始 URL")
 					}
 					logging.Info("HTTPStrm 重定向至：", redirectURL)
-					ctx.Redirect(http.StatusFound, redirectURL)
+					metrics.StrmRedirects.Inc(string(constants.HTTPStrm), urlHost(redirectURL))
+					strmRedirected(ctx, newEmbySessionMedia(item, mediasource, strmFileType), "", redirectURL)
+					respondStrm(ctx, decision, redirectURL)
 				}
 				return
 			case constants.AlistStrm: // 无需判断 *mediasource.Container 是否以Strm结尾，当 AlistStrm 存储的位置有对应的文件时，*mediasource.Container 会被设置为文件后缀
-				alistServerAddr := opt.(string)
+				alistServerAddr := rule.alistAddr
+				alistPath := rule.rewrite(*mediasource.Path)
 				alistServer, err := service.GetAlistServer(alistServerAddr)
 				if err != nil {
 					logging.Warning("获取 AlistServer 失败：", err)
+					strmFailed(ctx, newEmbySessionMedia(item, mediasource, strmFileType), alistServerAddr, err)
+					ctx.String(http.StatusInternalServerError, err.Error())
 					return
 				}
-				fsGetData, err := alistServer.FsGet(*mediasource.Path)
+				fsGetData, err := alistServer.FsGet(alistPath)
 				if err != nil {
-					logging.Warning("请求 FsGet 失败：", err)
+					statusCode := alistErrorStatusCode(err)
+					logging.Warningf("请求 FsGet 失败（响应状态码：%d）：%s", statusCode, err)
+					strmFailed(ctx, newEmbySessionMedia(item, mediasource, strmFileType), alistServerAddr, err)
+					ctx.String(statusCode, err.Error())
 					return
 				}
 				var redirectURL string
-				if config.AlistStrm.RawURL {
+				if alistStrmSetting(embyServerHandler.name).RawURL {
 					redirectURL = fsGetData.RawURL
 				} else {
-					redirectURL = fmt.Sprintf("%s/d%s", alistServerAddr, *mediasource.Path)
-					if fsGetData.Sign != "" {
-						redirectURL += "?sign=" + fsGetData.Sign
-					}
+					redirectURL = alistDownloadURL(alistServerAddr, alistPath, fsGetData)
 				}
 				logging.Info("AlistStrm 重定向至：", redirectURL)
-				ctx.Redirect(http.StatusFound, redirectURL)
+				metrics.StrmRedirects.Inc(string(constants.AlistStrm), alistServer.GetEndpoint())
+				strmRedirected(ctx, newEmbySessionMedia(item, mediasource, strmFileType), alistServerAddr, redirectURL)
+				respondStrm(ctx, decision, redirectURL)
 				return
 			case constants.UnknownStrm:
 				embyServerHandler.ReverseProxy(ctx.Writer, ctx.Request)
@@ -298,9 +500,9 @@ func (embyServerHandler *EmbyServerHandler) ModifySubtitles(rw *http.Response) e
 
 	if utils.IsSRT(subtitile) { // 判断是否为 SRT 格式
 		logging.Info("字幕文件为 SRT 格式")
-		if config.Subtitle.SRT2ASS {
+		if config.Get().Subtitle.SRT2ASS {
 			logging.Info("已将 SRT 字幕已转为 ASS 格式")
-			assSubtitle := utils.SRT2ASS(subtitile, config.Subtitle.ASSStyle)
+			assSubtitle := utils.SRT2ASS(subtitile, config.Get().Subtitle.ASSStyle)
 			return updateBody(rw, assSubtitle)
 		}
 	}
@@ -324,6 +526,7 @@ func (embyServerHandler *EmbyServerHandler) ModifyBaseHtmlPlayer(rw *http.Respon
 
 // 修改首页函数
 func (embyServerHandler *EmbyServerHandler) ModifyIndex(rw *http.Response) error {
+	web := webSetting(embyServerHandler.name)
 	var (
 		htmlFilePath string = path.Join(config.CostomDir(), "index.html")
 		htmlContent  []byte
@@ -331,8 +534,8 @@ func (embyServerHandler *EmbyServerHandler) ModifyIndex(rw *http.Response) error
 		err          error
 	)
 
-	defer rw.Body.Close()  // 无论哪种情况，最终都要确保原 Body 被关闭，避免内存泄漏
-	if !config.Web.Index { // 从上游获取响应体
+	defer rw.Body.Close() // 无论哪种情况，最终都要确保原 Body 被关闭，避免内存泄漏
+	if !web.Index {       // 从上游获取响应体
 		if htmlContent, err = readBody(rw); err != nil {
 			return err
 		}
@@ -343,29 +546,29 @@ func (embyServerHandler *EmbyServerHandler) ModifyIndex(rw *http.Response) error
 		}
 	}
 
-	if config.Web.Head != "" { // 用户自定义HEAD
-		addHEAD = append(addHEAD, []byte(config.Web.Head+"\n")...)
+	if web.Head != "" { // 用户自定义HEAD
+		addHEAD = append(addHEAD, []byte(web.Head+"\n")...)
 	}
-	if config.Web.ExternalPlayerUrl { // 外部播放器
+	if web.ExternalPlayerUrl { // 外部播放器
 		addHEAD = append(addHEAD, []byte(`<script src="/MediaWarp/static/embyExternalUrl/embyWebAddExternalUrl/embyLaunchPotplayer.js"></script>`+"\n")...)
 	}
-	if config.Web.Crx { // crx 美化
+	if web.Crx { // crx 美化
 		addHEAD = append(addHEAD, []byte(`<link rel="stylesheet" id="theme-css" href="/MediaWarp/static/emby-crx/static/css/style.css" type="text/css" media="all" />
     <script src="/MediaWarp/static/emby-crx/static/js/common-utils.js"></script>
     <script src="/MediaWarp/static/emby-crx/static/js/jquery-3.6.0.min.js"></script>
     <script src="/MediaWarp/static/emby-crx/static/js/md5.min.js"></script>
     <script src="/MediaWarp/static/emby-crx/content/main.js"></script>`+"\n")...)
 	}
-	if config.Web.ActorPlus { // 过滤没有头像的演员和制作人员
+	if web.ActorPlus { // 过滤没有头像的演员和制作人员
 		addHEAD = append(addHEAD, []byte(`<script src="/MediaWarp/static/emby-web-mod/actorPlus/actorPlus.js"></script>`+"\n")...)
 	}
-	if config.Web.FanartShow { // 显示同人图（fanart图）
+	if web.FanartShow { // 显示同人图（fanart图）
 		addHEAD = append(addHEAD, []byte(`<script src="/MediaWarp/static/emby-web-mod/fanart_show/fanart_show.js"></script>`+"\n")...)
 	}
-	if config.Web.Danmaku { // 弹幕
+	if web.Danmaku { // 弹幕
 		addHEAD = append(addHEAD, []byte(`<script src="/MediaWarp/static/dd-danmaku/ede.js" defer></script>`+"\n")...)
 	}
-	if config.Web.VideoTogether { // VideoTogether
+	if web.VideoTogether { // VideoTogether
 		addHEAD = append(addHEAD, []byte(`<script src="https://2gether.video/release/extension.website.user.js"></script>`+"\n")...)
 	}
 	htmlContent = bytes.Replace(htmlContent, []byte("</head>"), append(addHEAD, []byte("</head>")...), 1) // 将添加HEAD
@@ -0,0 +1,32 @@
+package handler
+
+import "MediaWarp/utils"
+
+type (
+	PolicyUser   = policyUser
+	SessionMedia = sessionMedia
+)
+
+var ApplyStreamPolicy = applyStreamPolicy
+
+func PreparePlayback(server string, client utils.ClientInfo, user PolicyUser, playSessionID string, media SessionMedia) {
+	sessionTracker.preparing(server, client, user, playSessionID, media)
+}
+
+func RedirectPlayback(server string, client utils.ClientInfo, user PolicyUser, playSessionID string, media SessionMedia) {
+	sessionTracker.redirected(server, client, user, playSessionID, media, "")
+}
+
+func AddQuotaUsage(user PolicyUser, n int64) {
+	quotaUsage.add(user, n)
+}
+
+// 清空播放会话和代理流量统计
+func ResetPlayback() {
+	sessionTracker.mutex.Lock()
+	clear(sessionTracker.sessions)
+	sessionTracker.mutex.Unlock()
+	quotaUsage.mutex.Lock()
+	clear(quotaUsage.usage)
+	quotaUsage.mutex.Unlock()
+}
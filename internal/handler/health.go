@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/server"
+	"MediaWarp/utils"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	readinessCacheTTL      = 10 * time.Second // 就绪检查结果的缓存时间
+	mediaServerPingTimeout = 5 * time.Second  // 检测媒体服务器是否可用的超时时间
+)
+
+// 单项就绪检查结果
+type readinessCheck struct {
+	Name    string `json:"Name"`
+	Ready   bool   `json:"Ready"`
+	Latency int64  `json:"Latency"` // 响应耗时（毫秒）
+	Error   string `json:"Error,omitempty"`
+}
+
+// 就绪检查结果
+type readinessReport struct {
+	Ready     bool             `json:"Ready"`
+	Checks    []readinessCheck `json:"Checks"`
+	CheckedAt time.Time        `json:"CheckedAt"`
+}
+
+// 缓存的就绪检查结果
+var readinessCache struct {
+	mutex   sync.Mutex // 保护 report，检查期间不持有
+	refresh sync.Mutex // 同一时间只进行一次检查，其余请求等待检查结果
+	report  readinessReport
+}
+
+// 检测媒体服务器是否可用使用的 HTTP 客户端
+var pingClient = &http.Client{Timeout: mediaServerPingTimeout}
+
+// 检测媒体服务器是否可用
+//
+// 请求无需认证的 /System/Info/Public 接口
+func pingMediaServer(addr string) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(utils.GetEndpoint(addr), "/")+"/System/Info/Public", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	startTime := time.Now()
+	resp, err := pingClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("/System/Info/Public 响应状态码 %d", resp.StatusCode)
+	}
+	return time.Since(startTime), nil
+}
+
+// 检查媒体服务器是否可用
+//
+// 同时检查 ADDR 和 Backups，任一地址可用即视为可用，延迟取第一个可用的地址
+func checkMediaServer(name string, setting config.MediaServerSetting) readinessCheck {
+	addrs := append([]string{setting.ADDR}, setting.Backups...)
+	latencies := make([]time.Duration, len(addrs))
+	errs := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			latencies[i], errs[i] = pingMediaServer(addr)
+		}()
+	}
+	wg.Wait()
+
+	check := readinessCheck{Name: name}
+	var messages []string
+	for i, addr := range addrs {
+		if errs[i] == nil {
+			check.Ready = true
+			check.Latency = latencies[i].Milliseconds()
+			return check
+		}
+		messages = append(messages, fmt.Sprintf("%s：%s", addr, errs[i]))
+	}
+	check.Error = strings.Join(messages, "；")
+	return check
+}
+
+// 检查媒体服务器（包括上游媒体服务器）和所有 Alist 服务器是否可用
+//
+// 各服务器同时检查；结果缓存 readinessCacheTTL，避免频繁的健康检查请求压垮上游服务器
+func checkReadiness() readinessReport {
+	if report, ok := cachedReadiness(); ok {
+		return report
+	}
+	readinessCache.refresh.Lock()
+	defer readinessCache.refresh.Unlock()
+	if report, ok := cachedReadiness(); ok { // 等待期间其他请求已完成检查
+		return report
+	}
+
+	settings := config.Get()
+	mediaServerChecks := make([]readinessCheck, 1+len(settings.Upstreams))
+	var (
+		wg            sync.WaitGroup
+		alistStatuses []alistServerStatus
+	)
+	wg.Add(2 + len(settings.Upstreams))
+	go func() {
+		defer wg.Done()
+		mediaServerChecks[0] = checkMediaServer(string(settings.MediaServer.Type), settings.MediaServer)
+	}()
+	for i, upstream := range settings.Upstreams {
+		go func() {
+			defer wg.Done()
+			mediaServerChecks[i+1] = checkMediaServer(fmt.Sprintf("%s %s", upstream.Type, upstream.Name), upstream.MediaServerSetting)
+		}()
+	}
+	go func() {
+		defer wg.Done()
+		alistStatuses = alistServerStatuses()
+	}()
+	wg.Wait()
+
+	report := readinessReport{Ready: true, Checks: mediaServerChecks, CheckedAt: time.Now()}
+	for _, status := range alistStatuses {
+		report.Checks = append(report.Checks, readinessCheck{
+			Name:    "Alist " + status.Endpoint,
+			Ready:   status.Available,
+			Latency: status.Latency,
+			Error:   status.Error,
+		})
+	}
+	for _, check := range report.Checks {
+		report.Ready = report.Ready && check.Ready
+	}
+	readinessCache.mutex.Lock()
+	readinessCache.report = report
+	readinessCache.mutex.Unlock()
+	return report
+}
+
+// 获取未过期的就绪检查结果
+func cachedReadiness() (readinessReport, bool) {
+	readinessCache.mutex.Lock()
+	defer readinessCache.mutex.Unlock()
+	report := readinessCache.report
+	return report, time.Since(report.CheckedAt) < readinessCacheTTL
+}
+
+// 存活检查
+//
+// GET /MediaWarp/healthz
+// 进程正在运行即返回 200
+func HealthzHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"Status": "OK"})
+}
+
+// 就绪检查
+//
+// GET /MediaWarp/readyz
+// 媒体服务器和所有 Alist 服务器均可用时返回 200，否则返回 503，正在退出时直接返回 503
+func ReadyzHandler(ctx *gin.Context) {
+	if !server.Ready() {
+		ctx.JSON(http.StatusServiceUnavailable, readinessReport{
+			Checks:    []readinessCheck{{Name: "MediaWarp", Error: "MediaWarp 正在退出"}},
+			CheckedAt: time.Now(),
+		})
+		return
+	}
+	report := checkReadiness()
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	ctx.JSON(status, report)
+}
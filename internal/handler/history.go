@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/history"
+	"MediaWarp/internal/logging"
+	"MediaWarp/utils"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultHistoryLimit = 100 // 查询播放历史时默认返回的记录数量
+
+var ErrInvalidStatsGroup = errors.New("错误的统计分组，可选值：user、library、client、item")
+
+// 记录 Strm 重定向
+//
+// 设置访问日志中的重定向目标、更新播放会话，并在会话首次重定向时写入播放历史
+func strmRedirected(ctx *gin.Context, media sessionMedia, alistServer string, redirectURL string) {
+	ctx.Set(logging.StrmTargetContextKey, redirectURL)
+	session, first := sessionTracker.redirected(mediaServerNameOf(ctx.Request), streamClientInfo(ctx), streamUser(ctx), ctx.Query("playsessionid"), media, redirectURL)
+	if first {
+		recordPlayback(MediaServerOf(ctx.Request), session, media, alistServer, redirectURL, nil)
+	}
+}
+
+// 记录 Strm 目标地址解析失败
+//
+// 优先使用已有播放会话中的用户信息（视频流请求通常不携带用户 ID）
+func strmFailed(ctx *gin.Context, media sessionMedia, alistServer string, err error) {
+	client := streamClientInfo(ctx)
+	session, ok := sessionTracker.get(mediaServerNameOf(ctx.Request), client, ctx.Query("playsessionid"), media.MediaSourceID)
+	if !ok {
+		session.updateClient(client)
+	}
+	recordPlayback(MediaServerOf(ctx.Request), session, media, alistServer, "", err)
+}
+
+// 视频流请求的客户端信息
+//
+// 视频流请求通常不携带用户 ID，使用认证或应用访问策略时得到的用户 ID
+func streamClientInfo(ctx *gin.Context) utils.ClientInfo {
+	client := utils.GetClientInfo(ctx.Request)
+	if client.UserID == "" {
+		if user, ok := GetAuthenticatedUser(ctx); ok && user.ID != "" {
+			client.UserID = user.ID
+		} else {
+			client.UserID = streamUser(ctx).ID
+		}
+	}
+	return client
+}
+
+// 写入播放历史
+//
+// 查询媒体库可能需要请求上游服务器，在后台进行
+func recordPlayback(mediaServer MediaServerHandler, session PlaybackSession, media sessionMedia, alistServer string, source string, err error) {
+	if history.GetStore() == nil {
+		return
+	}
+	record := history.Record{
+		Time:          time.Now(),
+		UserID:        session.UserID,
+		Client:        session.Client,
+		Device:        session.Device,
+		DeviceID:      session.DeviceID,
+		ItemID:        media.ItemID,
+		MediaSourceID: media.MediaSourceID,
+		Name:          media.Name,
+		Path:          media.Path,
+		StrmType:      media.StrmType,
+		Source:        source,
+		AlistServer:   alistServer,
+		Outcome:       constants.PlaybackRedirected,
+	}
+	if err != nil {
+		record.Outcome = constants.PlaybackFailed
+		record.Error = err.Error()
+	}
+	go func() {
+		record.Library = libraryOf(mediaServer, record.Path)
+		history.Add(record)
+	}()
+}
+
+// 解析播放历史查询条件
+func parseHistoryQuery(ctx *gin.Context) (history.Query, error) {
+	query := history.Query{
+		UserID:   ctx.Query("userid"),
+		ItemID:   ctx.Query("itemid"),
+		Library:  ctx.Query("library"),
+		DeviceID: ctx.Query("deviceid"),
+		Outcome:  constants.PlaybackOutcome(ctx.Query("outcome")),
+		Limit:    defaultHistoryLimit,
+	}
+	for _, param := range []struct {
+		key    string
+		target *time.Time
+	}{
+		{"since", &query.Since},
+		{"until", &query.Until},
+	} {
+		if value := ctx.Query(param.key); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return query, errors.New(param.key + " 参数需为 RFC3339 格式的时间")
+			}
+			*param.target = t
+		}
+	}
+	for _, param := range []struct {
+		key    string
+		target *int
+	}{
+		{"offset", &query.Offset},
+		{"limit", &query.Limit},
+	} {
+		if value := ctx.Query(param.key); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return query, errors.New(param.key + " 参数需为非负整数")
+			}
+			*param.target = n
+		}
+	}
+	return query, nil
+}
+
+// 查询播放历史
+//
+// GET /MediaWarp/api/history
+// 支持 userId、itemId、library、deviceId、outcome、since、until、offset、limit 参数
+func HistoryHandler(ctx *gin.Context) {
+	store := history.GetStore()
+	if store == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": history.ErrDisabled.Error()})
+		return
+	}
+	query, err := parseHistoryQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	records, total, err := store.Query(query)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"Items": records, "TotalRecordCount": total})
+}
+
+// 播放统计
+//
+// GET /MediaWarp/api/history/stats?by=user|library|client|item
+// 支持与查询播放历史相同的过滤参数
+func HistoryStatsHandler(ctx *gin.Context) {
+	store := history.GetStore()
+	if store == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": history.ErrDisabled.Error()})
+		return
+	}
+	query, err := parseHistoryQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var keyFn func(record *history.Record) string
+	switch ctx.DefaultQuery("by", "user") {
+	case "user":
+		keyFn = func(record *history.Record) string { return record.UserID }
+	case "library":
+		keyFn = func(record *history.Record) string { return record.Library }
+	case "client":
+		keyFn = func(record *history.Record) string { return record.Client }
+	case "item":
+		keyFn = func(record *history.Record) string { return record.Name }
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidStatsGroup.Error()})
+		return
+	}
+
+	stats, err := store.Stats(query, keyFn)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, stats)
+}
@@ -2,19 +2,25 @@ package handler
 
 import (
 	"MediaWarp/constants"
+	"MediaWarp/internal/balancer"
 	"MediaWarp/internal/config"
 	"MediaWarp/internal/logging"
+	"MediaWarp/internal/metrics"
 	"MediaWarp/internal/service"
 	"MediaWarp/internal/service/jellyfin"
+	"MediaWarp/internal/wsproxy"
 	"MediaWarp/utils"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"path"
+	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -22,42 +28,69 @@ import (
 
 // Jellyfin 服务器处理器
 type JellyfinHandler struct {
+	name        string                 // 上游媒体服务器名称，MediaServer 为空字符串
 	server      *jellyfin.Jellyfin     // Jellyfin 服务器
 	routerRules []RegexpRouteRule      // 正则路由规则
 	proxy       *httputil.ReverseProxy // 反向代理
+	webSocket   *wsproxy.Proxy         // WebSocket 代理
 }
 
-func NewJellyfinHander(addr string, apiKey string) (*JellyfinHandler, error) {
-	jellyfinHandler := JellyfinHandler{}
-	jellyfinHandler.server = jellyfin.New(addr, apiKey)
+// 初始化
+//
+// ctx 取消时停止地址池的健康检查
+func NewJellyfinHander(ctx context.Context, name string, setting config.MediaServerSetting) (*JellyfinHandler, error) {
+	jellyfinHandler := JellyfinHandler{name: name}
+	pool, err := balancer.New(name, setting)
+	if err != nil {
+		return nil, err
+	}
+	go pool.RunHealthCheck(ctx)
+	jellyfinHandler.server = jellyfin.New(setting.ADDR, setting.AUTH, pool.Client())
 	target, err := url.Parse(jellyfinHandler.server.GetEndpoint())
 	if err != nil {
 		return nil, err
 	}
-	jellyfinHandler.proxy = httputil.NewSingleHostReverseProxy(target)
+	jellyfinHandler.proxy = newReverseProxy(target, pool)
+	jellyfinHandler.webSocket = newWebSocketProxy(target, func(req *http.Request) {
+		jellyfinHandler.proxy.Director(req)
+		pool.Route(req)
+	})
 
 	{ // 初始化路由规则
 		jellyfinHandler.routerRules = []RegexpRouteRule{
 			{
+				Name:    "WebSocket",
+				Regexp:  constants.JellyfinRegexp.Router.WebSocket,
+				Handler: webSocketHandler(jellyfinHandler.webSocket, jellyfinHandler.ReverseProxy),
+			},
+			{
+				Name:   "ModifyPlaybackInfo",
 				Regexp: constants.JellyfinRegexp.Router.ModifyPlaybackInfo,
 				Handler: responseModifyCreater(
-					&httputil.ReverseProxy{Director: jellyfinHandler.proxy.Director},
+					&httputil.ReverseProxy{Director: jellyfinHandler.proxy.Director, Transport: jellyfinHandler.proxy.Transport},
 					jellyfinHandler.ModifyPlaybackInfo,
 				),
 			},
 			{
+				Name:    "VideosHandler",
 				Regexp:  constants.JellyfinRegexp.Router.VideosHandler,
 				Handler: jellyfinHandler.VideosHandler,
 			},
+			{
+				Name:    "PlayingReport",
+				Regexp:  constants.JellyfinRegexp.Router.PlayingReport,
+				Handler: playingReportHandler(jellyfinHandler.name, jellyfinHandler.ReverseProxy),
+			},
 		}
-		if config.Web.Enable {
-			if config.Web.Index || config.Web.Head != "" || config.Web.ExternalPlayerUrl || config.Web.VideoTogether {
+		if web := webSetting(name); web.Enable {
+			if web.Index || web.Head != "" || web.ExternalPlayerUrl || web.VideoTogether {
 				jellyfinHandler.routerRules = append(
 					jellyfinHandler.routerRules,
 					RegexpRouteRule{
+						Name:   "ModifyIndex",
 						Regexp: constants.JellyfinRegexp.Router.ModifyIndex,
 						Handler: responseModifyCreater(
-							&httputil.ReverseProxy{Director: jellyfinHandler.proxy.Director},
+							&httputil.ReverseProxy{Director: jellyfinHandler.proxy.Director, Transport: jellyfinHandler.proxy.Transport},
 							jellyfinHandler.ModifyIndex,
 						),
 					},
@@ -68,6 +101,11 @@ func NewJellyfinHander(addr string, apiKey string) (*JellyfinHandler, error) {
 	return &jellyfinHandler, nil
 }
 
+// 上游媒体服务器名称
+func (jellyfinHandler *JellyfinHandler) Name() string {
+	return jellyfinHandler.name
+}
+
 // 转发请求至上游服务器
 func (jellyfinHandler *JellyfinHandler) ReverseProxy(rw http.ResponseWriter, req *http.Request) {
 	jellyfinHandler.proxy.ServeHTTP(rw, req)
@@ -78,10 +116,106 @@ func (jellyfinHandler *JellyfinHandler) GetRegexpRouteRules() []RegexpRouteRule
 	return jellyfinHandler.routerRules
 }
 
+// 分页获取 Strm 条目
+//
+// 返回当前页中的 Strm 条目以及媒体库中视频条目的总数
+func (jellyfinHandler *JellyfinHandler) ListStrmItems(startIndex int, limit int) ([]StrmItem, int, error) {
+	itemResponse, err := jellyfinHandler.server.ItemsServiceQueryVideoItems(startIndex, limit, "Path,MediaSources")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if itemResponse.TotalRecordCount != nil {
+		total = int(*itemResponse.TotalRecordCount)
+	}
+	return jellyfinStrmItems(jellyfinHandler.name, itemResponse.Items), total, nil
+}
+
+// 获取指定条目的 Strm 条目
+//
+// 条目不是 Strm 文件时返回空列表
+func (jellyfinHandler *JellyfinHandler) GetStrmItems(itemID string) ([]StrmItem, error) {
+	itemResponse, err := jellyfinHandler.server.ItemsServiceQueryItem(itemID, 1, "Path,MediaSources")
+	if err != nil {
+		return nil, err
+	}
+	return jellyfinStrmItems(jellyfinHandler.name, itemResponse.Items), nil
+}
+
+// 从条目列表中筛选出 Strm 条目
+//
+// 每个媒体源对应一个 Strm 条目
+func jellyfinStrmItems(upstream string, items []jellyfin.BaseItemDto) []StrmItem {
+	var strmItems []StrmItem
+	for _, item := range items {
+		if item.ID == nil || item.Path == nil || !strings.HasSuffix(strings.ToLower(*item.Path), ".strm") {
+			continue
+		}
+		var name string
+		if item.Name != nil {
+			name = *item.Name
+		}
+		for _, mediasource := range item.MediaSources {
+			if mediasource.Path == nil {
+				continue
+			}
+			strmItems = append(strmItems, StrmItem{
+				Upstream: upstream,
+				ItemID:   *item.ID,
+				Name:     name,
+				Path:     *item.Path,
+				Target:   *mediasource.Path,
+			})
+		}
+	}
+	return strmItems
+}
+
+// 获取媒体库列表
+func (jellyfinHandler *JellyfinHandler) ListLibraries() ([]Library, error) {
+	virtualFolders, err := jellyfinHandler.server.LibraryServiceGetVirtualFolders()
+	if err != nil {
+		return nil, err
+	}
+
+	libraries := make([]Library, 0, len(virtualFolders))
+	for _, folder := range virtualFolders {
+		libraries = append(libraries, Library{
+			ID:        utils.Deref(folder.ItemID),
+			Name:      utils.Deref(folder.Name),
+			Locations: folder.Locations,
+		})
+	}
+	return libraries, nil
+}
+
+// 获取访问令牌对应的用户
+//
+// 令牌无效或用户被禁用时返回 ErrInvalidToken
+func (jellyfinHandler *JellyfinHandler) GetUser(token string) (*MediaServerUser, error) {
+	user, err := jellyfinHandler.server.UsersServiceGetMe(token)
+	if errors.Is(err, jellyfin.ErrUnauthorized) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Policy != nil && user.Policy.IsDisabled {
+		return nil, ErrInvalidToken
+	}
+	return &MediaServerUser{
+		ID:              utils.Deref(user.ID),
+		Name:            utils.Deref(user.Name),
+		IsAdministrator: user.Policy != nil && user.Policy.IsAdministrator,
+	}, nil
+}
+
 // 修改播放信息请求
 //
 // /Items/:itemId
 // 强制将 HTTPStrm 设置为支持直链播放和转码、AlistStrm 设置为支持直链播放并且禁止转码
+// 启用访问策略时按策略移除禁止播放的媒体源、强制转码或强制直链播放
 func (jellyfinHandler *JellyfinHandler) ModifyPlaybackInfo(rw *http.Response) error {
 	defer rw.Body.Close()
 	data, err := readBody(rw)
@@ -96,6 +230,15 @@ func (jellyfinHandler *JellyfinHandler) ModifyPlaybackInfo(rw *http.Response) er
 		return err
 	}
 
+	var (
+		user          policyUser
+		client        = utils.GetClientInfo(rw.Request)
+		deniedSources = make(map[string]bool) // 访问策略禁止播放的媒体源
+		limitErr      error                   // 超过播放限制的原因
+	)
+	if config.Get().Policy.Enable || config.Get().Limit.Enable {
+		user = requestUser(rw.Request)
+	}
 	for index, mediasource := range playbackInfoResponse.MediaSources {
 		logging.Debug("请求 ItemsServiceQueryItem：" + *mediasource.ID)
 		itemResponse, err := jellyfinHandler.server.ItemsServiceQueryItem(*mediasource.ID, 1, "Path,MediaSources") // 查询 item 需要去除前缀仅保留数字部分
@@ -104,10 +247,35 @@ func (jellyfinHandler *JellyfinHandler) ModifyPlaybackInfo(rw *http.Response) er
 			continue
 		}
 		item := itemResponse.Items[0]
-		strmFileType, opt := recgonizeStrmFileType(*item.Path)
+		strmFileType, rule := recgonizeStrmFileType(jellyfinHandler.name, *item.Path)
+		action := constants.PolicyAllow
+		if strmFileType != constants.UnknownStrm {
+			action = evaluatePolicy(jellyfinHandler, user, *item.Path)
+		}
+		switch action {
+		case constants.PolicyDeny:
+			logging.Infof("访问策略禁止用户 %s（%s）播放：%s", user.Name, user.ID, *mediasource.Name)
+			deniedSources[*mediasource.ID] = true
+			continue
+		case constants.PolicyTranscode:
+			*playbackInfoResponse.MediaSources[index].SupportsDirectPlay = false
+			*playbackInfoResponse.MediaSources[index].SupportsDirectStream = false
+			logging.Infof("访问策略要求用户 %s（%s）由媒体服务器转码播放：%s", user.Name, user.ID, *mediasource.Name)
+			continue
+		}
+		if strmFileType != constants.UnknownStrm {
+			if err := checkStreamLimit(jellyfinHandler.name, user, client, utils.Deref(playbackInfoResponse.PlaySessionID), *mediasource.ID, action); err != nil {
+				logging.Infof("用户 %s（%s）设备 %s 播放 %s 失败：%s", user.Name, user.ID, client.Device, *mediasource.Name, err)
+				limitErr = err
+				continue
+			}
+		}
+		if strmFileType != constants.UnknownStrm && playbackInfoResponse.PlaySessionID != nil {
+			sessionTracker.preparing(jellyfinHandler.name, client, user, *playbackInfoResponse.PlaySessionID, newJellyfinSessionMedia(item, mediasource, strmFileType))
+		}
 		switch strmFileType {
 		case constants.HTTPStrm: // HTTPStrm 设置支持直链播放并且支持转码
-			if !config.HTTPStrm.TransCode {
+			if !httpStrmSetting(jellyfinHandler.name).TransCode || action == constants.PolicyRedirect {
 				*playbackInfoResponse.MediaSources[index].SupportsDirectPlay = true
 				*playbackInfoResponse.MediaSources[index].SupportsDirectStream = true
 				playbackInfoResponse.MediaSources[index].TranscodingURL = nil
@@ -120,13 +288,14 @@ func (jellyfinHandler *JellyfinHandler) ModifyPlaybackInfo(rw *http.Response) er
 						continue
 					}
 					directStreamURL := fmt.Sprintf("/Videos/%s/stream?MediaSourceId=%s&Static=true&%s", *mediasource.ID, *mediasource.ID, apikeypair)
+					directStreamURL = withSessionParams(directStreamURL, *mediasource.DirectStreamURL)
 					playbackInfoResponse.MediaSources[index].DirectStreamURL = &directStreamURL
 					logging.Info(*mediasource.Name, " 强制禁止转码，直链播放链接为: ", directStreamURL)
 				}
 			}
 
 		case constants.AlistStrm: // AlistStm 设置支持直链播放并且禁止转码
-			if !config.AlistStrm.TransCode {
+			if !alistStrmSetting(jellyfinHandler.name).TransCode || action == constants.PolicyRedirect {
 				*playbackInfoResponse.MediaSources[index].SupportsDirectPlay = true
 				*playbackInfoResponse.MediaSources[index].SupportsDirectStream = true
 				*playbackInfoResponse.MediaSources[index].SupportsTranscoding = false
@@ -142,6 +311,7 @@ func (jellyfinHandler *JellyfinHandler) ModifyPlaybackInfo(rw *http.Response) er
 						continue
 					}
 					directStreamURL += "&" + apikeypair
+					directStreamURL = withSessionParams(directStreamURL, *mediasource.DirectStreamURL)
 				}
 				playbackInfoResponse.MediaSources[index].DirectStreamURL = &directStreamURL
 				container := strings.TrimPrefix(path.Ext(*mediasource.Path), ".")
@@ -152,12 +322,12 @@ func (jellyfinHandler *JellyfinHandler) ModifyPlaybackInfo(rw *http.Response) er
 			}
 
 			if playbackInfoResponse.MediaSources[index].Size == nil {
-				alistServer, err := service.GetAlistServer(opt.(string))
+				alistServer, err := service.GetAlistServer(rule.alistAddr)
 				if err != nil {
 					logging.Warning("获取 AlistServer 失败：", err)
 					continue
 				}
-				fsGetData, err := alistServer.FsGet(*mediasource.Path)
+				fsGetData, err := alistServer.FsGet(rule.rewrite(*mediasource.Path))
 				if err != nil {
 					logging.Warning("请求 FsGet 失败：", err)
 					continue
@@ -168,6 +338,23 @@ func (jellyfinHandler *JellyfinHandler) ModifyPlaybackInfo(rw *http.Response) er
 		}
 	}
 
+	if limitErr != nil {
+		errorCode := jellyfin.RateLimitExceeded
+		if errors.Is(limitErr, ErrQuotaExceeded) {
+			errorCode = jellyfin.NotAllowed
+		}
+		playbackInfoResponse.ErrorCode = &errorCode
+		playbackInfoResponse.MediaSources = nil
+	} else if len(deniedSources) > 0 {
+		playbackInfoResponse.MediaSources = slices.DeleteFunc(playbackInfoResponse.MediaSources, func(mediasource jellyfin.MediaSourceInfo) bool {
+			return deniedSources[*mediasource.ID]
+		})
+		if len(playbackInfoResponse.MediaSources) == 0 {
+			errorCode := jellyfin.NotAllowed
+			playbackInfoResponse.ErrorCode = &errorCode
+		}
+	}
+
 	if data, err = json.Marshal(playbackInfoResponse); err != nil {
 		logging.Warning("序列化 jellyfin.PlaybackInfoResponse Json 错误：", err)
 		return err
@@ -186,6 +373,9 @@ func (jellyfinHandler *JellyfinHandler) VideosHandler(ctx *gin.Context) {
 		logging.Debug("VideosHandler 不处理 HEAD 请求，转发至上游服务器")
 		return
 	}
+	if !RequireAuth(ctx) { // 在解析 Strm 之前拒绝未认证的请求
+		return
+	}
 
 	mediaSourceID := ctx.Query("mediasourceid")
 	logging.Debugf("请求 ItemsServiceQueryItem：%s", mediaSourceID)
@@ -204,16 +394,20 @@ func (jellyfinHandler *JellyfinHandler) VideosHandler(ctx *gin.Context) {
 		return
 	}
 
-	strmFileType, opt := recgonizeStrmFileType(*item.Path)
+	strmFileType, rule := recgonizeStrmFileType(jellyfinHandler.name, *item.Path)
 	for _, mediasource := range item.MediaSources {
 		if *mediasource.ID == mediaSourceID { // EmbyServer >= 4.9 返回的ID带有前缀mediasource_
+			decision, ok := applyStreamPolicy(ctx, newJellyfinSessionMedia(item, mediasource, strmFileType), jellyfinHandler.proxy.ServeHTTP)
+			if !ok {
+				return
+			}
 			switch strmFileType {
 			case constants.HTTPStrm:
 				if *mediasource.Protocol == jellyfin.HTTP {
-					redirectURL := *mediasource.Path
-					if config.HTTPStrm.FinalURL {
+					redirectURL := rule.httpStrmURL(*mediasource.Path)
+					if setting := httpStrmSetting(jellyfinHandler.name); setting.FinalURL {
 						logging.Debug("HTTPStrm 启用获取最终 URL，开始尝试获取最终 URL")
-						if finalURL, err := getFinalURL(redirectURL, ctx.Request.UserAgent()); err != nil {
+						if finalURL, err := getFinalURL(redirectURL, ctx.Request.UserAgent(), setting.FinalURLCache); err != nil {
 							logging.Warning("获取最终 URL 失败，使用原始 URL：", err)
 						} else {
 							redirectURL = finalURL
@@ -222,32 +416,39 @@ func (jellyfinHandler *JellyfinHandler) VideosHandler(ctx *gin.Context) {
 						logging.Debug("HTTPStrm 未启用获取最终 URL，直接使用原始 URL")
 					}
 					logging.Info("HTTPStrm 重定向至：", redirectURL)
-					ctx.Redirect(http.StatusFound, redirectURL)
+					metrics.StrmRedirects.Inc(string(constants.HTTPStrm), urlHost(redirectURL))
+					strmRedirected(ctx, newJellyfinSessionMedia(item, mediasource, strmFileType), "", redirectURL)
+					respondStrm(ctx, decision, redirectURL)
 				}
 				return
 			case constants.AlistStrm: // 无需判断 *mediasource.Container 是否以Strm结尾，当 AlistStrm 存储的位置有对应的文件时，*mediasource.Container 会被设置为文件后缀
-				alistServerAddr := opt.(string)
+				alistServerAddr := rule.alistAddr
+				alistPath := rule.rewrite(*mediasource.Path)
 				alistServer, err := service.GetAlistServer(alistServerAddr)
 				if err != nil {
 					logging.Warning("获取 AlistServer 失败：", err)
+					strmFailed(ctx, newJellyfinSessionMedia(item, mediasource, strmFileType), alistServerAddr, err)
+					ctx.String(http.StatusInternalServerError, err.Error())
 					return
 				}
-				fsGetData, err := alistServer.FsGet(*mediasource.Path)
+				fsGetData, err := alistServer.FsGet(alistPath)
 				if err != nil {
-					logging.Warning("请求 FsGet 失败：", err)
+					statusCode := alistErrorStatusCode(err)
+					logging.Warningf("请求 FsGet 失败（响应状态码：%d）：%s", statusCode, err)
+					strmFailed(ctx, newJellyfinSessionMedia(item, mediasource, strmFileType), alistServerAddr, err)
+					ctx.String(statusCode, err.Error())
 					return
 				}
 				var redirectURL string
-				if config.AlistStrm.RawURL {
+				if alistStrmSetting(jellyfinHandler.name).RawURL {
 					redirectURL = fsGetData.RawURL
 				} else {
-					redirectURL = fmt.Sprintf("%s/d%s", alistServerAddr, *mediasource.Path)
-					if fsGetData.Sign != "" {
-						redirectURL += "?sign=" + fsGetData.Sign
-					}
+					redirectURL = alistDownloadURL(alistServerAddr, alistPath, fsGetData)
 				}
 				logging.Infof("AlistStrm 重定向至：%s", redirectURL)
-				ctx.Redirect(http.StatusFound, redirectURL)
+				metrics.StrmRedirects.Inc(string(constants.AlistStrm), alistServer.GetEndpoint())
+				strmRedirected(ctx, newJellyfinSessionMedia(item, mediasource, strmFileType), alistServerAddr, redirectURL)
+				respondStrm(ctx, decision, redirectURL)
 				return
 			case constants.UnknownStrm:
 				jellyfinHandler.proxy.ServeHTTP(ctx.Writer, ctx.Request)
@@ -259,6 +460,7 @@ func (jellyfinHandler *JellyfinHandler) VideosHandler(ctx *gin.Context) {
 
 // 修改首页函数
 func (jellyfinHandler *JellyfinHandler) ModifyIndex(rw *http.Response) error {
+	web := webSetting(jellyfinHandler.name)
 	var (
 		htmlFilePath string = path.Join(config.CostomDir(), "index.html")
 		htmlContent  []byte
@@ -267,7 +469,7 @@ func (jellyfinHandler *JellyfinHandler) ModifyIndex(rw *http.Response) error {
 	)
 
 	defer rw.Body.Close() // 无论哪种情况，最终都要确保原 Body 被关闭，避免内存泄漏
-	if config.Web.Index { // 从本地文件读取index.html
+	if web.Index {        // 从本地文件读取index.html
 		if htmlContent, err = os.ReadFile(htmlFilePath); err != nil {
 			logging.Warning("读取文件内容出错，错误信息：", err)
 			return err
@@ -278,29 +480,29 @@ func (jellyfinHandler *JellyfinHandler) ModifyIndex(rw *http.Response) error {
 		}
 	}
 
-	if config.Web.Head != "" { // 用户自定义HEAD
-		addHEAD = append(addHEAD, []byte(config.Web.Head+"\n")...)
+	if web.Head != "" { // 用户自定义HEAD
+		addHEAD = append(addHEAD, []byte(web.Head+"\n")...)
 	}
-	if config.Web.ExternalPlayerUrl { // 外部播放器
+	if web.ExternalPlayerUrl { // 外部播放器
 		addHEAD = append(addHEAD, []byte(`<script src="/MediaWarp/static/embyExternalUrl/embyWebAddExternalUrl/embyLaunchPotplayer.js"></script>`+"\n")...)
 	}
-	if config.Web.Crx { // crx 美化
+	if web.Crx { // crx 美化
 		addHEAD = append(addHEAD, []byte(`<link rel="stylesheet" id="theme-css" href="/MediaWarp/static/jellyfin-crx/static/css/style.css" type="text/css" media="all" />
     <script src="/MediaWarp/static/jellyfin-crx/static/js/common-utils.js"></script>
     <script src="/MediaWarp/static/jellyfin-crx/static/js/jquery-3.6.0.min.js"></script>
     <script src="/MediaWarp/static/jellyfin-crx/static/js/md5.min.js"></script>
     <script src="/MediaWarp/static/jellyfin-crx/content/main.js"></script>`+"\n")...)
 	}
-	if config.Web.ActorPlus { // 过滤没有头像的演员和制作人员
+	if web.ActorPlus { // 过滤没有头像的演员和制作人员
 		addHEAD = append(addHEAD, []byte(`<script src="/MediaWarp/static/emby-web-mod/actorPlus/actorPlus.js"></script>`+"\n")...)
 	}
-	if config.Web.FanartShow { // 显示同人图（fanart图）
+	if web.FanartShow { // 显示同人图（fanart图）
 		addHEAD = append(addHEAD, []byte(`<script src="/MediaWarp/static/emby-web-mod/fanart_show/fanart_show.js"></script>`+"\n")...)
 	}
-	if config.Web.Danmaku { // 弹幕
+	if web.Danmaku { // 弹幕
 		addHEAD = append(addHEAD, []byte(`<script src="/MediaWarp/static/jellyfin-danmaku/ede.js" defer></script>`+"\n")...)
 	}
-	if config.Web.VideoTogether { // VideoTogether
+	if web.VideoTogether { // VideoTogether
 		addHEAD = append(addHEAD, []byte(`<script src="https://2gether.video/release/extension.website.user.js"></script>`+"\n")...)
 	}
 	htmlContent = bytes.Replace(htmlContent, []byte("</head>"), append(addHEAD, []byte("</head>")...), 1) // 将添加HEAD
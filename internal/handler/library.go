@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"MediaWarp/internal/logging"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const libraryCacheTTL = 10 * time.Minute // 媒体库列表缓存时间
+
+// 媒体库
+type Library struct {
+	ID        string   `json:"Id"`
+	Name      string   `json:"Name"`
+	Locations []string `json:"Locations"` // 媒体库包含的文件夹路径
+}
+
+// 缓存的媒体库列表
+type libraryCacheEntry struct {
+	libraries []Library
+	expiresAt time.Time
+}
+
+// 媒体库列表缓存
+//
+// 媒体库很少变动，避免每次播放都请求上游服务器；键为上游媒体服务器名称
+var libraryCache = struct {
+	mutex   sync.Mutex
+	entries map[string]libraryCacheEntry
+	group   singleflight.Group // 合并同一媒体服务器同时进行的请求
+}{entries: make(map[string]libraryCacheEntry)}
+
+// 获取媒体库列表
+//
+// 优先使用缓存，请求失败时使用过期的缓存
+// 请求上游服务器时不持有锁，同时请求同一媒体服务器时只发送一次请求
+func getLibraries(mediaServer MediaServerHandler) []Library {
+	if mediaServer == nil {
+		return nil
+	}
+	name := mediaServer.Name()
+	libraryCache.mutex.Lock()
+	entry := libraryCache.entries[name]
+	libraryCache.mutex.Unlock()
+	if time.Now().Before(entry.expiresAt) {
+		return entry.libraries
+	}
+
+	result, err, _ := libraryCache.group.Do(name, func() (any, error) {
+		libraries, err := mediaServer.ListLibraries()
+		if err != nil {
+			return nil, err
+		}
+		libraryCache.mutex.Lock()
+		libraryCache.entries[name] = libraryCacheEntry{libraries: libraries, expiresAt: time.Now().Add(libraryCacheTTL)}
+		libraryCache.mutex.Unlock()
+		return libraries, nil
+	})
+	if err != nil {
+		logging.Warning("获取媒体库列表失败：", err)
+		return entry.libraries
+	}
+	return result.([]Library)
+}
+
+// 获取文件所属的媒体库名称
+//
+// 按最长的文件夹路径前缀匹配，未匹配到时返回空字符串
+func libraryOf(mediaServer MediaServerHandler, path string) string {
+	return matchLibrary(getLibraries(mediaServer), path).Name
+}
+
+// 获取文件所属的媒体库
+//
+// 未匹配到时返回空的媒体库
+func libraryFor(mediaServer MediaServerHandler, path string) Library {
+	return matchLibrary(getLibraries(mediaServer), path)
+}
+
+func matchLibrary(libraries []Library, path string) Library {
+	var (
+		matched Library
+		longest int
+	)
+	path = strings.ReplaceAll(path, "\\", "/")
+	for _, library := range libraries {
+		for _, location := range library.Locations {
+			location = strings.TrimRight(strings.ReplaceAll(location, "\\", "/"), "/")
+			if location == "" || len(location) <= longest {
+				continue
+			}
+			if path == location || strings.HasPrefix(path, location+"/") { // 仅在路径分隔处匹配，避免 /media/movie 匹配 /media/movies
+				matched = library
+				longest = len(location)
+			}
+		}
+	}
+	return matched
+}
@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/config"
+	"MediaWarp/utils"
+	"cmp"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const bytesPerGB = 1 << 30
+
+var (
+	ErrTooManyUserStreams   = errors.New("用户同时播放的 Strm 数量已达上限")
+	ErrTooManyDeviceStreams = errors.New("设备同时播放的 Strm 数量已达上限")
+	ErrQuotaExceeded        = errors.New("代理流量已超过配额")
+)
+
+// 用户的播放限制
+type streamLimit struct {
+	MaxStreamsPerUser   int
+	MaxStreamsPerDevice int
+	Quota               int64 // 流量配额（字节），0 表示不限制
+}
+
+// 获取用户的播放限制
+//
+// 使用第一条匹配的覆盖规则，未匹配时使用全局限制
+func limitFor(user policyUser) streamLimit {
+	for _, override := range config.Get().Limit.Overrides {
+		if user.match(override.Users, override.Groups) {
+			return streamLimit{
+				MaxStreamsPerUser:   override.MaxStreamsPerUser,
+				MaxStreamsPerDevice: override.MaxStreamsPerDevice,
+				Quota:               int64(override.Quota) * bytesPerGB,
+			}
+		}
+	}
+	return streamLimit{
+		MaxStreamsPerUser:   config.Get().Limit.MaxStreamsPerUser,
+		MaxStreamsPerDevice: config.Get().Limit.MaxStreamsPerDevice,
+		Quota:               int64(config.Get().Limit.Quota) * bytesPerGB,
+	}
+}
+
+// 检查播放限制
+//
+// server 为处理请求的媒体服务器名称，仅统计该媒体服务器的会话
+// 已开始播放的会话（如拖动进度时重新请求视频流）不检查同时播放数量
+// 仅由 MediaWarp 代理的视频流检查流量配额
+func checkStreamLimit(server string, user policyUser, client utils.ClientInfo, playSessionID string, mediaSourceID string, action constants.PolicyAction) error {
+	if !config.Get().Limit.Enable {
+		return nil
+	}
+	limit := limitFor(user)
+	if action == constants.PolicyProxy && limit.Quota > 0 && quotaUsage.used(user) >= limit.Quota {
+		return ErrQuotaExceeded
+	}
+
+	excludeID := playSessionID
+	if session, ok := sessionTracker.get(server, client, playSessionID, mediaSourceID); ok {
+		if !session.RedirectTime.IsZero() {
+			return nil
+		}
+		excludeID = session.ID
+	}
+	userStreams, deviceStreams := sessionTracker.activeStreams(server, user.ID, client.DeviceID, mediaSourceID, excludeID)
+	switch {
+	case limit.MaxStreamsPerUser > 0 && user.ID != "" && userStreams >= limit.MaxStreamsPerUser:
+		return ErrTooManyUserStreams
+	case limit.MaxStreamsPerDevice > 0 && client.DeviceID != "" && deviceStreams >= limit.MaxStreamsPerDevice:
+		return ErrTooManyDeviceStreams
+	}
+	return nil
+}
+
+// 统计媒体服务器 server 中正在播放的会话数量
+//
+// 仅统计已重定向且未超时的会话，不包括 excludeID 对应的会话和同一设备播放同一媒体源的会话（客户端重新播放时可能未上报停止）
+func (tracker *SessionTracker) activeStreams(server string, userID string, deviceID string, mediaSourceID string, excludeID string) (userStreams int, deviceStreams int) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	now := time.Now()
+	for _, session := range tracker.sessions {
+		if session.Server != server || session.ID == excludeID || session.RedirectTime.IsZero() || now.Sub(session.LastActive) > sessionIdleTimeout {
+			continue
+		}
+		if deviceID != "" && session.DeviceID == deviceID && session.MediaSourceID == mediaSourceID {
+			continue
+		}
+		if userID != "" && session.authUser.ID == userID {
+			userStreams++
+		}
+		if deviceID != "" && session.DeviceID == deviceID {
+			deviceStreams++
+		}
+	}
+	return
+}
+
+// 用户的代理流量
+type QuotaUsageEntry struct {
+	UserID   string `json:"UserId"`
+	UserName string `json:"UserName"`
+	Bytes    int64  `json:"Bytes"` // 当前周期内已代理的流量
+	Quota    int64  `json:"Quota"` // 流量配额，0 表示不限制
+}
+
+// 代理流量统计
+//
+// 按 Limit.QuotaPeriod 周期统计，保存在内存中，重启后重置
+type QuotaUsage struct {
+	mutex  sync.Mutex
+	period string
+	usage  map[string]*QuotaUsageEntry
+}
+
+var quotaUsage = &QuotaUsage{usage: make(map[string]*QuotaUsageEntry)}
+
+// 统计周期标识
+func quotaPeriodOf(t time.Time) string {
+	if config.Get().Limit.QuotaPeriod == constants.QuotaMonthly {
+		return t.Format("2006-01")
+	}
+	return t.Format("2006-01-02")
+}
+
+// 进入新的统计周期时清空统计
+//
+// 调用方需持有锁
+func (usage *QuotaUsage) rotate() {
+	if period := quotaPeriodOf(time.Now()); period != usage.period {
+		usage.period = period
+		clear(usage.usage)
+	}
+}
+
+// 用户的统计键
+func (user policyUser) quotaKey() string {
+	return cmp.Or(user.ID, user.Name)
+}
+
+// 增加用户的代理流量，返回当前周期内的总流量
+func (usage *QuotaUsage) add(user policyUser, n int64) int64 {
+	key := user.quotaKey()
+	if key == "" {
+		return 0
+	}
+	usage.mutex.Lock()
+	defer usage.mutex.Unlock()
+	usage.rotate()
+	entry, ok := usage.usage[key]
+	if !ok {
+		entry = &QuotaUsageEntry{UserID: user.ID}
+		usage.usage[key] = entry
+	}
+	if user.Name != "" {
+		entry.UserName = user.Name
+	}
+	entry.Bytes += n
+	return entry.Bytes
+}
+
+// 获取用户当前周期内的代理流量
+func (usage *QuotaUsage) used(user policyUser) int64 {
+	usage.mutex.Lock()
+	defer usage.mutex.Unlock()
+	usage.rotate()
+	if entry, ok := usage.usage[user.quotaKey()]; ok {
+		return entry.Bytes
+	}
+	return 0
+}
+
+// 获取当前周期内所有用户的代理流量
+//
+// 按流量从多到少排序
+func (usage *QuotaUsage) Entries() (string, []QuotaUsageEntry) {
+	usage.mutex.Lock()
+	usage.rotate()
+	entries := make([]QuotaUsageEntry, 0, len(usage.usage))
+	for _, entry := range usage.usage {
+		entries = append(entries, *entry)
+	}
+	period := usage.period
+	usage.mutex.Unlock()
+
+	for i := range entries {
+		entries[i].Quota = limitFor(policyUser{ID: entries[i].UserID, Name: entries[i].UserName}).Quota
+	}
+	slices.SortFunc(entries, func(a, b QuotaUsageEntry) int {
+		return cmp.Compare(b.Bytes, a.Bytes)
+	})
+	return period, entries
+}
+
+// 统计代理流量的 Writer
+//
+// 超过用户的流量配额时返回 ErrQuotaExceeded，中断传输
+type quotaWriter struct {
+	writer io.Writer
+	user   policyUser
+	quota  int64
+}
+
+func newQuotaWriter(writer io.Writer, user policyUser) *quotaWriter {
+	w := &quotaWriter{writer: writer, user: user}
+	if config.Get().Limit.Enable {
+		w.quota = limitFor(user).Quota
+	}
+	return w
+}
+
+func (w *quotaWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if used := quotaUsage.add(w.user, int64(n)); err == nil && w.quota > 0 && used > w.quota {
+		return n, ErrQuotaExceeded
+	}
+	return n, err
+}
+
+// 查询代理流量统计
+//
+// GET /MediaWarp/api/quota
+func QuotaHandler(ctx *gin.Context) {
+	period, entries := quotaUsage.Entries()
+	ctx.JSON(http.StatusOK, gin.H{"Period": period, "Items": entries})
+}
@@ -0,0 +1,305 @@
+package handler
+
+import (
+	"MediaWarp/internal/clientfilter"
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/logging"
+	"MediaWarp/internal/service"
+	"MediaWarp/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrAlistServerExists   = errors.New("Alist 服务器已存在")
+	ErrAlistServerNotFound = errors.New("Alist 服务器不存在")
+	ErrWriteBackDisabled   = errors.New("未启用 API.WriteBack，修改会在重新加载配置时丢失")
+)
+
+// 写回配置文件失败
+//
+// 修改未生效
+type writeBackError struct {
+	err error
+}
+
+func (e *writeBackError) Error() string {
+	return "写回配置文件失败，修改未生效：" + e.err.Error()
+}
+
+func (e *writeBackError) Unwrap() error {
+	return e.err
+}
+
+// 修改配置
+//
+// modify 修改当前配置的副本，重新编译 Strm 规则、客户端过滤规则并将 keys 对应的配置项写回配置文件后整体替换当前配置，再重新注册 Alist 服务器
+// 任一步骤失败时当前配置保持不变；未启用 API.WriteBack 时拒绝修改，避免修改在重新加载配置时丢失
+func updateConfig(modify func(settings *config.Settings) error, keys ...string) error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	if !config.Get().API.WriteBack {
+		return ErrWriteBackDisabled
+	}
+	settings := config.Clone()
+	if err := modify(settings); err != nil {
+		return err
+	}
+	rules, err := buildStrmRules(settings)
+	if err != nil {
+		return err
+	}
+	clientFilter, err := clientfilter.Build(settings)
+	if err != nil {
+		return err
+	}
+
+	snapshot := settings.Snapshot()
+	for _, key := range keys {
+		value, err := lookupSetting(snapshot, key)
+		if err == nil {
+			err = config.WriteBack(key, value)
+		}
+		if err != nil {
+			logging.Warning("写回配置文件失败：", err)
+			return &writeBackError{err: err}
+		}
+	}
+	logging.Info("已将修改写回配置文件")
+
+	config.Set(settings)
+	setStrmRules(rules)
+	clientfilter.Set(clientFilter)
+	service.InitAlistSerer()
+	return nil
+}
+
+// 从配置快照中获取配置项
+//
+// key 为 "顶级配置项.字段" 形式，字段可省略
+func lookupSetting(settings map[string]any, key string) (any, error) {
+	top, field, hasField := strings.Cut(key, ".")
+	value, ok := settings[top]
+	if !ok {
+		return nil, fmt.Errorf("未知的配置项：%s", key)
+	}
+	if !hasField {
+		return value, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if value, ok = fields[field]; !ok {
+		return nil, fmt.Errorf("未知的配置项：%s", key)
+	}
+	return value, nil
+}
+
+// 响应修改配置的结果
+func respondUpdate(ctx *gin.Context, err error, result func() any) {
+	var wbErr *writeBackError
+	switch {
+	case errors.As(err, &wbErr):
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrAlistServerExists), errors.Is(err, ErrWriteBackDisabled):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrAlistServerNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err != nil:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusOK, result())
+	}
+}
+
+// 查找 Alist 服务器配置的下标
+//
+// 按服务器入口地址比较，未找到时返回 -1
+func findAlistSetting(list []config.AlistSetting, addr string) int {
+	endpoint := utils.GetEndpoint(addr)
+	return slices.IndexFunc(list, func(setting config.AlistSetting) bool {
+		return utils.GetEndpoint(setting.ADDR) == endpoint
+	})
+}
+
+// 隐藏敏感信息后的 Alist 服务器配置
+func maskedAlistSettings() any {
+	data, _ := json.Marshal(config.Get().AlistStrm.List)
+	var list []any
+	json.Unmarshal(data, &list)
+	if list == nil {
+		list = []any{}
+	}
+	return maskSecrets(list)
+}
+
+// Strm 前缀列表请求体
+type prefixListRequest struct {
+	PrefixList []string `json:"PrefixList"`
+}
+
+// 获取 Alist 服务器列表
+//
+// GET /MediaWarp/api/alist
+func ListAlistHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, maskedAlistSettings())
+}
+
+// 添加 Alist 服务器
+//
+// POST /MediaWarp/api/alist
+// 请求体与配置文件中 AlistStrm.List 的单项相同
+func AddAlistHandler(ctx *gin.Context) {
+	var setting config.AlistSetting
+	if err := ctx.ShouldBindJSON(&setting); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := updateConfig(func(settings *config.Settings) error {
+		if setting.ADDR == "" {
+			return errors.New("缺少 ADDR")
+		}
+		if findAlistSetting(settings.AlistStrm.List, setting.ADDR) >= 0 {
+			return fmt.Errorf("%w：%s", ErrAlistServerExists, setting.ADDR)
+		}
+		settings.AlistStrm.List = append(slices.Clone(settings.AlistStrm.List), setting)
+		return nil
+	}, "AlistStrm.List")
+	if err == nil {
+		logging.Info("已添加 Alist 服务器：", setting.ADDR)
+	}
+	respondUpdate(ctx, err, maskedAlistSettings)
+}
+
+// 移除 Alist 服务器
+//
+// DELETE /MediaWarp/api/alist?addr=Alist服务器地址
+func RemoveAlistHandler(ctx *gin.Context) {
+	addr := ctx.Query("addr")
+	err := updateConfig(func(settings *config.Settings) error {
+		index := findAlistSetting(settings.AlistStrm.List, addr)
+		if index < 0 {
+			return fmt.Errorf("%w：%s", ErrAlistServerNotFound, addr)
+		}
+		settings.AlistStrm.List = slices.Delete(slices.Clone(settings.AlistStrm.List), index, index+1)
+		return nil
+	}, "AlistStrm.List")
+	if err == nil {
+		logging.Info("已移除 Alist 服务器：", addr)
+	}
+	respondUpdate(ctx, err, maskedAlistSettings)
+}
+
+// 修改 Alist 服务器的 Strm 前缀列表
+//
+// PUT /MediaWarp/api/alist/prefixes?addr=Alist服务器地址
+// 请求体：{"PrefixList": ["/media/strm"]}
+func UpdateAlistPrefixesHandler(ctx *gin.Context) {
+	var req prefixListRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	addr := ctx.Query("addr")
+	err := updateConfig(func(settings *config.Settings) error {
+		index := findAlistSetting(settings.AlistStrm.List, addr)
+		if index < 0 {
+			return fmt.Errorf("%w：%s", ErrAlistServerNotFound, addr)
+		}
+		list := slices.Clone(settings.AlistStrm.List)
+		list[index].PrefixList = req.PrefixList
+		settings.AlistStrm.List = list
+		return nil
+	}, "AlistStrm.List")
+	respondUpdate(ctx, err, maskedAlistSettings)
+}
+
+// 获取 HTTPStrm 前缀列表
+//
+// GET /MediaWarp/api/httpstrm/prefixes
+func GetHTTPStrmPrefixesHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, prefixListRequest{PrefixList: config.Get().HTTPStrm.PrefixList})
+}
+
+// 修改 HTTPStrm 前缀列表
+//
+// PUT /MediaWarp/api/httpstrm/prefixes
+// 请求体：{"PrefixList": ["/media/strm/http"]}
+func UpdateHTTPStrmPrefixesHandler(ctx *gin.Context) {
+	var req prefixListRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := updateConfig(func(settings *config.Settings) error {
+		settings.HTTPStrm.PrefixList = req.PrefixList
+		return nil
+	}, "HTTPStrm.PrefixList")
+	respondUpdate(ctx, err, func() any {
+		return prefixListRequest{PrefixList: config.Get().HTTPStrm.PrefixList}
+	})
+}
+
+// 获取客户端过滤设置
+//
+// GET /MediaWarp/api/clientfilter
+func GetClientFilterHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, config.Get().ClientFilter)
+}
+
+// 修改客户端过滤名单和规则
+//
+// PUT /MediaWarp/api/clientfilter
+// 请求体：{"Mode": "BlackList", "ClientList": ["Infuse"], "AllowUnknown": false, "Rules": [{"Client": "^Infuse$", "MaxVersion": "7.7", "Action": "Deny"}]}
+// 客户端过滤中间件在启动时注册，修改 Enable 需要重启才能生效
+func UpdateClientFilterHandler(ctx *gin.Context) {
+	var req config.ClientFilterSetting
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := updateConfig(func(settings *config.Settings) error {
+		clientFilter := settings.ClientFilter
+		clientFilter.Mode = req.Mode
+		clientFilter.ClientList = req.ClientList
+		clientFilter.AllowUnknown = req.AllowUnknown
+		clientFilter.Rules = req.Rules
+		if _, err := clientfilter.New(clientFilter); err != nil {
+			return err
+		}
+		settings.ClientFilter = clientFilter
+		return nil
+	}, "ClientFilter.Mode", "ClientFilter.ClientList", "ClientFilter.AllowUnknown", "ClientFilter.Rules")
+	respondUpdate(ctx, err, func() any { return config.Get().ClientFilter })
+}
+
+// 清空缓存
+//
+// DELETE /MediaWarp/api/cache
+// 清空最终 URL 缓存、媒体库列表缓存和访问令牌验证结果缓存
+func FlushCacheHandler(ctx *gin.Context) {
+	finalURLResolver.Flush()
+
+	libraryCache.mutex.Lock()
+	clear(libraryCache.entries)
+	libraryCache.mutex.Unlock()
+
+	userCache.mutex.Lock()
+	clear(userCache.entries)
+	userCache.mutex.Unlock()
+
+	logging.Info("已清空缓存")
+	ctx.JSON(http.StatusOK, gin.H{"FinalURL": finalURLResolver.Stats()})
+}
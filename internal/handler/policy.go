@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/logging"
+	"MediaWarp/utils"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const streamUserContextKey = "MediaWarp-StreamUser" // 请求上下文中视频流请求的用户
+
+var ErrPolicyDenied = errors.New("访问策略禁止播放")
+
+// 代理视频流时转发给 Strm 目标地址的请求头
+var proxyRequestHeaders = []string{"Range", "If-Range", "If-Modified-Since", "If-None-Match", "User-Agent", "Accept"}
+
+// 代理视频流时转发给客户端的响应头
+var proxyResponseHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified", "ETag", "Cache-Control"}
+
+// 代理视频流使用的 HTTP 客户端
+//
+// 跟随重定向，不设置超时（视频流可能持续数小时）
+var streamClient = &http.Client{}
+
+// 访问策略中的用户
+type policyUser struct {
+	ID   string
+	Name string
+}
+
+// 获取请求的用户
+//
+// 通过请求中的访问令牌获取用户（结果有缓存）
+// 请求中的用户 ID 由客户端提供，不可信：无法通过访问令牌获取用户时返回匿名用户，仅匹配不限定用户和用户组的规则
+func requestUser(req *http.Request) policyUser {
+	client := utils.GetClientInfo(req)
+	if mediaServerUser, err := authenticateToken(MediaServerOf(req), client.Token); err == nil && mediaServerUser != apiKeyUser {
+		return policyUser{ID: mediaServerUser.ID, Name: mediaServerUser.Name}
+	}
+	return policyUser{}
+}
+
+// 获取应用访问策略时得到的视频流请求的用户
+//
+// 未应用访问策略时返回匿名用户
+func streamUser(ctx *gin.Context) policyUser {
+	value, _ := ctx.Get(streamUserContextKey)
+	user, _ := value.(policyUser)
+	return user
+}
+
+// 判断用户是否属于用户组
+func (user policyUser) inGroup(group string) bool {
+	for name, members := range config.Get().Policy.Groups {
+		if strings.EqualFold(name, group) && slices.ContainsFunc(members, user.is) {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断用户 ID 或用户名是否与 key 相同
+func (user policyUser) is(key string) bool {
+	return (user.ID != "" && strings.EqualFold(user.ID, key)) || (user.Name != "" && strings.EqualFold(user.Name, key))
+}
+
+// 判断用户是否属于 users 或 groups
+//
+// users 和 groups 均为空时匹配所有用户
+func (user policyUser) match(users []string, groups []string) bool {
+	if len(users) == 0 && len(groups) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(users, user.is) || slices.ContainsFunc(groups, user.inGroup)
+}
+
+// 判断访问策略规则是否匹配
+//
+// Users 和 Groups 均为空时匹配所有用户，Libraries 为空时匹配所有媒体库
+func policyRuleMatch(rule config.PolicyRule, user policyUser, library Library) bool {
+	if !user.match(rule.Users, rule.Groups) {
+		return false
+	}
+	if len(rule.Libraries) > 0 {
+		return slices.ContainsFunc(rule.Libraries, func(key string) bool {
+			return (library.ID != "" && strings.EqualFold(library.ID, key)) || (library.Name != "" && strings.EqualFold(library.Name, key))
+		})
+	}
+	return true
+}
+
+// 评估 Strm 播放访问策略
+//
+// 未启用访问策略时返回 PolicyAllow，媒体库从 mediaServer 查询
+func evaluatePolicy(mediaServer MediaServerHandler, user policyUser, itemPath string) constants.PolicyAction {
+	if !config.Get().Policy.Enable {
+		return constants.PolicyAllow
+	}
+	library := libraryFor(mediaServer, itemPath)
+	for _, rule := range config.Get().Policy.Rules {
+		if policyRuleMatch(rule, user, library) {
+			logging.Debugf("用户 %s（%s）播放 %s 匹配访问策略：%s", user.Name, user.ID, itemPath, rule.Action)
+			return rule.Action
+		}
+	}
+	return config.Get().Policy.Default
+}
+
+// 视频流请求的处理方式
+type streamDecision struct {
+	Action constants.PolicyAction
+	User   policyUser
+}
+
+// 在视频流处理器中应用访问策略和播放限制
+//
+// 禁止播放时响应 403，超过播放限制时响应 429，强制转码时转发至上游服务器
+// 返回 false 表示请求已处理
+func applyStreamPolicy(ctx *gin.Context, media sessionMedia, proxy func(http.ResponseWriter, *http.Request)) (streamDecision, bool) {
+	decision := streamDecision{Action: constants.PolicyAllow}
+	if (!config.Get().Policy.Enable && !config.Get().Limit.Enable) || media.StrmType == constants.UnknownStrm {
+		return decision, true
+	}
+	client := utils.GetClientInfo(ctx.Request)
+	playSessionID := ctx.Query("playsessionid")
+	decision.User = requestUser(ctx.Request)
+	if decision.User.ID == "" { // 视频流请求可能不携带访问令牌，使用播放会话中已验证的用户
+		if session, ok := sessionTracker.get(mediaServerNameOf(ctx.Request), client, playSessionID, media.MediaSourceID); ok {
+			decision.User = session.authUser
+		}
+	}
+	user := decision.User
+	ctx.Set(streamUserContextKey, user)
+
+	decision.Action = evaluatePolicy(MediaServerOf(ctx.Request), user, media.Path)
+	switch decision.Action {
+	case constants.PolicyDeny:
+		logging.Infof("访问策略禁止用户 %s（%s）播放：%s", user.Name, user.ID, media.Path)
+		strmFailed(ctx, media, "", ErrPolicyDenied)
+		ctx.JSON(http.StatusForbidden, gin.H{"error": ErrPolicyDenied.Error()})
+		return decision, false
+	case constants.PolicyTranscode:
+		logging.Infof("访问策略要求用户 %s（%s）由媒体服务器转码播放：%s", user.Name, user.ID, media.Path)
+		proxy(ctx.Writer, ctx.Request)
+		return decision, false
+	}
+
+	if err := checkStreamLimit(mediaServerNameOf(ctx.Request), user, client, playSessionID, media.MediaSourceID, decision.Action); err != nil {
+		logging.Infof("用户 %s（%s）设备 %s 播放 %s 失败：%s", user.Name, user.ID, client.Device, media.Path, err)
+		strmFailed(ctx, media, "", err)
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return decision, false
+	}
+	return decision, true
+}
+
+// 按访问策略响应 Strm 目标地址
+//
+// PolicyProxy 时代理视频流，否则重定向至目标地址
+func respondStrm(ctx *gin.Context, decision streamDecision, target string) {
+	if decision.Action == constants.PolicyProxy {
+		proxyStream(ctx, target, decision.User)
+		return
+	}
+	ctx.Redirect(http.StatusFound, withPathPrefix(ctx.Request, target))
+}
+
+// 代理视频流
+//
+// 由 MediaWarp 请求 Strm 目标地址（跟随重定向）并将响应转发给客户端，不向客户端暴露直链
+// 转发的流量计入用户的流量配额，超过配额时中断传输
+func proxyStream(ctx *gin.Context, target string, user policyUser) {
+	req, err := http.NewRequestWithContext(ctx.Request.Context(), http.MethodGet, target, nil)
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, key := range proxyRequestHeaders {
+		if value := ctx.GetHeader(key); value != "" {
+			req.Header.Set(key, value)
+		}
+	}
+
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		logging.Warning("代理视频流失败：", err)
+		ctx.String(http.StatusBadGateway, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, key := range proxyResponseHeaders {
+		if value := resp.Header.Get(key); value != "" {
+			ctx.Header(key, value)
+		}
+	}
+	ctx.Status(resp.StatusCode)
+	if _, err = io.Copy(newQuotaWriter(ctx.Writer, user), resp.Body); err != nil {
+		logging.Debug("代理视频流中断：", err)
+	}
+}
@@ -0,0 +1,114 @@
+package handler_test
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/handler"
+	"MediaWarp/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var alice = handler.PolicyUser{ID: "a1", Name: "alice"}
+
+// 设备 deviceID 在播放会话 playSessionID 中播放媒体源 mediaSourceID 的视频流请求（不携带访问令牌）
+//
+// 返回访问策略的处理结果和响应状态码
+func streamRequest(t *testing.T, deviceID string, playSessionID string, mediaSourceID string) (constants.PolicyAction, handler.PolicyUser, bool, int) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/videos/1/stream?playsessionid="+playSessionID, nil)
+	ctx.Request.Header.Set("X-Emby-Device-Id", deviceID)
+	media := handler.SessionMedia{ItemID: "1", MediaSourceID: mediaSourceID, Path: "/media/strm/movie.strm", StrmType: constants.HTTPStrm}
+	decision, ok := handler.ApplyStreamPolicy(ctx, media, func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusAccepted) // 转发至上游服务器
+	})
+	return decision.Action, decision.User, ok, recorder.Code
+}
+
+func TestStreamPolicy(t *testing.T) {
+	handler.ResetPlayback()
+	config.Set(&config.Settings{Policy: config.PolicySetting{
+		Enable:  true,
+		Default: constants.PolicyAllow,
+		Groups:  map[string][]string{"vip": {"alice"}},
+		Rules: []config.PolicyRule{
+			{Users: []string{"bob"}, Action: constants.PolicyDeny},
+			{Groups: []string{"vip"}, Action: constants.PolicyProxy},
+		},
+	}})
+	handler.PreparePlayback("", utils.ClientInfo{DeviceID: "d1"}, alice, "ps1", handler.SessionMedia{MediaSourceID: "m1"})
+	handler.PreparePlayback("", utils.ClientInfo{DeviceID: "d2"}, handler.PolicyUser{ID: "b1", Name: "bob"}, "ps2", handler.SessionMedia{MediaSourceID: "m1"})
+
+	t.Run("按用户名匹配会话中的用户", func(t *testing.T) {
+		action, user, ok, _ := streamRequest(t, "d1", "ps1", "m1")
+		if !ok || action != constants.PolicyProxy {
+			t.Errorf("动作为 %s（%t），期望 %s", action, ok, constants.PolicyProxy)
+		}
+		if user != alice {
+			t.Errorf("用户为 %+v，期望 %+v", user, alice)
+		}
+	})
+
+	t.Run("禁止播放", func(t *testing.T) {
+		action, _, ok, code := streamRequest(t, "d2", "ps2", "m1")
+		if ok || action != constants.PolicyDeny || code != http.StatusForbidden {
+			t.Errorf("动作为 %s（%t），响应 %d，期望禁止播放", action, ok, code)
+		}
+	})
+
+	t.Run("匿名用户", func(t *testing.T) {
+		action, user, ok, _ := streamRequest(t, "d3", "ps3", "m1")
+		if !ok || action != constants.PolicyAllow || user != (handler.PolicyUser{}) {
+			t.Errorf("动作为 %s（%t），用户为 %+v，期望匿名用户使用默认动作", action, ok, user)
+		}
+	})
+}
+
+func TestStreamLimit(t *testing.T) {
+	handler.ResetPlayback()
+	config.Set(&config.Settings{Limit: config.LimitSetting{Enable: true, MaxStreamsPerUser: 1}})
+	handler.RedirectPlayback("", utils.ClientInfo{DeviceID: "d1"}, alice, "ps1", handler.SessionMedia{MediaSourceID: "m1"})
+	handler.PreparePlayback("", utils.ClientInfo{DeviceID: "d2"}, alice, "ps2", handler.SessionMedia{MediaSourceID: "m2"})
+
+	if _, _, ok, code := streamRequest(t, "d2", "ps2", "m2"); ok || code != http.StatusTooManyRequests {
+		t.Errorf("同时播放数量超过上限时响应 %d（%t），期望 %d", code, ok, http.StatusTooManyRequests)
+	}
+	if _, _, ok, _ := streamRequest(t, "d1", "ps1", "m1"); !ok {
+		t.Error("已开始播放的会话重新请求视频流时不应检查同时播放数量")
+	}
+
+	config.Set(&config.Settings{
+		Limit: config.LimitSetting{
+			Enable:            true,
+			MaxStreamsPerUser: 1,
+			Overrides:         []config.LimitOverride{{Users: []string{"alice"}, MaxStreamsPerUser: 2}},
+		},
+	})
+	if _, _, ok, code := streamRequest(t, "d2", "ps2", "m2"); !ok {
+		t.Errorf("按用户名覆盖播放限制后响应 %d，应允许播放", code)
+	}
+}
+
+func TestStreamQuota(t *testing.T) {
+	handler.ResetPlayback()
+	config.Set(&config.Settings{
+		Policy: config.PolicySetting{Enable: true, Default: constants.PolicyProxy},
+		Limit:  config.LimitSetting{Enable: true, Quota: 1, QuotaPeriod: constants.QuotaDaily},
+	})
+	handler.PreparePlayback("", utils.ClientInfo{DeviceID: "d1"}, alice, "ps1", handler.SessionMedia{MediaSourceID: "m1"})
+
+	handler.AddQuotaUsage(alice, 1<<30-1)
+	if action, _, ok, _ := streamRequest(t, "d1", "ps1", "m1"); !ok || action != constants.PolicyProxy {
+		t.Errorf("动作为 %s（%t），未超过配额时应代理视频流", action, ok)
+	}
+	handler.AddQuotaUsage(alice, 1)
+	if _, _, ok, code := streamRequest(t, "d1", "ps1", "m1"); ok || code != http.StatusTooManyRequests {
+		t.Errorf("超过配额时响应 %d（%t），期望 %d", code, ok, http.StatusTooManyRequests)
+	}
+}
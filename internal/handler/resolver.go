@@ -0,0 +1,365 @@
+package handler
+
+import (
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/logging"
+	"MediaWarp/internal/metrics"
+	"MediaWarp/utils"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	MaxRedirectAttempts = 10               // 最大重定向次数限制
+	RedirectTimeout     = 10 * time.Second // 最大超时时间
+
+	resolverExpireMargin    = 30 * time.Second // 提前于上游过期时间失效，避免客户端拿到即将过期的地址
+	resolverCleanupInterval = time.Minute      // 清理过期缓存的间隔
+	finalURLCacheName       = "final_url"      // 指标中最终 URL 缓存的名称
+)
+
+var (
+	ErrInvalidLocationHeader = errors.New("重定向 Location 头无效")
+	ErrMaxRedirectsExceeded  = fmt.Errorf("超过最大重定向次数限制（%d）", MaxRedirectAttempts)
+	ErrRedirectLoop          = errors.New("检测到循环重定向")
+)
+
+// 最终 URL 解析结果
+type finalURLResult struct {
+	finalURL   string    // 最终的非重定向地址
+	statusCode int       // 最终地址响应的状态码
+	expireAt   time.Time // 缓存过期时间，零值表示不可缓存
+}
+
+// 最终 URL 解析器
+//
+// 跟踪重定向链得到最终地址，按 URL 和 User-Agent 缓存解析结果
+// 上游拒绝 HEAD 请求时使用 Range: bytes=0-0 的 GET 请求代替
+type FinalURLResolver struct {
+	client      *http.Client
+	mutex       sync.RWMutex
+	cache       map[string]finalURLResult
+	lastCleanup time.Time
+
+	requests     atomic.Int64 // 解析请求数
+	cacheHits    atomic.Int64 // 缓存命中数
+	errors       atomic.Int64 // 解析失败数
+	getFallbacks atomic.Int64 // 使用 GET 代替 HEAD 的次数
+	latency      atomic.Int64 // 未命中缓存时的累计解析耗时（纳秒）
+}
+
+// 最终 URL 解析器统计信息
+type FinalURLResolverStats struct {
+	Requests       int64   `json:"Requests"`
+	CacheHits      int64   `json:"CacheHits"`
+	CacheMisses    int64   `json:"CacheMisses"`
+	CacheSize      int     `json:"CacheSize"`
+	Errors         int64   `json:"Errors"`
+	GETFallbacks   int64   `json:"GETFallbacks"`
+	AverageLatency float64 `json:"AverageLatency"` // 未命中缓存时的平均解析耗时（毫秒）
+}
+
+var finalURLResolver = newFinalURLResolver()
+
+func newFinalURLResolver() *FinalURLResolver {
+	return &FinalURLResolver{
+		client: &http.Client{
+			Timeout: RedirectTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				// 禁止自动重定向，以便手动处理
+				return http.ErrUseLastResponse
+			},
+		},
+		cache: make(map[string]finalURLResult),
+	}
+}
+
+// 获取全局最终 URL 解析器
+func GetFinalURLResolver() *FinalURLResolver {
+	return finalURLResolver
+}
+
+// 获取URL的最终目标地址（自动跟踪重定向）
+func getFinalURL(rawURL string, ua string, cacheSetting config.FinalURLCacheSetting) (string, error) {
+	return finalURLResolver.Resolve(rawURL, ua, cacheSetting)
+}
+
+// 解析最终地址
+//
+// 优先从缓存中读取，cacheSetting 为处理该请求的媒体服务器的最终 URL 缓存设置
+func (resolver *FinalURLResolver) Resolve(rawURL string, ua string, cacheSetting config.FinalURLCacheSetting) (string, error) {
+	resolver.requests.Add(1)
+	metrics.CacheRequests.Inc(finalURLCacheName)
+	key := rawURL + "\x00" + ua
+
+	resolver.mutex.RLock()
+	result, ok := resolver.cache[key]
+	resolver.mutex.RUnlock()
+	if ok && time.Now().Before(result.expireAt) {
+		resolver.cacheHits.Add(1)
+		metrics.CacheHits.Inc(finalURLCacheName)
+		logging.Debugf("最终 URL 命中缓存：%s -> %s", rawURL, result.finalURL)
+		return result.finalURL, nil
+	}
+
+	startTime := time.Now()
+	result, err := resolver.trace(rawURL, ua, cacheSetting)
+	resolver.latency.Add(int64(time.Since(startTime)))
+	if err != nil {
+		resolver.errors.Add(1)
+		return "", err
+	}
+	resolver.store(key, result)
+	return result.finalURL, nil
+}
+
+// 写入缓存
+func (resolver *FinalURLResolver) store(key string, result finalURLResult) {
+	now := time.Now()
+	if !result.expireAt.After(now) {
+		return
+	}
+
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	resolver.cache[key] = result
+	if now.Sub(resolver.lastCleanup) > resolverCleanupInterval {
+		for k, v := range resolver.cache {
+			if !now.Before(v.expireAt) {
+				delete(resolver.cache, k)
+			}
+		}
+		resolver.lastCleanup = now
+	}
+}
+
+// 清空缓存
+func (resolver *FinalURLResolver) Flush() {
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	resolver.cache = make(map[string]finalURLResult)
+}
+
+// 获取统计信息
+func (resolver *FinalURLResolver) Stats() FinalURLResolverStats {
+	resolver.mutex.RLock()
+	cacheSize := len(resolver.cache)
+	resolver.mutex.RUnlock()
+
+	stats := FinalURLResolverStats{
+		Requests:     resolver.requests.Load(),
+		CacheHits:    resolver.cacheHits.Load(),
+		CacheSize:    cacheSize,
+		Errors:       resolver.errors.Load(),
+		GETFallbacks: resolver.getFallbacks.Load(),
+	}
+	stats.CacheMisses = stats.Requests - stats.CacheHits
+	if stats.CacheMisses > 0 {
+		stats.AverageLatency = float64(resolver.latency.Load()) / float64(stats.CacheMisses) / float64(time.Millisecond)
+	}
+	return stats
+}
+
+// 跟踪重定向链
+//
+// 不读取缓存，返回最终的非重定向地址、该地址响应的状态码以及按 cacheSetting 计算的可缓存截止时间
+func (resolver *FinalURLResolver) trace(rawURL string, ua string, cacheSetting config.FinalURLCacheSetting) (finalURLResult, error) {
+	var result finalURLResult
+
+	startTime := time.Now()
+	defer func() {
+		logging.Debugf("获取 %s 最终URL耗时：%s", rawURL, time.Since(startTime))
+	}()
+
+	// 先对原始URL进行编码处理
+	encodedURL, err := encodeURL(rawURL)
+	if err != nil {
+		return result, fmt.Errorf("URL编码失败: %w", err)
+	}
+
+	parsedURL, err := url.Parse(encodedURL) // 验证并解析输入URL
+	if err != nil {
+		return result, fmt.Errorf("非法 URL： %w", err)
+	}
+	if parsedURL.Scheme == "" {
+		return result, fmt.Errorf("URL 缺少协议头： %s", parsedURL)
+	}
+
+	currentURL := parsedURL.String()
+	visited := make(map[string]struct{}, MaxRedirectAttempts)
+	redirectChain := make([]string, 0, MaxRedirectAttempts+1)
+	expiry := newResolveExpiry(startTime)
+
+	// 跟踪重定向链
+	for i := 0; i <= MaxRedirectAttempts; i++ {
+		// 检测循环重定向
+		if _, exists := visited[currentURL]; exists {
+			return result, fmt.Errorf("%w，重定向链: %s", ErrRedirectLoop, strings.Join(redirectChain, " -> "))
+		}
+		visited[currentURL] = struct{}{}
+		redirectChain = append(redirectChain, currentURL)
+
+		resp, err := resolver.probe(currentURL, ua)
+		if err != nil {
+			return result, err
+		}
+		resp.Body.Close()
+		expiry.observe(resp)
+
+		// 检查是否需要重定向 (3xx 状态码)
+		if resp.StatusCode >= http.StatusMultipleChoices && resp.StatusCode < http.StatusBadRequest {
+			location, err := resp.Location()
+			if err != nil {
+				return result, ErrInvalidLocationHeader
+			}
+
+			// 对重定向URL进行编码处理
+			encodedLocation, err := encodeURL(location.String())
+			if err != nil {
+				return result, fmt.Errorf("重定向URL编码失败: %w", err)
+			}
+			currentURL = encodedLocation
+
+			if strings.HasPrefix(currentURL, "/302/?pickcode=") {
+				fullURL := fmt.Sprintf("%s://%s%s", resp.Request.URL.Scheme, resp.Request.URL.Host, location)
+				logging.Debugf("拼接完整 URL：%s -> %s", currentURL, fullURL)
+				currentURL = fullURL
+			}
+
+			continue
+		}
+
+		// 返回最终的非重定向URL
+		logging.Debug("重定向链：", strings.Join(redirectChain, " -> "))
+		result.finalURL = resp.Request.URL.String()
+		result.statusCode = resp.StatusCode
+		if resp.StatusCode < http.StatusBadRequest { // 仅缓存成功的解析结果
+			result.expireAt = expiry.expireAt(cacheSetting)
+		}
+		return result, nil
+	}
+
+	return result, ErrMaxRedirectsExceeded
+}
+
+// 请求 URL 的响应头
+//
+// 先发送 HEAD 请求，失败或上游返回错误状态码时使用 Range: bytes=0-0 的 GET 请求重试
+// 调用方需要关闭响应体
+func (resolver *FinalURLResolver) probe(rawURL string, ua string) (*http.Response, error) {
+	resp, headErr := resolver.do(http.MethodHead, rawURL, ua)
+	if headErr == nil && resp.StatusCode < http.StatusBadRequest {
+		return resp, nil
+	}
+	if headErr == nil {
+		resp.Body.Close()
+		logging.Debugf("%s 拒绝 HEAD 请求（状态码：%d），使用 GET 请求重试", rawURL, resp.StatusCode)
+	} else {
+		logging.Debugf("%s HEAD 请求失败（%s），使用 GET 请求重试", rawURL, headErr)
+	}
+
+	resolver.getFallbacks.Add(1)
+	resp, err := resolver.do(http.MethodGet, rawURL, ua)
+	if err != nil {
+		if headErr != nil {
+			return nil, fmt.Errorf("发送 HTTP 请求失败：%w", headErr)
+		}
+		return nil, fmt.Errorf("发送 HTTP 请求失败：%w", err)
+	}
+	return resp, nil
+}
+
+// 发送单个请求
+func (resolver *FinalURLResolver) do(method string, rawURL string, ua string) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("User-Agent", ua) // 设置 User-Agent 头部
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0") // 只请求第一个字节
+	}
+	return resolver.client.Do(req)
+}
+
+// 解析结果过期时间计算
+//
+// 综合重定向链中每一跳的 Cache-Control、Expires 响应头以及签名 URL 中的过期时间，取最早者
+type resolveExpiry struct {
+	now      time.Time
+	earliest time.Time
+	found    bool
+	noStore  bool
+}
+
+func newResolveExpiry(now time.Time) *resolveExpiry {
+	return &resolveExpiry{now: now}
+}
+
+func (expiry *resolveExpiry) update(t time.Time) {
+	if !expiry.found || t.Before(expiry.earliest) {
+		expiry.earliest, expiry.found = t, true
+	}
+}
+
+// 记录一跳响应的过期提示
+func (expiry *resolveExpiry) observe(resp *http.Response) {
+	if t, ok := utils.ParseURLExpiry(resp.Request.URL); ok {
+		expiry.update(t)
+	}
+	t, ok, noStore := utils.ParseCacheExpiry(resp.Header, expiry.now)
+	if noStore {
+		expiry.noStore = true
+	} else if ok {
+		expiry.update(t)
+	}
+	if location, err := resp.Location(); err == nil { // 重定向目标本身也可能带有过期时间
+		if t, ok := utils.ParseURLExpiry(location); ok {
+			expiry.update(t)
+		}
+	}
+}
+
+// 计算缓存截止时间
+//
+// 零值表示不缓存
+func (expiry *resolveExpiry) expireAt(cacheSetting config.FinalURLCacheSetting) time.Time {
+	if expiry.noStore || cacheSetting.MaxTTL <= 0 {
+		return time.Time{}
+	}
+
+	expireAt := expiry.now.Add(cacheSetting.DefaultTTL)
+	if expiry.found {
+		expireAt = expiry.earliest.Add(-resolverExpireMargin)
+	}
+	if maxExpireAt := expiry.now.Add(cacheSetting.MaxTTL); expireAt.After(maxExpireAt) {
+		expireAt = maxExpireAt
+	}
+	if !expireAt.After(expiry.now) {
+		return time.Time{}
+	}
+	return expireAt
+}
+
+// 获取最终 URL 解析器统计信息
+//
+// GET /MediaWarp/api/resolver
+func FinalURLResolverStatsHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, finalURLResolver.Stats())
+}
+
+// 清空最终 URL 缓存
+//
+// DELETE /MediaWarp/api/resolver
+func FinalURLResolverFlushHandler(ctx *gin.Context) {
+	finalURLResolver.Flush()
+	ctx.JSON(http.StatusOK, finalURLResolver.Stats())
+}
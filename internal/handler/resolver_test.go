@@ -0,0 +1,64 @@
+package handler_test
+
+import (
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/handler"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFinalURLResolver(t *testing.T) {
+	cacheSetting := config.FinalURLCacheSetting{DefaultTTL: time.Minute, MaxTTL: time.Hour}
+
+	var hits int
+	expires := strconv.FormatInt(time.Now().Add(10*time.Minute).Unix(), 10)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/strm", func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		http.Redirect(w, r, "/file.mkv?expires="+expires, http.StatusFound)
+	})
+	mux.HandleFunc("/file.mkv", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead { // 模拟拒绝 HEAD 请求的上游
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Range") != "bytes=0-0" {
+			t.Errorf("GET 回退请求应携带 Range 头，实际：%q", r.Header.Get("Range"))
+		}
+		w.WriteHeader(http.StatusPartialContent)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resolver := handler.GetFinalURLResolver()
+	resolver.Flush()
+	for range 2 {
+		finalURL, err := resolver.Resolve(server.URL+"/strm", "TestUA", cacheSetting)
+		if err != nil {
+			t.Fatalf("解析最终 URL 失败：%v", err)
+		}
+		if expected := server.URL + "/file.mkv?expires=" + expires; finalURL != expected {
+			t.Errorf("最终 URL 错误。期望: %s, 实际: %s", expected, finalURL)
+		}
+	}
+
+	if hits != 1 {
+		t.Errorf("第二次解析应命中缓存，实际请求上游 %d 次", hits)
+	}
+	stats := resolver.Stats()
+	if stats.CacheHits != 1 || stats.GETFallbacks != 1 || stats.CacheSize != 1 {
+		t.Errorf("统计信息错误：%+v", stats)
+	}
+
+	for range 2 { // 未启用缓存的媒体服务器
+		if _, err := resolver.Resolve(server.URL+"/strm", "OtherUA", config.FinalURLCacheSetting{}); err != nil {
+			t.Fatalf("解析最终 URL 失败：%v", err)
+		}
+	}
+	if hits != 3 {
+		t.Errorf("未启用缓存时每次解析都应请求上游，实际共请求上游 %d 次", hits)
+	}
+}
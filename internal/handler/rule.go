@@ -8,6 +8,7 @@ import (
 
 // 正则表达式路由规则
 type RegexpRouteRule struct {
+	Name    string // 规则名称，用于日志和指标
 	Regexp  *regexp.Regexp
 	Handler gin.HandlerFunc
 }
@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/logging"
+	"MediaWarp/internal/service"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const strmScanPageSize = 200 // 扫描时每次向媒体服务器请求的条目数量
+
+// Strm 条目
+//
+// 由媒体服务器 API 查询得到
+type StrmItem struct {
+	Upstream string // 所属上游媒体服务器名称，MediaServer 为空字符串
+	ItemID   string // 媒体服务器中的条目 ID
+	Name     string // 条目名称
+	Path     string // Strm 文件路径
+	Target   string // Strm 文件内容（媒体服务器中 MediaSource 的 Path）
+}
+
+// Strm 条目检测结果
+type StrmScanEntry struct {
+	Upstream        string                   `json:"Upstream,omitempty"` // 所属上游媒体服务器名称
+	ItemID          string                   `json:"ItemId"`
+	Name            string                   `json:"Name"`
+	Path            string                   `json:"Path"`
+	Target          string                   `json:"Target"`
+	RewrittenTarget string                   `json:"RewrittenTarget"` // 经过重写规则处理后的 Strm 内容
+	Type            constants.StrmFileType   `json:"Type"`
+	Status          constants.StrmScanStatus `json:"Status"`
+	FinalURL        string                   `json:"FinalURL,omitempty"` // HTTPStrm 跟踪重定向后的地址
+	Latency         int64                    `json:"Latency"`            // 解析耗时（毫秒）
+	Error           string                   `json:"Error,omitempty"`
+}
+
+// Strm 扫描报告
+type StrmScanReport struct {
+	Running   bool                             `json:"Running"`
+	StartTime time.Time                        `json:"StartTime"`
+	EndTime   time.Time                        `json:"EndTime"`
+	Total     int                              `json:"Total"`   // 扫描的 Strm 条目总数
+	Summary   map[constants.StrmScanStatus]int `json:"Summary"` // 各状态的条目数量
+	Entries   []StrmScanEntry                  `json:"Entries"` // 异常条目（失效、缓慢、循环重定向）
+	Error     string                           `json:"Error,omitempty"`
+}
+
+// Strm 扫描器
+//
+// 通过媒体服务器 API 枚举所有 Strm 条目，并检测其指向的资源是否可用
+type StrmScanner struct {
+	mutex  sync.RWMutex
+	report StrmScanReport
+}
+
+var (
+	strmScanner           = &StrmScanner{}
+	ErrStrmScanRunning    = errors.New("Strm 扫描正在进行中")
+	ErrMediaServerMissing = errors.New("媒体服务器处理器未初始化")
+)
+
+// 获取全局 Strm 扫描器
+func GetStrmScanner() *StrmScanner {
+	return strmScanner
+}
+
+// 获取最近一次扫描报告
+func (scanner *StrmScanner) Report() StrmScanReport {
+	scanner.mutex.RLock()
+	defer scanner.mutex.RUnlock()
+	report := scanner.report
+	report.Summary = maps.Clone(scanner.report.Summary) // 扫描进行中时报告仍会被修改，返回副本
+	report.Entries = slices.Clone(scanner.report.Entries)
+	return report
+}
+
+// 开始扫描
+//
+// 扫描在后台进行，若已有扫描在进行中则返回 ErrStrmScanRunning
+func (scanner *StrmScanner) Start() error {
+	scanner.mutex.Lock()
+	defer scanner.mutex.Unlock()
+	if scanner.report.Running {
+		return ErrStrmScanRunning
+	}
+	if mediaServerHandler == nil {
+		return ErrMediaServerMissing
+	}
+	scanner.report = StrmScanReport{
+		Running:   true,
+		StartTime: time.Now(),
+		Summary:   make(map[constants.StrmScanStatus]int),
+		Entries:   []StrmScanEntry{},
+	}
+	go scanner.run(mediaServers())
+	return nil
+}
+
+// 执行扫描
+//
+// 依次扫描所有媒体服务器
+func (scanner *StrmScanner) run(mediaServers []MediaServerHandler) {
+	logging.Info("开始扫描 Strm 文件")
+	var (
+		items   = make(chan StrmItem)
+		wg      sync.WaitGroup
+		workers = max(config.Get().StrmScan.Concurrency, 1)
+	)
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				scanner.record(checkStrmItem(item))
+			}
+		}()
+	}
+
+	var scanErrs []error
+	for _, mediaServer := range mediaServers {
+		for startIndex := 0; ; startIndex += strmScanPageSize {
+			strmItems, total, err := mediaServer.ListStrmItems(startIndex, strmScanPageSize)
+			if err != nil {
+				if mediaServer.Name() != "" {
+					err = fmt.Errorf("%s：%w", mediaServer.Name(), err)
+				}
+				scanErrs = append(scanErrs, fmt.Errorf("查询媒体服务器条目失败：%w", err))
+				break
+			}
+			for _, item := range strmItems {
+				items <- item
+			}
+			if startIndex+strmScanPageSize >= total {
+				break
+			}
+		}
+	}
+	close(items)
+	scanErr := errors.Join(scanErrs...)
+	wg.Wait()
+
+	scanner.mutex.Lock()
+	defer scanner.mutex.Unlock()
+	scanner.report.Running = false
+	scanner.report.EndTime = time.Now()
+	if scanErr != nil {
+		scanner.report.Error = scanErr.Error()
+		logging.Warning("Strm 扫描出错：", scanErr)
+	}
+	logging.Infof("Strm 扫描完成，共扫描 %d 个条目，耗时：%s", scanner.report.Total, scanner.report.EndTime.Sub(scanner.report.StartTime))
+}
+
+// 记录单个条目的检测结果
+func (scanner *StrmScanner) record(entry StrmScanEntry) {
+	scanner.mutex.Lock()
+	defer scanner.mutex.Unlock()
+	scanner.report.Total++
+	scanner.report.Summary[entry.Status]++
+	switch entry.Status {
+	case constants.StrmScanBroken, constants.StrmScanSlow, constants.StrmScanRedirectLoop:
+		scanner.report.Entries = append(scanner.report.Entries, entry)
+	}
+}
+
+// 检测单个 Strm 条目
+//
+// HTTPStrm 跟踪重定向链判断最终地址是否可用，AlistStrm 通过 FsGet 判断文件是否存在
+func checkStrmItem(item StrmItem) StrmScanEntry {
+	strmFileType, rule := recgonizeStrmFileType(item.Upstream, item.Path)
+	entry := StrmScanEntry{
+		Upstream: item.Upstream,
+		ItemID:   item.ItemID,
+		Name:     item.Name,
+		Path:     item.Path,
+		Target:   item.Target,
+		Type:     strmFileType,
+		Status:   constants.StrmScanOK,
+	}
+
+	startTime := time.Now()
+	switch strmFileType {
+	case constants.HTTPStrm:
+		entry.RewrittenTarget = rule.httpStrmURL(item.Target)
+		result, err := finalURLResolver.trace(entry.RewrittenTarget, "MediaWarp/"+config.Version().AppVersion, config.FinalURLCacheSetting{}) // 扫描时不读取也不写入缓存
+		switch {
+		case errors.Is(err, ErrRedirectLoop) || errors.Is(err, ErrMaxRedirectsExceeded):
+			entry.Status = constants.StrmScanRedirectLoop
+			entry.Error = err.Error()
+		case err != nil:
+			entry.Status = constants.StrmScanBroken
+			entry.Error = err.Error()
+		case result.statusCode >= http.StatusBadRequest:
+			entry.Status = constants.StrmScanBroken
+			entry.Error = fmt.Sprintf("最终地址响应状态码：%d", result.statusCode)
+		}
+		entry.FinalURL = result.finalURL
+
+	case constants.AlistStrm:
+		entry.RewrittenTarget = rule.rewrite(item.Target)
+		alistServer, err := service.GetAlistServer(rule.alistAddr)
+		if err != nil {
+			entry.Status = constants.StrmScanBroken
+			entry.Error = err.Error()
+			break
+		}
+		fsGetData, err := alistServer.FsGet(entry.RewrittenTarget)
+		if err != nil {
+			entry.Status = constants.StrmScanBroken
+			entry.Error = err.Error()
+		} else if fsGetData.IsDir {
+			entry.Status = constants.StrmScanBroken
+			entry.Error = "Strm 指向的路径是文件夹"
+		}
+
+	default:
+		entry.Status = constants.StrmScanSkipped
+	}
+
+	latency := time.Since(startTime)
+	entry.Latency = latency.Milliseconds()
+	if entry.Status == constants.StrmScanOK && latency > config.Get().StrmScan.SlowThreshold {
+		entry.Status = constants.StrmScanSlow
+	}
+	if entry.Status != constants.StrmScanOK && entry.Status != constants.StrmScanSkipped {
+		logging.Debugf("Strm 条目 %s（%s）检测结果：%s %s", entry.Name, entry.Path, entry.Status, entry.Error)
+	}
+	return entry
+}
+
+// 开始 Strm 扫描
+//
+// POST /MediaWarp/api/scan
+func StrmScanStartHandler(ctx *gin.Context) {
+	if err := strmScanner.Start(); err != nil {
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, strmScanner.Report())
+}
+
+// 获取 Strm 扫描报告
+//
+// GET /MediaWarp/api/scan
+func StrmScanReportHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, strmScanner.Report())
+}
@@ -3,34 +3,258 @@ package handler
 import (
 	"MediaWarp/constants"
 	"MediaWarp/internal/config"
+	"MediaWarp/internal/logging"
+	"context"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
 )
 
 // 媒体服务器处理接口
 type MediaServerHandler interface {
+	Name() string                                    // 上游媒体服务器名称，MediaServer 为空字符串
 	ReverseProxy(http.ResponseWriter, *http.Request) // 转发请求至上游服务器
 	GetRegexpRouteRules() []RegexpRouteRule          // 获取正则路由表
+	ListStrmItems(int, int) ([]StrmItem, int, error) // 分页获取 Strm 条目
+	GetStrmItems(string) ([]StrmItem, error)         // 获取指定条目的 Strm 条目
+	ListLibraries() ([]Library, error)               // 获取媒体库列表
+	GetUser(string) (*MediaServerUser, error)        // 获取访问令牌对应的用户
 }
 
-var mediaServerHandler MediaServerHandler
+// 上游媒体服务器
+type upstream struct {
+	setting config.UpstreamSetting
+	handler MediaServerHandler
+}
+
+type (
+	mediaServerContextKey struct{} // 请求上下文中保存所选媒体服务器的键
+	pathPrefixContextKey  struct{} // 请求上下文中保存匹配上游媒体服务器时去除的路径前缀的键
+)
+
+var (
+	mediaServerHandler MediaServerHandler // MediaServer 对应的处理器，未匹配任何上游媒体服务器时使用
+	upstreams          []upstream         // Upstreams 对应的处理器，按配置顺序匹配
+)
+
 var ErrInvalidMediaServerType = errors.New("错误的媒体服务器类型")
 
 // 初始化媒体服务器处理器
-func Init() error {
+//
+// ctx 取消时停止后台任务（地址池健康检查、播放会话清理）
+func Init(ctx context.Context) error {
+	if err := initStrmRules(); err != nil {
+		return err
+	}
+
 	var err error
-	switch config.MediaServer.Type {
+	mediaServerHandler, err = newMediaServerHandler(ctx, "", config.Get().MediaServer)
+	if err != nil {
+		return err
+	}
+	upstreams = make([]upstream, 0, len(config.Get().Upstreams))
+	for _, setting := range config.Get().Upstreams {
+		handler, err := newMediaServerHandler(ctx, setting.Name, setting.MediaServerSetting)
+		if err != nil {
+			return fmt.Errorf("上游媒体服务器 %s：%w", setting.Name, err)
+		}
+		upstreams = append(upstreams, upstream{setting: setting, handler: handler})
+	}
+	go sessionTracker.runCleanup(ctx)
+	return nil
+}
+
+// 按媒体服务器类型创建处理器
+func newMediaServerHandler(ctx context.Context, name string, setting config.MediaServerSetting) (MediaServerHandler, error) {
+	switch setting.Type {
 	case constants.EMBY:
-		mediaServerHandler, err = NewEmbyServerHandler(config.MediaServer.ADDR, config.MediaServer.AUTH)
+		return NewEmbyServerHandler(ctx, name, setting)
 	case constants.JELLYFIN:
-		mediaServerHandler, err = NewJellyfinHander(config.MediaServer.ADDR, config.MediaServer.AUTH)
+		return NewJellyfinHander(ctx, name, setting)
 	default:
-		err = ErrInvalidMediaServerType
+		return nil, ErrInvalidMediaServerType
 	}
-	return err
 }
 
 // 获取媒体服务器接口
+//
+// 返回 MediaServer 对应的处理器
 func GetMediaServer() MediaServerHandler {
 	return mediaServerHandler
 }
+
+// 获取所有媒体服务器接口
+//
+// MediaServer 在前，其余按 Upstreams 的配置顺序排列
+func mediaServers() []MediaServerHandler {
+	handlers := make([]MediaServerHandler, 0, len(upstreams)+1)
+	handlers = append(handlers, mediaServerHandler)
+	for _, upstream := range upstreams {
+		handlers = append(handlers, upstream.handler)
+	}
+	return handlers
+}
+
+// 按名称获取媒体服务器接口
+//
+// name 为空时返回 MediaServer 对应的处理器，未找到时返回 false
+func mediaServerByName(name string) (MediaServerHandler, bool) {
+	for _, handler := range mediaServers() {
+		if handler.Name() == name {
+			return handler, true
+		}
+	}
+	return nil, false
+}
+
+// 获取处理请求的媒体服务器接口
+//
+// 返回 SelectMediaServer 选择的媒体服务器，未选择时返回 MediaServer 对应的处理器
+func MediaServerOf(req *http.Request) MediaServerHandler {
+	if handler, ok := req.Context().Value(mediaServerContextKey{}).(MediaServerHandler); ok {
+		return handler
+	}
+	return mediaServerHandler
+}
+
+// 获取处理请求的媒体服务器名称
+//
+// MediaServer 对应的处理器返回空字符串
+func mediaServerNameOf(req *http.Request) string {
+	if handler := MediaServerOf(req); handler != nil {
+		return handler.Name()
+	}
+	return ""
+}
+
+// 获取匹配上游媒体服务器时去除的路径前缀
+//
+// 保持请求中的大小写，未去除时返回空字符串
+func PathPrefixOf(req *http.Request) string {
+	prefix, _ := req.Context().Value(pathPrefixContextKey{}).(string)
+	return prefix
+}
+
+// 为绝对路径的重定向地址加上去除的路径前缀
+//
+// 仅处理以 / 开头的路径，完整 URL 和 //host 形式的地址原样返回
+func withPathPrefix(req *http.Request, location string) string {
+	prefix := PathPrefixOf(req)
+	if prefix == "" || !strings.HasPrefix(location, "/") || strings.HasPrefix(location, "//") {
+		return location
+	}
+	return prefix + location
+}
+
+// 根据请求选择上游媒体服务器
+//
+// 按配置顺序匹配 Upstreams，返回在上下文中保存了所选媒体服务器的请求，匹配路径前缀时去除该前缀并保存在上下文中
+// 均未匹配时原样返回请求，使用 MediaServer
+func SelectMediaServer(req *http.Request) *http.Request {
+	for _, upstream := range upstreams {
+		if !upstream.match(req) {
+			continue
+		}
+		logging.Debugf("%s%s 使用上游媒体服务器：%s", req.Host, req.URL.Path, upstream.setting.Name)
+		req = req.WithContext(context.WithValue(req.Context(), mediaServerContextKey{}, upstream.handler))
+		if prefix := upstream.setting.PathPrefix; prefix != "" {
+			req = req.WithContext(context.WithValue(req.Context(), pathPrefixContextKey{}, req.URL.Path[:len(prefix)]))
+			u := *req.URL
+			u.Path = trimPathPrefix(u.Path, prefix)
+			if hasPathPrefix(u.RawPath, prefix) {
+				u.RawPath = trimPathPrefix(u.RawPath, prefix)
+			} else {
+				u.RawPath = ""
+			}
+			req.URL = &u
+		}
+		return req
+	}
+	return req
+}
+
+// 判断请求是否匹配上游媒体服务器
+//
+// 设置的条件需全部满足
+func (upstream upstream) match(req *http.Request) bool {
+	setting := upstream.setting
+	if len(setting.Hosts) > 0 {
+		host := req.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if !slices.ContainsFunc(setting.Hosts, func(item string) bool { return strings.EqualFold(item, host) }) {
+			return false
+		}
+	}
+	if len(setting.Ports) > 0 {
+		localAddr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+		if !ok {
+			return false
+		}
+		addrPort, err := netip.ParseAddrPort(localAddr.String())
+		if err != nil || !slices.Contains(setting.Ports, int(addrPort.Port())) {
+			return false
+		}
+	}
+	if setting.PathPrefix != "" && !hasPathPrefix(req.URL.Path, setting.PathPrefix) {
+		return false
+	}
+	return true
+}
+
+// 判断路径是否以 prefix 开头（按路径段匹配，忽略大小写）
+func hasPathPrefix(path string, prefix string) bool {
+	return len(path) >= len(prefix) && strings.EqualFold(path[:len(prefix)], prefix) && (len(path) == len(prefix) || path[len(prefix)] == '/')
+}
+
+// 去除路径前缀
+func trimPathPrefix(path string, prefix string) string {
+	if path = path[len(prefix):]; path == "" {
+		return "/"
+	}
+	return path
+}
+
+// 获取上游媒体服务器设置
+//
+// name 为空（MediaServer）或未找到时返回 false
+func upstreamSetting(name string) (config.UpstreamSetting, bool) {
+	if name == "" {
+		return config.UpstreamSetting{}, false
+	}
+	for _, setting := range config.Get().Upstreams {
+		if setting.Name == name {
+			return setting, true
+		}
+	}
+	return config.UpstreamSetting{}, false
+}
+
+// 媒体服务器使用的 Web 页面修改设置
+func webSetting(name string) config.WebSetting {
+	if setting, ok := upstreamSetting(name); ok && setting.Web != nil {
+		return *setting.Web
+	}
+	return config.Get().Web
+}
+
+// 媒体服务器使用的 HTTPStrm 设置
+func httpStrmSetting(name string) config.HTTPStrmSetting {
+	if setting, ok := upstreamSetting(name); ok && setting.HTTPStrm != nil {
+		return *setting.HTTPStrm
+	}
+	return config.Get().HTTPStrm
+}
+
+// 媒体服务器使用的 AlistStrm 设置
+func alistStrmSetting(name string) config.AlistStrmSetting {
+	if setting, ok := upstreamSetting(name); ok && setting.AlistStrm != nil {
+		return *setting.AlistStrm
+	}
+	return config.Get().AlistStrm
+}
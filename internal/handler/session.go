@@ -0,0 +1,434 @@
+package handler
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/logging"
+	"MediaWarp/internal/service/emby"
+	"MediaWarp/internal/service/jellyfin"
+	"MediaWarp/utils"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sessionIdleTimeout     = 10 * time.Minute // 超过该时间未收到任何播放请求或进度报告的会话视为已结束
+	sessionCleanupInterval = time.Minute      // 清理已结束会话的间隔
+	maxPlayingReportSize   = 64 << 10         // 读取播放进度报告请求体的最大长度，超出部分原样转发
+	ticksPerSecond         = 10_000_000       // Emby/Jellyfin 中 1 秒对应的 Ticks 数
+)
+
+// 播放会话
+//
+// 由 PlaybackInfo 请求、视频流重定向和 /Sessions/Playing* 进度报告关联得到
+type PlaybackSession struct {
+	ID             string                  `json:"Id"`     // PlaySessionId，客户端未提供时为 DeviceId 与 MediaSourceId 的组合
+	Server         string                  `json:"Server"` // 上游媒体服务器名称，MediaServer 为空
+	UserID         string                  `json:"UserId"`
+	Client         string                  `json:"Client"`
+	Device         string                  `json:"Device"`
+	DeviceID       string                  `json:"DeviceId"`
+	ItemID         string                  `json:"ItemId"`
+	MediaSourceID  string                  `json:"MediaSourceId"`
+	Name           string                  `json:"Name"`
+	StrmType       constants.StrmFileType  `json:"StrmType"`
+	Source         string                  `json:"Source"` // 重定向的目标地址
+	State          constants.PlaybackState `json:"State"`
+	Size           int64                   `json:"Size"`
+	Bitrate        int64                   `json:"Bitrate"`
+	RunTimeTicks   int64                   `json:"RunTimeTicks"`
+	PositionTicks  int64                   `json:"PositionTicks"`
+	EstimatedBytes int64                   `json:"EstimatedBytes"` // 根据播放进度估算的已传输字节数
+	StartTime      time.Time               `json:"StartTime"`
+	RedirectTime   time.Time               `json:"RedirectTime"`
+	LastActive     time.Time               `json:"LastActive"`
+
+	authUser policyUser // 通过访问令牌验证的用户，用于访问策略和播放限制（UserID 由客户端提供，不可信）
+}
+
+// 估算已传输的字节数
+//
+// 优先使用进度报告中的播放位置，没有进度报告时使用重定向后经过的时间
+// 优先按码率计算，没有码率时按文件大小和时长的比例计算
+func (session *PlaybackSession) estimateBytes(now time.Time) int64 {
+	positionTicks := session.PositionTicks
+	if positionTicks == 0 && !session.RedirectTime.IsZero() {
+		positionTicks = int64(now.Sub(session.RedirectTime) / 100) // 1 Tick = 100ns
+	}
+	if session.RunTimeTicks > 0 {
+		positionTicks = min(positionTicks, session.RunTimeTicks)
+	}
+
+	switch {
+	case session.Bitrate > 0:
+		return int64(float64(session.Bitrate) / 8 * float64(positionTicks) / ticksPerSecond)
+	case session.Size > 0 && session.RunTimeTicks > 0:
+		return int64(float64(session.Size) * float64(positionTicks) / float64(session.RunTimeTicks))
+	default:
+		return 0
+	}
+}
+
+// 会话关联的媒体信息
+type sessionMedia struct {
+	ItemID        string
+	MediaSourceID string
+	Name          string
+	Path          string // Strm 文件路径
+	StrmType      constants.StrmFileType
+	Size          int64
+	Bitrate       int64
+	RunTimeTicks  int64
+}
+
+func newEmbySessionMedia(item emby.BaseItemDto, mediasource emby.MediaSourceInfo, strmType constants.StrmFileType) sessionMedia {
+	return sessionMedia{
+		ItemID:        utils.Deref(item.ID),
+		MediaSourceID: utils.Deref(mediasource.ID),
+		Name:          itemDisplayName(utils.Deref(item.SeriesName), utils.Deref(item.Name)),
+		Path:          utils.Deref(item.Path),
+		StrmType:      strmType,
+		Size:          utils.Deref(mediasource.Size),
+		Bitrate:       utils.Deref(mediasource.Bitrate),
+		RunTimeTicks:  utils.Deref(mediasource.RunTimeTicks),
+	}
+}
+
+func newJellyfinSessionMedia(item jellyfin.BaseItemDto, mediasource jellyfin.MediaSourceInfo, strmType constants.StrmFileType) sessionMedia {
+	return sessionMedia{
+		ItemID:        utils.Deref(item.ID),
+		MediaSourceID: utils.Deref(mediasource.ID),
+		Name:          itemDisplayName(utils.Deref(item.SeriesName), utils.Deref(item.Name)),
+		Path:          utils.Deref(item.Path),
+		StrmType:      strmType,
+		Size:          utils.Deref(mediasource.Size),
+		Bitrate:       utils.Deref(mediasource.Bitrate),
+		RunTimeTicks:  utils.Deref(mediasource.RunTimeTicks),
+	}
+}
+
+// 条目展示名称
+//
+// 剧集条目带上剧名
+func itemDisplayName(seriesName string, name string) string {
+	if seriesName != "" {
+		return seriesName + " - " + name
+	}
+	return name
+}
+
+// 保留原直链播放链接中用于关联播放会话的查询参数
+//
+// 改写后的直链播放链接仍携带 DeviceId、PlaySessionId，便于视频流请求与 PlaybackInfo 请求关联
+func withSessionParams(directStreamURL string, original string) string {
+	u, err := url.Parse(original)
+	if err != nil {
+		return directStreamURL
+	}
+	for _, param := range []string{"DeviceId", "PlaySessionId"} {
+		for key, values := range u.Query() {
+			if strings.EqualFold(key, param) && values[0] != "" {
+				directStreamURL += "&" + param + "=" + url.QueryEscape(values[0])
+				break
+			}
+		}
+	}
+	return directStreamURL
+}
+
+// 客户端上报的播放进度
+//
+// /Sessions/Playing、/Sessions/Playing/Progress、/Sessions/Playing/Stopped 的请求体
+type playingReport struct {
+	ItemID        string `json:"ItemId"`
+	MediaSourceID string `json:"MediaSourceId"`
+	PlaySessionID string `json:"PlaySessionId"`
+	PositionTicks *int64 `json:"PositionTicks"`
+	IsPaused      bool   `json:"IsPaused"`
+}
+
+// 播放会话跟踪器
+//
+// 仅跟踪 Strm 文件的播放，会话停止或长时间无活动后移除
+// 不同媒体服务器的 PlaySessionId、DeviceId 可能相同，会话按媒体服务器区分
+type SessionTracker struct {
+	mutex    sync.Mutex
+	sessions map[string]*PlaybackSession // 键为 sessionKey
+}
+
+var sessionTracker = &SessionTracker{sessions: make(map[string]*PlaybackSession)}
+
+// 获取全局播放会话跟踪器
+func GetSessionTracker() *SessionTracker {
+	return sessionTracker
+}
+
+// 会话在跟踪器中的键
+//
+// server 为媒体服务器名称，id 为会话 ID
+func sessionKey(server string, id string) string {
+	return server + "\x00" + id
+}
+
+// 查找会话
+//
+// 在媒体服务器 server 的会话中优先按 PlaySessionId 查找，其次按设备和媒体源查找
+// 调用方需持有写锁
+func (tracker *SessionTracker) find(server string, client utils.ClientInfo, playSessionID string, mediaSourceID string) *PlaybackSession {
+	if session, ok := tracker.sessions[sessionKey(server, playSessionID)]; ok && playSessionID != "" {
+		return session
+	}
+	if client.DeviceID == "" || mediaSourceID == "" {
+		return nil
+	}
+	var found *PlaybackSession
+	for _, session := range tracker.sessions {
+		if session.Server != server {
+			continue
+		}
+		if playSessionID != "" && session.ID != fallbackSessionID(session.DeviceID, session.MediaSourceID) { // 同一设备使用新的 PlaySessionId 播放同一媒体源视为新会话
+			continue
+		}
+		if session.DeviceID == client.DeviceID && session.MediaSourceID == mediaSourceID && (found == nil || session.LastActive.After(found.LastActive)) {
+			found = session
+		}
+	}
+	return found
+}
+
+// 客户端未提供 PlaySessionId 时使用的会话 ID
+func fallbackSessionID(deviceID string, mediaSourceID string) string {
+	return deviceID + "|" + mediaSourceID
+}
+
+// 查找或创建会话
+//
+// 调用方需持有写锁
+func (tracker *SessionTracker) findOrCreate(server string, client utils.ClientInfo, playSessionID string, media sessionMedia) *PlaybackSession {
+	session := tracker.find(server, client, playSessionID, media.MediaSourceID)
+	if session == nil {
+		id := playSessionID
+		if id == "" {
+			id = fallbackSessionID(client.DeviceID, media.MediaSourceID)
+		}
+		session = &PlaybackSession{ID: id, Server: server, State: constants.PlaybackPreparing, StartTime: time.Now()}
+		tracker.sessions[sessionKey(server, id)] = session
+	}
+
+	session.updateClient(client)
+	if media.ItemID != "" {
+		session.ItemID = media.ItemID
+	}
+	if media.MediaSourceID != "" {
+		session.MediaSourceID = media.MediaSourceID
+	}
+	if media.Name != "" {
+		session.Name = media.Name
+	}
+	if media.StrmType != "" {
+		session.StrmType = media.StrmType
+	}
+	session.Size = max(session.Size, media.Size)
+	session.Bitrate = max(session.Bitrate, media.Bitrate)
+	session.RunTimeTicks = max(session.RunTimeTicks, media.RunTimeTicks)
+	session.LastActive = time.Now()
+	return session
+}
+
+// 更新会话的客户端信息
+//
+// 不同请求携带的客户端信息不完全相同，仅使用非空值覆盖
+func (session *PlaybackSession) updateClient(client utils.ClientInfo) {
+	for _, field := range []struct {
+		target *string
+		value  string
+	}{
+		{&session.UserID, client.UserID},
+		{&session.Client, client.Client},
+		{&session.Device, client.Device},
+		{&session.DeviceID, client.DeviceID},
+	} {
+		if field.value != "" {
+			*field.target = field.value
+		}
+	}
+}
+
+// 记录会话已验证的用户
+//
+// 未验证的用户（ID 为空）不覆盖原值
+func (session *PlaybackSession) setAuthUser(user policyUser) {
+	if user.ID != "" {
+		session.authUser = user
+	}
+}
+
+// 记录 PlaybackInfo 请求
+//
+// user 为通过访问令牌验证的用户，未验证时为空
+func (tracker *SessionTracker) preparing(server string, client utils.ClientInfo, user policyUser, playSessionID string, media sessionMedia) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.findOrCreate(server, client, playSessionID, media).setAuthUser(user)
+}
+
+// 记录视频流重定向
+//
+// user 为通过访问令牌验证的用户，未验证时为空
+// 返回会话快照，以及是否为该会话的首次重定向（播放器拖动进度等会多次请求视频流）
+func (tracker *SessionTracker) redirected(server string, client utils.ClientInfo, user policyUser, playSessionID string, media sessionMedia, source string) (PlaybackSession, bool) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	session := tracker.findOrCreate(server, client, playSessionID, media)
+	session.setAuthUser(user)
+	first := session.RedirectTime.IsZero()
+	session.Source = source
+	session.RedirectTime = time.Now()
+	if session.State == constants.PlaybackPreparing {
+		session.State = constants.PlaybackPlaying
+	}
+	return *session, first
+}
+
+// 获取会话快照
+//
+// 会话不存在时返回 false
+func (tracker *SessionTracker) get(server string, client utils.ClientInfo, playSessionID string, mediaSourceID string) (PlaybackSession, bool) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	session := tracker.find(server, client, playSessionID, mediaSourceID)
+	if session == nil {
+		return PlaybackSession{}, false
+	}
+	return *session, true
+}
+
+// 记录客户端上报的播放进度
+//
+// 仅更新已存在的会话（即 Strm 文件的播放）
+func (tracker *SessionTracker) report(server string, client utils.ClientInfo, state constants.PlaybackState, report playingReport) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	session := tracker.find(server, client, report.PlaySessionID, report.MediaSourceID)
+	if session == nil {
+		return
+	}
+
+	session.updateClient(client)
+	if report.PositionTicks != nil {
+		session.PositionTicks = *report.PositionTicks
+	}
+	session.LastActive = time.Now()
+	switch {
+	case state == constants.PlaybackStopped:
+		session.State = constants.PlaybackStopped
+		delete(tracker.sessions, sessionKey(session.Server, session.ID))
+		logging.Debugf("播放会话 %s 已停止：%s（%s）", session.ID, session.Name, session.Device)
+	case state == constants.PlaybackPlaying && report.IsPaused:
+		session.State = constants.PlaybackPaused
+	case state != "":
+		session.State = state
+	}
+}
+
+// 获取当前所有播放会话
+//
+// 移除长时间无活动的会话，按开始时间从新到旧排序
+func (tracker *SessionTracker) Sessions() []PlaybackSession {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	now := time.Now()
+	tracker.evictIdle(now)
+	sessions := make([]PlaybackSession, 0, len(tracker.sessions))
+	for _, session := range tracker.sessions {
+		snapshot := *session
+		snapshot.EstimatedBytes = session.estimateBytes(now)
+		sessions = append(sessions, snapshot)
+	}
+	slices.SortFunc(sessions, func(a, b PlaybackSession) int {
+		return b.StartTime.Compare(a.StartTime)
+	})
+	return sessions
+}
+
+// 移除长时间无活动的会话
+//
+// 调用方需持有写锁
+func (tracker *SessionTracker) evictIdle(now time.Time) {
+	for id, session := range tracker.sessions {
+		if now.Sub(session.LastActive) > sessionIdleTimeout {
+			delete(tracker.sessions, id)
+		}
+	}
+}
+
+// 定期移除长时间无活动的会话
+//
+// 客户端未上报停止时会话只能由此移除；ctx 取消时停止
+func (tracker *SessionTracker) runCleanup(ctx context.Context) {
+	ticker := time.NewTicker(sessionCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			tracker.mutex.Lock()
+			tracker.evictIdle(now)
+			tracker.mutex.Unlock()
+		}
+	}
+}
+
+// 播放进度报告处理器
+//
+// /Sessions/Playing、/Sessions/Playing/Progress、/Sessions/Playing/Stopped、/Sessions/Playing/Ping
+// 记录媒体服务器 server 的播放进度后将请求原样转发至上游服务器
+func playingReportHandler(server string, reverseProxy func(http.ResponseWriter, *http.Request)) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.Request.Method == http.MethodPost {
+			body, err := io.ReadAll(io.LimitReader(ctx.Request.Body, maxPlayingReportSize))
+			ctx.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), ctx.Request.Body))
+			if err != nil {
+				logging.Warning("读取播放进度报告失败：", err)
+			} else {
+				var report playingReport
+				if len(body) > 0 {
+					if err := json.Unmarshal(body, &report); err != nil {
+						logging.Debug("解析播放进度报告失败：", err)
+					}
+				}
+				if report.PlaySessionID == "" {
+					report.PlaySessionID = ctx.Query("playsessionid") // Ping 等请求通过查询参数传递
+				}
+				var state constants.PlaybackState
+				switch {
+				case strings.HasSuffix(strings.ToLower(ctx.Request.URL.Path), "/stopped"):
+					state = constants.PlaybackStopped
+				case strings.HasSuffix(strings.ToLower(ctx.Request.URL.Path), "/ping"):
+				default:
+					state = constants.PlaybackPlaying
+				}
+				sessionTracker.report(server, utils.GetClientInfo(ctx.Request), state, report)
+			}
+		}
+		reverseProxy(ctx.Writer, ctx.Request)
+	}
+}
+
+// 获取当前播放会话
+//
+// GET /MediaWarp/api/sessions
+func SessionsHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, sessionTracker.Sessions())
+}
@@ -0,0 +1,40 @@
+package handler_test
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/handler"
+	"MediaWarp/utils"
+	"testing"
+)
+
+// 不同媒体服务器中相同的 PlaySessionId 和 DeviceId 属于不同的会话
+func TestSessionsPerServer(t *testing.T) {
+	handler.ResetPlayback()
+	config.Set(&config.Settings{
+		Policy: config.PolicySetting{Enable: true, Default: constants.PolicyAllow},
+		Limit:  config.LimitSetting{Enable: true, MaxStreamsPerDevice: 1},
+	})
+	bob := handler.PolicyUser{ID: "b1", Name: "bob"}
+	client := utils.ClientInfo{DeviceID: "d1"}
+	handler.PreparePlayback("", client, alice, "ps1", handler.SessionMedia{MediaSourceID: "m1"})
+	handler.RedirectPlayback("jellyfin", client, bob, "ps1", handler.SessionMedia{MediaSourceID: "m1"})
+	handler.RedirectPlayback("jellyfin", client, bob, "ps2", handler.SessionMedia{MediaSourceID: "m2"})
+
+	sessions := handler.GetSessionTracker().Sessions()
+	servers := make(map[string]int)
+	for _, session := range sessions {
+		servers[session.Server]++
+	}
+	if len(sessions) != 3 || servers[""] != 1 || servers["jellyfin"] != 2 {
+		t.Fatalf("会话为 %+v，期望 MediaServer 1 个、jellyfin 2 个", sessions)
+	}
+
+	_, user, ok, code := streamRequest(t, "d1", "ps1", "m1")
+	if user != alice {
+		t.Errorf("用户为 %+v，期望 MediaServer 会话中的 %+v", user, alice)
+	}
+	if !ok {
+		t.Errorf("响应 %d，其他媒体服务器的会话不应计入同时播放数量", code)
+	}
+}
@@ -0,0 +1,361 @@
+package handler
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/logging"
+	"MediaWarp/utils"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Strm 规则
+//
+// 由配置文件中的 HTTPStrm、AlistStrm 设置编译得到
+type strmRule struct {
+	strmFileType constants.StrmFileType
+	prefixList   []string           // Strm 文件路径前缀
+	alistAddr    string             // AlistStrm 对应的 Alist 服务器地址
+	rewriteRules utils.RewriteRules // Strm 内容重写规则
+	urlRules     []*httpStrmURLRule // HTTPStrm URL 处理规则
+}
+
+// HTTPStrm URL 处理规则
+type httpStrmURLRule struct {
+	hosts    []string           // 仅处理主机匹配的 URL，为空则处理所有 URL
+	host     string             // 替换后的主机
+	template *template.Template // URL 模板
+	sign     config.URLSignSetting
+}
+
+// URL 模板可用字段
+type httpStrmURLTemplateData struct {
+	URL      string // 完整 URL
+	Scheme   string // 协议
+	Host     string // 主机（可能包含端口）
+	Path     string // 路径（已转义）
+	RawQuery string // 查询参数（已转义，不含 ?）
+}
+
+const defaultSignExpire = time.Hour // HMAC、NginxSecureLink 签名默认有效期
+
+var (
+	strmRules      map[string][]*strmRule // 各媒体服务器按配置顺序排列的 Strm 规则，HTTPStrm 优先，键为上游媒体服务器名称（MediaServer 为空字符串）
+	strmRulesMutex sync.RWMutex
+)
+
+// 重写 Strm 内容
+//
+// 在请求 Alist 或重定向之前将媒体服务器记录的 Strm 内容转换为实际地址
+func (rule *strmRule) rewrite(target string) string {
+	rewritten := rule.rewriteRules.Rewrite(target)
+	if rewritten != target {
+		logging.Debugf("Strm 内容重写：%s -> %s", target, rewritten)
+	}
+	return rewritten
+}
+
+// 得到 HTTPStrm 的重定向地址
+//
+// 依次应用 Strm 内容重写规则和第一条匹配的 URL 处理规则，处理失败时使用重写后的地址
+func (rule *strmRule) httpStrmURL(target string) string {
+	rawURL := rule.rewrite(target)
+	if len(rule.urlRules) == 0 {
+		return rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		logging.Warningf("解析 HTTPStrm URL %s 失败：%s", rawURL, err)
+		return rawURL
+	}
+	for _, urlRule := range rule.urlRules {
+		if !urlRule.match(u) {
+			continue
+		}
+		result, err := urlRule.apply(u)
+		if err != nil {
+			logging.Warningf("处理 HTTPStrm URL %s 失败：%s", rawURL, err)
+			return rawURL
+		}
+		logging.Debugf("HTTPStrm URL 处理：%s -> %s", rawURL, result)
+		return result
+	}
+	return rawURL
+}
+
+// 判断 URL 是否匹配该规则
+func (urlRule *httpStrmURLRule) match(u *url.URL) bool {
+	if len(urlRule.hosts) == 0 {
+		return true
+	}
+	for _, host := range urlRule.hosts {
+		if strings.EqualFold(u.Host, host) || strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// 对 URL 依次替换主机、套用模板、签名
+func (urlRule *httpStrmURLRule) apply(original *url.URL) (string, error) {
+	u := *original
+	if urlRule.host != "" {
+		u.Host = urlRule.host
+	}
+
+	if urlRule.template != nil {
+		var buf bytes.Buffer
+		data := httpStrmURLTemplateData{
+			URL:      u.String(),
+			Scheme:   u.Scheme,
+			Host:     u.Host,
+			Path:     u.EscapedPath(),
+			RawQuery: u.RawQuery,
+		}
+		if err := urlRule.template.Execute(&buf, data); err != nil {
+			return "", fmt.Errorf("渲染 URL 模板失败：%w", err)
+		}
+		templated, err := url.Parse(buf.String())
+		if err != nil {
+			return "", fmt.Errorf("解析模板生成的 URL 失败：%w", err)
+		}
+		u = *templated
+	}
+
+	expire := urlRule.sign.Expire
+	switch urlRule.sign.Type {
+	case constants.URLSignHMAC:
+		if expire <= 0 {
+			expire = defaultSignExpire
+		}
+		utils.SignURLHMAC(&u, urlRule.sign.Secret, time.Now().Add(expire).Unix(), urlRule.sign.SignParam, urlRule.sign.ExpireParam)
+	case constants.URLSignNginxSecureLink:
+		if expire <= 0 {
+			expire = defaultSignExpire
+		}
+		utils.SignURLNginxSecureLink(&u, urlRule.sign.Secret, time.Now().Add(expire).Unix())
+	case constants.URLSignAlist:
+		var expires int64 // 0 表示永不过期
+		if expire > 0 {
+			expires = time.Now().Add(expire).Unix()
+		}
+		utils.SignURLAlist(&u, urlRule.sign.Secret, expires)
+	}
+	return u.String(), nil
+}
+
+// 初始化 Strm 规则
+func initStrmRules() error {
+	rules, err := buildStrmRules(config.Get())
+	if err != nil {
+		return err
+	}
+	setStrmRules(rules)
+	return nil
+}
+
+// 编译 Strm 规则
+//
+// 从配置中读取全局和各上游媒体服务器的 HTTPStrm、AlistStrm 设置，编译重写规则
+// 未单独设置 HTTPStrm、AlistStrm 的上游媒体服务器使用全局规则
+func buildStrmRules(settings *config.Settings) (map[string][]*strmRule, error) {
+	rules := make(map[string][]*strmRule, len(settings.Upstreams)+1)
+	var err error
+	if rules[""], err = compileStrmRules(settings.HTTPStrm, settings.AlistStrm); err != nil {
+		return nil, err
+	}
+	for _, upstream := range settings.Upstreams {
+		if upstream.HTTPStrm == nil && upstream.AlistStrm == nil {
+			continue
+		}
+		httpStrm, alistStrm := settings.HTTPStrm, settings.AlistStrm
+		if upstream.HTTPStrm != nil {
+			httpStrm = *upstream.HTTPStrm
+		}
+		if upstream.AlistStrm != nil {
+			alistStrm = *upstream.AlistStrm
+		}
+		if rules[upstream.Name], err = compileStrmRules(httpStrm, alistStrm); err != nil {
+			return nil, fmt.Errorf("上游媒体服务器 %s：%w", upstream.Name, err)
+		}
+	}
+	return rules, nil
+}
+
+// 替换 Strm 规则
+func setStrmRules(rules map[string][]*strmRule) {
+	strmRulesMutex.Lock()
+	strmRules = rules
+	strmRulesMutex.Unlock()
+}
+
+// 编译一组 HTTPStrm、AlistStrm 设置
+func compileStrmRules(httpStrm config.HTTPStrmSetting, alistStrm config.AlistStrmSetting) ([]*strmRule, error) {
+	var rules []*strmRule
+	if httpStrm.Enable {
+		rewriteRules, err := compileRewriteRules(httpStrm.RewriteRules)
+		if err != nil {
+			return nil, fmt.Errorf("HTTPStrm 重写规则错误：%w", err)
+		}
+		urlRules, err := compileHTTPStrmURLRules(httpStrm.URLRules)
+		if err != nil {
+			return nil, fmt.Errorf("HTTPStrm URL 处理规则错误：%w", err)
+		}
+		rules = append(rules, &strmRule{
+			strmFileType: constants.HTTPStrm,
+			prefixList:   httpStrm.PrefixList,
+			rewriteRules: rewriteRules,
+			urlRules:     urlRules,
+		})
+	}
+	if alistStrm.Enable {
+		for _, alistStrmConfig := range alistStrm.List {
+			rewriteRules, err := compileRewriteRules(alistStrmConfig.RewriteRules)
+			if err != nil {
+				return nil, fmt.Errorf("AlistStrm（%s）重写规则错误：%w", alistStrmConfig.ADDR, err)
+			}
+			rules = append(rules, &strmRule{
+				strmFileType: constants.AlistStrm,
+				prefixList:   alistStrmConfig.PrefixList,
+				alistAddr:    alistStrmConfig.ADDR,
+				rewriteRules: rewriteRules,
+			})
+		}
+	}
+	return rules, nil
+}
+
+// 将配置中的重写规则编译为 utils.RewriteRules
+func compileRewriteRules(settings []config.PathRewriteSetting) (utils.RewriteRules, error) {
+	rules := make(utils.RewriteRules, 0, len(settings))
+	for _, setting := range settings {
+		switch setting.Type {
+		case constants.PathRewritePrefix:
+			rules = append(rules, utils.NewPrefixRewriteRule(setting.From, setting.To))
+		case constants.PathRewriteRegexp:
+			rule, err := utils.NewRegexpRewriteRule(setting.From, setting.To)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rule)
+		case constants.PathRewriteURLDecode:
+			rules = append(rules, utils.NewURLDecodeRewriteRule())
+		default:
+			return nil, fmt.Errorf("未知的重写方式：%s", setting.Type)
+		}
+	}
+	return rules, nil
+}
+
+// 编译 HTTPStrm URL 处理规则
+func compileHTTPStrmURLRules(settings []config.HTTPStrmURLSetting) ([]*httpStrmURLRule, error) {
+	urlRules := make([]*httpStrmURLRule, 0, len(settings))
+	for index, setting := range settings {
+		urlRule := &httpStrmURLRule{
+			hosts: setting.Hosts,
+			host:  setting.Host,
+			sign:  setting.Sign,
+		}
+		if setting.Template != "" {
+			tmpl, err := template.New(fmt.Sprintf("HTTPStrmURL-%d", index)).Option("missingkey=error").Parse(setting.Template)
+			if err != nil {
+				return nil, err
+			}
+			urlRule.template = tmpl
+		}
+		switch setting.Sign.Type {
+		case "", constants.URLSignNginxSecureLink, constants.URLSignAlist:
+		case constants.URLSignHMAC:
+			if urlRule.sign.SignParam == "" {
+				urlRule.sign.SignParam = "sign"
+			}
+			if urlRule.sign.ExpireParam == "" {
+				urlRule.sign.ExpireParam = "expires"
+			}
+		default:
+			return nil, fmt.Errorf("未知的签名方式：%s", setting.Sign.Type)
+		}
+		urlRules = append(urlRules, urlRule)
+	}
+	return urlRules, nil
+}
+
+// 根据 Strm 文件路径识别 Strm 文件类型
+//
+// 使用 upstream（上游媒体服务器名称，MediaServer 为空字符串）对应的 Strm 规则
+// 返回 Strm 文件类型和匹配到的 Strm 规则（UnknownStrm 时为 nil）
+func recgonizeStrmFileType(upstream string, strmFilePath string) (constants.StrmFileType, *strmRule) {
+	strmRulesMutex.RLock()
+	rules, ok := strmRules[upstream]
+	if !ok {
+		rules = strmRules[""]
+	}
+	strmRulesMutex.RUnlock()
+	for _, rule := range rules {
+		for _, prefix := range rule.prefixList {
+			if strings.HasPrefix(strmFilePath, prefix) {
+				if rule.strmFileType == constants.AlistStrm {
+					logging.Debugf("%s 成功匹配路径：%s，Strm 类型：%s，AlistServer 地址：%s", strmFilePath, prefix, rule.strmFileType, rule.alistAddr)
+				} else {
+					logging.Debugf("%s 成功匹配路径：%s，Strm 类型：%s", strmFilePath, prefix, rule.strmFileType)
+				}
+				return rule.strmFileType, rule
+			}
+		}
+	}
+	logging.Debugf("%s 未匹配任何路径，Strm 类型：%s", strmFilePath, constants.UnknownStrm)
+	return constants.UnknownStrm, nil
+}
+
+// Strm 内容重写预览结果
+type strmRewriteResult struct {
+	Path        string                 `json:"Path"`        // Strm 文件路径
+	Target      string                 `json:"Target"`      // 原始 Strm 内容
+	Type        constants.StrmFileType `json:"Type"`        // 匹配到的 Strm 类型
+	AlistServer string                 `json:"AlistServer"` // AlistStrm 对应的 Alist 服务器地址
+	Steps       []string               `json:"Steps"`       // 依次应用每一条重写规则的结果
+	Result      string                 `json:"Result"`      // 最终结果
+}
+
+// 预览 Strm 内容重写结果
+//
+// GET /MediaWarp/api/rewrite?path=Strm 文件路径&target=Strm 文件内容&upstream=上游媒体服务器名称
+// 仅计算重写结果，不会请求 Alist 或重定向；未指定 upstream 时使用 MediaServer 的规则
+func StrmRewriteDryRunHandler(ctx *gin.Context) {
+	strmFilePath := ctx.Query("path")
+	target := ctx.Query("target")
+	if strmFilePath == "" || target == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "缺少 path 或 target 参数"})
+		return
+	}
+
+	result := strmRewriteResult{
+		Path:   strmFilePath,
+		Target: target,
+		Steps:  []string{target},
+		Result: target,
+	}
+	strmFileType, rule := recgonizeStrmFileType(ctx.Query("upstream"), strmFilePath)
+	result.Type = strmFileType
+	if rule != nil {
+		result.AlistServer = rule.alistAddr
+		result.Steps = rule.rewriteRules.Trace(target)
+		result.Result = result.Steps[len(result.Steps)-1]
+		if rule.strmFileType == constants.HTTPStrm { // HTTPStrm 还需应用 URL 处理规则
+			if finalURL := rule.httpStrmURL(target); finalURL != result.Result {
+				result.Steps = append(result.Steps, finalURL)
+				result.Result = finalURL
+			}
+		}
+	}
+	ctx.JSON(http.StatusOK, result)
+}
@@ -1,9 +1,10 @@
 package handler
 
 import (
-	"MediaWarp/constants"
-	"MediaWarp/internal/config"
+	"MediaWarp/internal/forwarded"
 	"MediaWarp/internal/logging"
+	"MediaWarp/internal/service/alist"
+	"MediaWarp/utils"
 	"bytes"
 	"compress/gzip"
 	"errors"
@@ -17,12 +18,36 @@ import (
 	"runtime/debug"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/andybalholm/brotli"
 	"github.com/gin-gonic/gin"
 )
 
+// 创建转发至媒体服务器的反向代理
+//
+// 按 Forwarded 设置处理转发请求头和 Host 请求头，通过媒体服务器地址池 transport 转发
+func newReverseProxy(target *url.URL, transport http.RoundTripper) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = transport
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		if forwarder := forwarded.Get(); forwarder != nil {
+			forwarder.Rewrite(req, target)
+		}
+	}
+	proxy.ModifyResponse = restoreLocationPrefix
+	return proxy
+}
+
+// 为上游响应的 Location 头加上匹配上游媒体服务器时去除的路径前缀
+func restoreLocationPrefix(resp *http.Response) error {
+	if location := resp.Header.Get("Location"); location != "" {
+		resp.Header.Set("Location", withPathPrefix(resp.Request, location))
+	}
+	return nil
+}
+
 // 响应修改创建器
 //
 // 将需要修改上游响应的处理器包装成一个 gin.HandlerFunc 处理器
@@ -37,6 +62,7 @@ func responseModifyCreater(proxy *httputil.ReverseProxy, modifyResponseFN func(r
 				logging.Errorf("%s 发生 panic：%s\n%s", funcName, r, string(debug.Stack()))
 			}
 		}()
+		restoreLocationPrefix(rw)
 		return modifyResponseFN(rw)
 	}
 
@@ -45,30 +71,44 @@ func responseModifyCreater(proxy *httputil.ReverseProxy, modifyResponseFN func(r
 	}
 }
 
-// 根据 Strm 文件路径识别 Strm 文件类型
+// 根据 Alist 错误类别得到响应客户端的状态码
 //
-// 返回 Strm 文件类型和一个可选配置
-func recgonizeStrmFileType(strmFilePath string) (constants.StrmFileType, any) {
-	if config.HTTPStrm.Enable {
-		for _, prefix := range config.HTTPStrm.PrefixList {
-			if strings.HasPrefix(strmFilePath, prefix) {
-				logging.Debugf("%s 成功匹配路径：%s，Strm 类型：%s", strmFilePath, prefix, constants.HTTPStrm)
-				return constants.HTTPStrm, nil
-			}
-		}
+// 文件不存在返回 404，Alist 不可用返回 503，认证失败等 MediaWarp 与 Alist 之间的问题返回 502
+func alistErrorStatusCode(err error) int {
+	switch {
+	case errors.Is(err, alist.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, alist.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
 	}
-	if config.AlistStrm.Enable {
-		for _, alistStrmConfig := range config.AlistStrm.List {
-			for _, prefix := range alistStrmConfig.PrefixList {
-				if strings.HasPrefix(strmFilePath, prefix) {
-					logging.Debugf("%s 成功匹配路径：%s，Strm 类型：%s，AlistServer 地址：%s", strmFilePath, prefix, constants.AlistStrm, alistStrmConfig.ADDR)
-					return constants.AlistStrm, alistStrmConfig.ADDR
-				}
-			}
-		}
+}
+
+// 生成 Alist 文件的下载地址
+//
+// 对路径逐段进行转义，受签名或元信息密码保护的文件需要附带 sign 参数
+func alistDownloadURL(alistServerAddr string, filePath string, fsGetData alist.FsGetData) string {
+	segments := strings.Split(filePath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	downloadURL := fmt.Sprintf("%s/d%s", utils.GetEndpoint(alistServerAddr), strings.Join(segments, "/"))
+	if fsGetData.Sign != "" {
+		downloadURL += "?sign=" + url.QueryEscape(fsGetData.Sign)
+	}
+	return downloadURL
+}
+
+// 获取 URL 中的主机名
+//
+// 用于指标中标识重定向的来源，解析失败时返回 unknown
+func urlHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "unknown"
 	}
-	logging.Debugf("%s 未匹配任何路径，Strm 类型：%s", strmFilePath, constants.UnknownStrm)
-	return constants.UnknownStrm, nil
+	return u.Host
 }
 
 // 读取响应体
@@ -154,103 +194,6 @@ func updateBody(rw *http.Response, content []byte) error {
 	return nil
 }
 
-const (
-	MaxRedirectAttempts = 10               // 最大重定向次数限制
-	RedirectTimeout     = 10 * time.Second // 最大超时时间
-
-)
-
-var (
-	ErrInvalidLocationHeader = errors.New("重定向 Location 头无效")
-	ErrMaxRedirectsExceeded  = fmt.Errorf("超过最大重定向次数限制（%d）", MaxRedirectAttempts)
-)
-
-// 获取URL的最终目标地址（自动跟踪重定向）
-func getFinalURL(rawURL string, ua string) (string, error) {
-	startTime := time.Now()
-	defer func() {
-		logging.Debugf("获取 %s 最终URL耗时：%s", rawURL, time.Since(startTime))
-	}()
-
-	// 先对原始URL进行编码处理
-	encodedURL, err := encodeURL(rawURL)
-	if err != nil {
-		return "", fmt.Errorf("URL编码失败: %w", err)
-	}
-
-	parsedURL, err := url.Parse(encodedURL) // 验证并解析输入URL
-	if err != nil {
-		return "", fmt.Errorf("非法 URL： %w", err)
-	}
-	if parsedURL.Scheme == "" {
-		return "", fmt.Errorf("URL 缺少协议头： %s", parsedURL)
-	}
-
-	// 创建自定义HTTP客户端配置
-	client := &http.Client{
-		Timeout: RedirectTimeout,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			// 禁止自动重定向，以便手动处理
-			return http.ErrUseLastResponse
-		},
-	}
-
-	currentURL := parsedURL.String()
-	visited := make(map[string]struct{}, MaxRedirectAttempts)
-	redirectChain := make([]string, 0, MaxRedirectAttempts+1)
-
-	// 跟踪重定向链
-	for i := 0; i <= MaxRedirectAttempts; i++ {
-		// 检测循环重定向
-		if _, exists := visited[currentURL]; exists {
-			return "", fmt.Errorf("检测到循环重定向，重定向链: %s", strings.Join(redirectChain, " -> "))
-		}
-		visited[currentURL] = struct{}{}
-		redirectChain = append(redirectChain, currentURL)
-
-		req, err := http.NewRequest(http.MethodHead, currentURL, nil) // 创建 HEAD 请求（更高效，只获取头部信息）
-		if err != nil {
-			return "", fmt.Errorf("创建请求失败: %w", err)
-		}
-		req.Header.Set("User-Agent", ua) // 设置 User-Agent 头部
-
-		resp, err := client.Do(req)
-		if err != nil {
-			return "", fmt.Errorf("发送 HTTP 请求失败：%w", err)
-		}
-		defer resp.Body.Close()
-
-		// 检查是否需要重定向 (3xx 状态码)
-		if resp.StatusCode >= http.StatusMultipleChoices && resp.StatusCode < http.StatusBadRequest {
-			location, err := resp.Location()
-			if err != nil {
-				return "", ErrInvalidLocationHeader
-			}
-
-			// 对重定向URL进行编码处理
-			encodedLocation, err := encodeURL(location.String())
-			if err != nil {
-				return "", fmt.Errorf("重定向URL编码失败: %w", err)
-			}
-			currentURL = encodedLocation
-
-			if strings.HasPrefix(currentURL, "/302/?pickcode=") {
-				fullURL := fmt.Sprintf("%s://%s%s", req.URL.Scheme, req.URL.Host, location)
-				logging.Debugf("拼接完整 URL：%s -> %s", currentURL, fullURL)
-				currentURL = fullURL
-			}
-
-			continue
-		}
-
-		// 返回最终的非重定向URL
-		logging.Debug("重定向链：", strings.Join(redirectChain, " -> "))
-		return resp.Request.URL.String(), nil
-	}
-
-	return "", ErrMaxRedirectsExceeded
-}
-
 // encodeURL 对URL进行编码处理，确保特殊字符被正确转义
 func encodeURL(rawURL string) (string, error) {
 	u, err := url.Parse(rawURL)
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/logging"
+	"MediaWarp/internal/metrics"
+	"MediaWarp/internal/wsproxy"
+	"MediaWarp/utils"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 统计的 WebSocket 消息类型，其余类型统计为 Other
+var webSocketMessageTypes = []string{
+	"KeepAlive", "ForceKeepAlive",
+	"SessionsStart", "SessionsStop", "Sessions",
+	"ScheduledTasksInfoStart", "ScheduledTasksInfoStop", "ScheduledTasksInfo", "ActivityLogEntryStart", "ActivityLogEntryStop", "ActivityLogEntry",
+	"Play", "Playstate", "GeneralCommand",
+	"UserDataChanged", "LibraryChanged", "RefreshProgress",
+}
+
+// WebSocket 消息
+//
+// Emby 和 Jellyfin 的 WebSocket 消息格式相同
+type webSocketMessage struct {
+	MessageType string
+	Data        json.RawMessage
+}
+
+// 创建 WebSocket 代理
+func newWebSocketProxy(target *url.URL, director func(*http.Request)) *wsproxy.Proxy {
+	proxy := &wsproxy.Proxy{
+		Target:      target,
+		Director:    director,
+		IdleTimeout: func() time.Duration { return config.Get().WebSocket.IdleTimeout },
+	}
+	proxy.OnMessage(inspectWebSocketMessage)
+	return proxy
+}
+
+// WebSocket 接口处理器
+//
+// 非 WebSocket 握手请求转发至上游服务器
+func webSocketHandler(proxy *wsproxy.Proxy, reverseProxy func(http.ResponseWriter, *http.Request)) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !wsproxy.IsWebSocketRequest(ctx.Request) {
+			reverseProxy(ctx.Writer, ctx.Request)
+			return
+		}
+		ctx.Status(http.StatusSwitchingProtocols) // 连接被接管后 gin 无法得知状态码，预先设置以便日志和指标记录；拒绝升级时会被实际状态码覆盖
+		proxy.ServeHTTP(ctx.Writer, ctx.Request)
+	}
+}
+
+// 检查 WebSocket 消息
+//
+// 按消息类型统计数量，记录媒体服务器发送给客户端的播放控制命令
+func inspectWebSocketMessage(conn *wsproxy.Conn, direction wsproxy.Direction, message []byte) {
+	var msg webSocketMessage
+	if err := json.Unmarshal(message, &msg); err != nil || msg.MessageType == "" {
+		return
+	}
+	messageType := msg.MessageType
+	if !slices.Contains(webSocketMessageTypes, messageType) {
+		messageType = "Other"
+	}
+	metrics.WebSocketMessages.Inc(string(direction), messageType)
+
+	if direction == wsproxy.ServerToClient && (msg.MessageType == "Play" || msg.MessageType == "Playstate") {
+		client := utils.GetClientInfo(conn.Request)
+		logging.Infof("媒体服务器向客户端 %s（%s，设备 ID：%s）发送了 %s 命令：%s", client.Client, client.Device, client.DeviceID, msg.MessageType, msg.Data)
+	}
+}
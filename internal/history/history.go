@@ -0,0 +1,374 @@
+package history
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/logging"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	recordQueueSize = 256       // 待写入记录队列长度
+	cleanupInterval = time.Hour // 清理过期记录的间隔
+)
+
+var (
+	playbackBucket = []byte("playback")
+
+	ErrDisabled = errors.New("播放历史未启用")
+)
+
+// 播放记录
+type Record struct {
+	ID            uint64                    `json:"Id"`
+	Time          time.Time                 `json:"Time"`
+	UserID        string                    `json:"UserId"`
+	Client        string                    `json:"Client"`
+	Device        string                    `json:"Device"`
+	DeviceID      string                    `json:"DeviceId"`
+	ItemID        string                    `json:"ItemId"`
+	MediaSourceID string                    `json:"MediaSourceId"`
+	Name          string                    `json:"Name"`
+	Path          string                    `json:"Path"`    // Strm 文件路径
+	Library       string                    `json:"Library"` // 所属媒体库
+	StrmType      constants.StrmFileType    `json:"StrmType"`
+	Source        string                    `json:"Source"`                // 重定向的目标地址
+	AlistServer   string                    `json:"AlistServer,omitempty"` // AlistStrm 使用的 Alist 服务器
+	Outcome       constants.PlaybackOutcome `json:"Outcome"`
+	Error         string                    `json:"Error,omitempty"`
+}
+
+// 播放记录查询条件
+//
+// 字符串条件为空、时间条件为零值时不进行过滤
+type Query struct {
+	UserID   string
+	ItemID   string
+	Library  string
+	DeviceID string
+	Outcome  constants.PlaybackOutcome
+	Since    time.Time
+	Until    time.Time
+	Offset   int
+	Limit    int
+}
+
+func (q *Query) match(record *Record) bool {
+	switch {
+	case q.UserID != "" && record.UserID != q.UserID,
+		q.ItemID != "" && record.ItemID != q.ItemID,
+		q.Library != "" && record.Library != q.Library,
+		q.DeviceID != "" && record.DeviceID != q.DeviceID,
+		q.Outcome != "" && record.Outcome != q.Outcome,
+		!q.Since.IsZero() && record.Time.Before(q.Since),
+		!q.Until.IsZero() && !record.Time.Before(q.Until):
+		return false
+	}
+	return true
+}
+
+// 播放统计
+type Stat struct {
+	Key        string    `json:"Key"`        // 分组键（用户 ID、媒体库名称等）
+	Plays      int       `json:"Plays"`      // 播放次数
+	Failures   int       `json:"Failures"`   // 失败次数
+	Items      int       `json:"Items"`      // 播放的不同条目数量
+	Users      int       `json:"Users"`      // 播放的不同用户数量
+	LastPlayed time.Time `json:"LastPlayed"` // 最近一次播放时间
+}
+
+// 播放历史存储
+//
+// 使用 bbolt 嵌入式数据库，记录按写入顺序以自增 ID 为键保存
+// 写入在后台进行，不阻塞播放请求
+type Store struct {
+	db        *bolt.DB
+	retention time.Duration
+	records   chan Record
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+var store *Store
+
+// 初始化播放历史
+func Init() error {
+	if !config.Get().History.Enable {
+		logging.Info("播放历史未启用")
+		return nil
+	}
+	var err error
+	store, err = Open(config.HistoryDBPath(), config.Get().History.Retention)
+	if err != nil {
+		return fmt.Errorf("打开播放历史数据库失败：%w", err)
+	}
+	logging.Info("播放历史已启用，数据库：", config.HistoryDBPath())
+	return nil
+}
+
+// 获取全局播放历史存储
+//
+// 未启用时返回 nil
+func GetStore() *Store {
+	return store
+}
+
+// 关闭全局播放历史存储
+func Close() error {
+	if store == nil {
+		return nil
+	}
+	return store.Close()
+}
+
+// 添加播放记录
+//
+// 未启用播放历史时忽略
+func Add(record Record) {
+	if store != nil {
+		store.Add(record)
+	}
+}
+
+// 打开播放历史数据库
+//
+// retention 为记录保留时间，0 表示永久保留
+func Open(path string, retention time.Duration) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(playbackBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &Store{
+		db:        db,
+		retention: retention,
+		records:   make(chan Record, recordQueueSize),
+		done:      make(chan struct{}),
+	}
+	s.cleanup()
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// 关闭数据库
+//
+// 等待队列中的记录写入完成
+func (s *Store) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	return s.db.Close()
+}
+
+// 添加播放记录
+//
+// 队列已满时丢弃记录
+func (s *Store) Add(record Record) {
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	select {
+	case s.records <- record:
+	default:
+		logging.Warning("播放历史写入队列已满，丢弃记录：", record.Name)
+	}
+}
+
+// 后台写入记录并定期清理过期记录
+func (s *Store) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case record := <-s.records:
+			if err := s.write(record); err != nil {
+				logging.Warning("写入播放历史失败：", err)
+			}
+		case <-ticker.C:
+			s.cleanup()
+		case <-s.done:
+			for {
+				select {
+				case record := <-s.records:
+					if err := s.write(record); err != nil {
+						logging.Warning("写入播放历史失败：", err)
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Store) write(record Record) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(playbackBucket)
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		record.ID = id
+		value, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(recordKey(id), value)
+	})
+}
+
+// 记录的键
+//
+// 使用大端序保证按 ID 顺序遍历
+func recordKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// 清理超过保留时间的记录
+func (s *Store) cleanup() {
+	if s.retention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-s.retention)
+	var removed int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(playbackBucket)
+		var expired [][]byte
+		cursor := bucket.Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() { // 记录按时间顺序写入，遇到未过期的记录即可停止
+			var record Record
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			if !record.Time.Before(cutoff) {
+				break
+			}
+			expired = append(expired, key)
+		}
+		for _, key := range expired { // 遍历时删除会导致游标跳过记录
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		removed = len(expired)
+		return nil
+	})
+	if err != nil {
+		logging.Warning("清理播放历史失败：", err)
+		return
+	}
+	if removed > 0 {
+		logging.Infof("已清理 %d 条过期的播放历史", removed)
+	}
+}
+
+// 遍历满足条件的记录
+//
+// 从新到旧遍历，fn 返回 false 时停止
+func (s *Store) each(q Query, fn func(record *Record) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(playbackBucket).Cursor()
+		for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+			var record Record
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			if !q.Since.IsZero() && record.Time.Before(q.Since) { // 更早的记录均不满足条件
+				break
+			}
+			if q.match(&record) && !fn(&record) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// 查询播放记录
+//
+// 按时间从新到旧返回，同时返回满足条件的记录总数
+func (s *Store) Query(q Query) ([]Record, int, error) {
+	var (
+		records = []Record{}
+		total   int
+	)
+	err := s.each(q, func(record *Record) bool {
+		if total >= q.Offset && (q.Limit <= 0 || len(records) < q.Limit) {
+			records = append(records, *record)
+		}
+		total++
+		return true
+	})
+	return records, total, err
+}
+
+// 按分组统计播放次数
+//
+// keyFn 返回记录所属的分组
+func (s *Store) Stats(q Query, keyFn func(record *Record) string) ([]Stat, error) {
+	type accumulator struct {
+		Stat
+		items map[string]struct{}
+		users map[string]struct{}
+	}
+	groups := make(map[string]*accumulator)
+	err := s.each(q, func(record *Record) bool {
+		key := keyFn(record)
+		group, ok := groups[key]
+		if !ok {
+			group = &accumulator{
+				Stat:  Stat{Key: key},
+				items: make(map[string]struct{}),
+				users: make(map[string]struct{}),
+			}
+			groups[key] = group
+		}
+		if record.Outcome == constants.PlaybackFailed {
+			group.Failures++
+		} else {
+			group.Plays++
+		}
+		group.items[record.ItemID] = struct{}{}
+		group.users[record.UserID] = struct{}{}
+		if record.Time.After(group.LastPlayed) {
+			group.LastPlayed = record.Time
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]Stat, 0, len(groups))
+	for _, group := range groups {
+		group.Items = len(group.items)
+		group.Users = len(group.users)
+		stats = append(stats, group.Stat)
+	}
+	slices.SortFunc(stats, func(a, b Stat) int { // 按播放次数从多到少排序
+		if a.Plays != b.Plays {
+			return b.Plays - a.Plays
+		}
+		return b.LastPlayed.Compare(a.LastPlayed)
+	})
+	return stats, nil
+}
@@ -0,0 +1,59 @@
+package history_test
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/history"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := history.Open(path, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, record := range []history.Record{
+		{Time: now.Add(-48 * time.Hour), UserID: "old", ItemID: "1", Outcome: constants.PlaybackRedirected}, // 超过保留时间
+		{Time: now.Add(-3 * time.Minute), UserID: "alice", ItemID: "1", Library: "电影", Outcome: constants.PlaybackRedirected},
+		{Time: now.Add(-2 * time.Minute), UserID: "alice", ItemID: "2", Library: "剧集", Outcome: constants.PlaybackFailed},
+		{Time: now.Add(-1 * time.Minute), UserID: "bob", ItemID: "1", Library: "电影", Outcome: constants.PlaybackRedirected},
+	} {
+		store.Add(record)
+	}
+	if err = store.Close(); err != nil { // 关闭时写入队列中的记录
+		t.Fatal(err)
+	}
+
+	store, err = history.Open(path, 24*time.Hour) // 重新打开时清理过期记录
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	records, total, err := store.Query(history.Query{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(records) != 2 || records[0].UserID != "bob" || records[1].ItemID != "2" {
+		t.Fatalf("查询结果错误：total=%d, records=%+v", total, records)
+	}
+
+	records, total, err = store.Query(history.Query{UserID: "alice", Outcome: constants.PlaybackFailed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || records[0].ItemID != "2" {
+		t.Fatalf("过滤结果错误：total=%d, records=%+v", total, records)
+	}
+
+	stats, err := store.Stats(history.Query{}, func(record *history.Record) string { return record.Library })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats[0].Key != "电影" || stats[0].Plays != 2 || stats[0].Users != 2 || stats[1].Failures != 1 {
+		t.Fatalf("统计结果错误：%+v", stats)
+	}
+}
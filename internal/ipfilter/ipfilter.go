@@ -0,0 +1,193 @@
+package ipfilter
+
+import (
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/logging"
+	"MediaWarp/utils"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+const closeDelay = time.Minute // 替换过滤器后延迟关闭旧的 IP 地理位置数据库，等待正在进行的查询完成
+
+var (
+	ErrIPDenied             = errors.New("IP 地址在禁止列表中")
+	ErrIPNotAllowed         = errors.New("IP 地址不在允许列表中")
+	ErrCountryDenied        = errors.New("IP 地址所属的国家或地区被禁止访问")
+	ErrGeoIPDatabaseMissing = errors.New("已设置国家或地区规则，但未设置 IPFilter.GeoIPDatabase")
+)
+
+// 访问控制规则
+type Rule struct {
+	allow          []netip.Prefix
+	deny           []netip.Prefix
+	allowCountries []string
+	denyCountries  []string
+}
+
+// 编译访问控制规则
+func NewRule(setting config.IPFilterRule) (Rule, error) {
+	var (
+		rule Rule
+		err  error
+	)
+	if rule.allow, err = utils.ParsePrefixes(setting.Allow); err != nil {
+		return rule, err
+	}
+	if rule.deny, err = utils.ParsePrefixes(setting.Deny); err != nil {
+		return rule, err
+	}
+	rule.allowCountries = upperAll(setting.AllowCountries)
+	rule.denyCountries = upperAll(setting.DenyCountries)
+	return rule, nil
+}
+
+// 规则是否包含国家或地区限制
+func (rule Rule) hasCountries() bool {
+	return len(rule.allowCountries) > 0 || len(rule.denyCountries) > 0
+}
+
+func upperAll(list []string) []string {
+	result := make([]string, len(list))
+	for i, item := range list {
+		result[i] = strings.ToUpper(strings.TrimSpace(item))
+	}
+	return result
+}
+
+// IP 访问控制过滤器
+type Filter struct {
+	web    Rule
+	stream Rule
+	geoIP  *maxminddb.Reader
+}
+
+// 创建 IP 访问控制过滤器
+//
+// dbPath 为 MaxMind 格式的 IP 地理位置数据库路径，为空时不支持国家或地区规则
+func New(setting config.IPFilterSetting, dbPath string) (*Filter, error) {
+	web, err := NewRule(setting.Web)
+	if err != nil {
+		return nil, fmt.Errorf("Web 规则：%w", err)
+	}
+	stream, err := NewRule(setting.Stream)
+	if err != nil {
+		return nil, fmt.Errorf("Stream 规则：%w", err)
+	}
+	filter := &Filter{web: web, stream: stream}
+	if dbPath == "" {
+		if web.hasCountries() || stream.hasCountries() {
+			return nil, ErrGeoIPDatabaseMissing
+		}
+		return filter, nil
+	}
+	if filter.geoIP, err = maxminddb.Open(dbPath); err != nil {
+		return nil, fmt.Errorf("打开 IP 地理位置数据库失败：%w", err)
+	}
+	return filter, nil
+}
+
+// 关闭 IP 地理位置数据库
+func (filter *Filter) Close() error {
+	if filter.geoIP == nil {
+		return nil
+	}
+	return filter.geoIP.Close()
+}
+
+// 查询 IP 地址所属的国家或地区代码
+//
+// 未加载数据库或未查询到（如局域网地址）时返回空字符串
+func (filter *Filter) Country(addr netip.Addr) string {
+	if filter.geoIP == nil {
+		return ""
+	}
+	var isoCode string
+	if err := filter.geoIP.Lookup(addr).DecodePath(&isoCode, "country", "iso_code"); err != nil {
+		logging.Debugf("查询 %s 所属的国家或地区失败：%s", addr, err)
+		return ""
+	}
+	return isoCode
+}
+
+// 检查 IP 地址是否允许访问
+//
+// stream 为 true 时使用视频流规则，否则使用 Web 规则
+// 依次检查禁止列表、允许列表、国家或地区，无法确定国家或地区的地址不受国家或地区规则限制
+func (filter *Filter) Check(addr netip.Addr, stream bool) error {
+	rule := filter.web
+	if stream {
+		rule = filter.stream
+	}
+	addr = addr.Unmap()
+	if utils.ContainsAddr(rule.deny, addr) {
+		return ErrIPDenied
+	}
+	if len(rule.allow) > 0 && !utils.ContainsAddr(rule.allow, addr) {
+		return ErrIPNotAllowed
+	}
+	if !rule.hasCountries() {
+		return nil
+	}
+	country := filter.Country(addr)
+	if country == "" {
+		return nil
+	}
+	if slices.Contains(rule.denyCountries, country) || (len(rule.allowCountries) > 0 && !slices.Contains(rule.allowCountries, country)) {
+		return fmt.Errorf("%w：%s", ErrCountryDenied, country)
+	}
+	return nil
+}
+
+var current atomic.Pointer[Filter] // 全局 IP 访问控制过滤器
+
+// 初始化全局 IP 访问控制过滤器
+func Init() error {
+	filter, err := Build(config.Get())
+	if err != nil {
+		return err
+	}
+	Set(filter)
+	return nil
+}
+
+// 按配置创建 IP 访问控制过滤器
+//
+// 未启用时返回 nil
+func Build(settings *config.Settings) (*Filter, error) {
+	if !settings.IPFilter.Enable {
+		return nil, nil
+	}
+	return New(settings.IPFilter, config.GeoIPDatabasePath(settings.IPFilter))
+}
+
+// 替换全局 IP 访问控制过滤器
+//
+// 重新加载配置时调用以更新规则，旧的过滤器延迟关闭
+func Set(filter *Filter) {
+	if old := current.Swap(filter); old != nil {
+		time.AfterFunc(closeDelay, func() { old.Close() })
+	}
+}
+
+// 获取全局 IP 访问控制过滤器
+//
+// 未启用时返回 nil
+func Get() *Filter {
+	return current.Load()
+}
+
+// 关闭全局 IP 访问控制过滤器
+func Close() error {
+	if old := current.Swap(nil); old != nil {
+		return old.Close()
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package ipfilter_test
+
+import (
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/ipfilter"
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestFilterCheck(t *testing.T) {
+	filter, err := ipfilter.New(config.IPFilterSetting{
+		Web: config.IPFilterRule{
+			Deny: []string{"192.168.1.100"},
+		},
+		Stream: config.IPFilterRule{
+			Allow: []string{"192.168.1.0/24", "2001:db8::/32"},
+			Deny:  []string{"192.168.1.128/25"},
+		},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer filter.Close()
+
+	tests := map[string]struct {
+		ip     string
+		stream bool
+		want   error
+	}{
+		"Web 允许":          {"203.0.113.1", false, nil},
+		"Web 禁止":          {"192.168.1.100", false, ipfilter.ErrIPDenied},
+		"视频流允许":           {"192.168.1.10", true, nil},
+		"视频流 IPv4 映射地址允许": {"::ffff:192.168.1.10", true, nil},
+		"视频流 IPv6 允许":     {"2001:db8::1", true, nil},
+		"视频流禁止列表优先于允许列表":  {"192.168.1.200", true, ipfilter.ErrIPDenied},
+		"视频流不在允许列表":       {"203.0.113.1", true, ipfilter.ErrIPNotAllowed},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := filter.Check(netip.MustParseAddr(tt.ip), tt.stream); !errors.Is(got, tt.want) {
+				t.Errorf("Check(%s) = %v，期望 %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewInvalidSetting(t *testing.T) {
+	if _, err := ipfilter.New(config.IPFilterSetting{Web: config.IPFilterRule{Allow: []string{"not-an-ip"}}}, ""); err == nil {
+		t.Error("错误的 IP 地址应返回错误")
+	}
+	if _, err := ipfilter.New(config.IPFilterSetting{Stream: config.IPFilterRule{AllowCountries: []string{"cn"}}}, ""); !errors.Is(err, ipfilter.ErrGeoIPDatabaseMissing) {
+		t.Errorf("未设置数据库时应返回 ErrGeoIPDatabaseMissing，实际为 %v", err)
+	}
+}
@@ -1,16 +1,73 @@
 package logging
 
 import (
-	"MediaWarp/internal/config"
+	"MediaWarp/constants"
 	"MediaWarp/utils"
 	"bytes"
 	"fmt"
-	"os"
+	"net/http"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-type accessLoggerSetting struct{}
+// gin 上下文中记录访问日志附加字段的键
+const (
+	UserContextKey       = "MediaWarp-User"       // 发起请求的用户
+	StrmTargetContextKey = "MediaWarp-StrmTarget" // Strm 重定向的目标地址
+)
+
+// 访问日志条目
+type AccessEntry struct {
+	StartTime  time.Time     // 请求开始时间
+	Method     string        // 请求方法
+	Path       string        // 请求路径（带查询参数）
+	Status     int           // 响应状态码
+	Latency    time.Duration // 处理耗时
+	ClientIP   string        // 客户端 IP
+	UserAgent  string        // 客户端 User-Agent
+	User       string        // 用户
+	StrmTarget string        // Strm 重定向的目标地址
+}
+
+// 结构化字段
+//
+// 用于 JSON 格式日志
+func (e *AccessEntry) fields() logrus.Fields {
+	fields := logrus.Fields{
+		"method":     e.Method,
+		"path":       e.Path,
+		"status":     e.Status,
+		"latency_ms": float64(e.Latency.Microseconds()) / 1000,
+		"client_ip":  e.ClientIP,
+		"user_agent": e.UserAgent,
+	}
+	if e.User != "" {
+		fields["user"] = e.User
+	}
+	if e.StrmTarget != "" {
+		fields["strm_target"] = e.StrmTarget
+	}
+	return fields
+}
+
+// 文本格式的访问日志
+func (e *AccessEntry) String() string {
+	statusColor, methodColor := getColor(e.Status, e.Method)
+	return fmt.Sprintf(
+		"【Access】 %s |\033[4%dm %d \033[0m| %-10s |\033[4%dm %-7s \033[0m| %s \"%s\"",
+		e.StartTime.Format(constants.FORMATE_TIME),
+		statusColor, e.Status,
+		e.Latency,
+		methodColor, e.Method,
+		e.ClientIP,
+		e.Path,
+	)
+}
+
+type accessLoggerSetting struct {
+	writer *rotateWriter // 访问日志文件
+}
 
 // 实现Format方法
 func (s *accessLoggerSetting) Format(entry *logrus.Entry) ([]byte, error) {
@@ -36,20 +93,46 @@ func (s *accessLoggerSetting) Levels() []logrus.Level {
 //
 // 将日志写入文件
 func (s *accessLoggerSetting) Fire(entry *logrus.Entry) error {
-	if err := os.MkdirAll(config.LogDirWithDate(), os.ModePerm); err != nil {
-		return err
-	}
-
-	accessLogFile, err := os.OpenFile(config.AccessLogPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	line, err := entry.String()
 	if err != nil {
 		return err
 	}
-	defer accessLogFile.Close()
+	_, err = s.writer.Write([]byte(utils.RemoveColorCodes(line)))
+	return err
+}
 
-	line, err := entry.String()
-	if err != nil {
-		return err
+// 根据Http状态码和Http请求方法获取颜色
+func getColor(statusCode int, method string) (uint8, uint8) {
+	var statusColor, methodColor uint8
+	switch {
+	case statusCode >= http.StatusOK && statusCode < http.StatusMultipleChoices:
+		statusColor = constants.StatusCode200Color
+	case statusCode >= http.StatusMultipleChoices && statusCode < http.StatusBadRequest:
+		statusColor = constants.StatusCode300Color
+	case statusCode >= http.StatusBadRequest && statusCode < http.StatusInternalServerError:
+		statusColor = constants.StatusCode400Color
+	case statusCode >= http.StatusInternalServerError:
+		statusColor = constants.StatusCode500Color
+	default:
+		statusColor = constants.ColorBlack
+	}
+	switch method {
+	case http.MethodGet:
+		methodColor = constants.MethodGetColor
+	case http.MethodPost:
+		methodColor = constants.MethodPostColor
+	case http.MethodPut:
+		methodColor = constants.MethodPutColor
+	case http.MethodPatch:
+		methodColor = constants.MethodPatchColor
+	case http.MethodDelete:
+		methodColor = constants.MethodDeleteColor
+	case http.MethodHead:
+		methodColor = constants.MethodHeadColor
+	case http.MethodOptions:
+		methodColor = constants.MethodOptionsColor
+	default:
+		methodColor = constants.ColorBlack
 	}
-	accessLogFile.WriteString(utils.RemoveColorCodes(line))
-	return nil
+	return statusColor, methodColor
 }
@@ -0,0 +1,12 @@
+package logging
+
+import (
+	"MediaWarp/internal/config"
+	"io"
+)
+
+const BackupTimeFormat = backupTimeFormat
+
+func NewRotateWriter(path string, setting config.LogRotateSetting) io.WriteCloser {
+	return newRotateWriter(path, setting)
+}
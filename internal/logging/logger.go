@@ -1,8 +1,12 @@
 package logging
 
 import (
+	"MediaWarp/constants"
 	"MediaWarp/internal/config"
+	"errors"
+	"fmt"
 	"io"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -10,43 +14,82 @@ import (
 var (
 	accessLogger  = logrus.New() // 访问日志
 	serviceLogger = logrus.New() // 服务日志
+	jsonFormat    bool           // 是否使用 JSON 格式输出日志
+	logFiles      []io.Closer    // 已打开的日志文件
 )
 
-func Init() {
+func Init() error {
 	var (
 		aLS = &accessLoggerSetting{}  // 访问日志logrus相关设置
 		sLS = &serviceLoggerSetting{} // 服务日志logrus相关设置
 	)
 
+	level, err := logrus.ParseLevel(config.Get().Logger.Level)
+	if err != nil {
+		return fmt.Errorf("日志级别 %s 无效: %w", config.Get().Logger.Level, err)
+	}
+	serviceLogger.SetLevel(level)
 	serviceLogger.SetReportCaller(false) // 关闭报告调用方
+	serviceLogger.AddHook(recentHook{})  // 保留最近的警告和错误，供管理后台查看
 
 	// 设置样式
-	accessLogger.SetFormatter(aLS)
-	serviceLogger.SetFormatter(sLS)
+	switch config.Get().Logger.Format {
+	case constants.LogFormatText:
+		accessLogger.SetFormatter(aLS)
+		serviceLogger.SetFormatter(sLS)
+	case constants.LogFormatJSON:
+		jsonFormat = true
+		jsonFormatter := &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano}
+		accessLogger.SetFormatter(jsonFormatter)
+		serviceLogger.SetFormatter(jsonFormatter)
+	default:
+		return fmt.Errorf("不支持的日志格式：%s", config.Get().Logger.Format)
+	}
 
-	if !config.Logger.AccessLogger.Console { // 访问日志不输出到终端
+	if !config.Get().Logger.AccessLogger.Console { // 访问日志不输出到终端
 		accessLogger.Out = io.Discard
 	}
 
-	if !config.Logger.ServiceLogger.Console { // 服务日志不输出到终端
+	if !config.Get().Logger.ServiceLogger.Console { // 服务日志不输出到终端
 		serviceLogger.Out = io.Discard
 	}
 
-	if config.Logger.AccessLogger.File {
+	if config.Get().Logger.AccessLogger.File {
+		aLS.writer = newRotateWriter(config.AccessLogPath(), config.Get().Logger.Rotate)
+		logFiles = append(logFiles, aLS.writer)
 		accessLogger.AddHook(aLS)
 	}
 
-	if config.Logger.ServiceLogger.File {
+	if config.Get().Logger.ServiceLogger.File {
+		sLS.writer = newRotateWriter(config.ServiceLogPath(), config.Get().Logger.Rotate)
+		logFiles = append(logFiles, sLS.writer)
 		serviceLogger.AddHook(sLS)
 	}
+	return nil
+}
 
+// 关闭日志文件
+func Close() error {
+	var errs []error
+	for _, file := range logFiles {
+		errs = append(errs, file.Close())
+	}
+	return errors.Join(errs...)
 }
 
 // 访问日志
 //
 // 默认日志级别为 Info
-func AccessLog(format string, args ...any) {
-	accessLogger.Infof(format, args...)
+// JSON 格式下输出结构化字段，文本格式下输出单行文本
+func AccessLog(entry AccessEntry) {
+	if entry.StrmTarget != "" {
+		recordRedirect(entry)
+	}
+	if jsonFormat {
+		accessLogger.WithTime(entry.StartTime).WithFields(entry.fields()).Info("access")
+		return
+	}
+	accessLogger.WithTime(entry.StartTime).Info(entry.String())
 }
 
 // 服务日志
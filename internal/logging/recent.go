@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const recentCapacity = 100 // 保留的最近日志条数
+
+// 最近的服务日志
+type RecentLog struct {
+	Time    time.Time `json:"Time"`
+	Level   string    `json:"Level"`
+	Message string    `json:"Message"`
+}
+
+// 最近的 Strm 重定向
+type RecentRedirect struct {
+	Time     time.Time `json:"Time"`
+	Status   int       `json:"Status"`
+	ClientIP string    `json:"ClientIP"`
+	User     string    `json:"User"`
+	Path     string    `json:"Path"` // 请求路径（不含查询参数，避免泄露 api_key）
+	Target   string    `json:"Target"`
+}
+
+// 固定容量的环形缓冲区
+//
+// 写满后覆盖最旧的条目
+type ring[T any] struct {
+	mutex   sync.Mutex
+	entries []T
+	next    int
+}
+
+func (r *ring[T]) push(entry T) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.entries) < recentCapacity {
+		r.entries = append(r.entries, entry)
+		return
+	}
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % recentCapacity
+}
+
+// 按时间从新到旧返回所有条目
+func (r *ring[T]) list() []T {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	entries := make([]T, 0, len(r.entries))
+	for i := range len(r.entries) {
+		entries = append(entries, r.entries[(r.next+len(r.entries)-1-i)%len(r.entries)])
+	}
+	return entries
+}
+
+var (
+	recentErrors    ring[RecentLog]
+	recentRedirects ring[RecentRedirect]
+)
+
+// 记录 Warning、Error 级别服务日志的 HOOK
+type recentHook struct{}
+
+func (recentHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.ErrorLevel, logrus.WarnLevel}
+}
+
+func (recentHook) Fire(entry *logrus.Entry) error {
+	recentErrors.push(RecentLog{
+		Time:    entry.Time,
+		Level:   strings.ToUpper(entry.Level.String()),
+		Message: entry.Message,
+	})
+	return nil
+}
+
+// 记录 Strm 重定向
+func recordRedirect(entry AccessEntry) {
+	path, _, _ := strings.Cut(entry.Path, "?")
+	recentRedirects.push(RecentRedirect{
+		Time:     entry.StartTime,
+		Status:   entry.Status,
+		ClientIP: entry.ClientIP,
+		User:     entry.User,
+		Path:     path,
+		Target:   entry.StrmTarget,
+	})
+}
+
+// 获取最近的 Warning、Error 级别服务日志
+//
+// 按时间从新到旧排序
+func RecentErrors() []RecentLog {
+	return recentErrors.list()
+}
+
+// 获取最近的 Strm 重定向
+//
+// 按时间从新到旧排序
+func RecentRedirects() []RecentRedirect {
+	return recentRedirects.list()
+}
@@ -0,0 +1,262 @@
+package logging
+
+import (
+	"MediaWarp/internal/config"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "2006-01-02T15-04-05.000" // 历史日志文件名中的时间格式
+
+// 可切割的日志文件
+//
+// 保持文件句柄打开，按大小或时间周期切割
+// 首次打开和每次切割后压缩历史文件，并清理超出保留数量或保留时间的历史文件
+type rotateWriter struct {
+	path    string
+	setting config.LogRotateSetting
+
+	mutex    sync.Mutex
+	file     *os.File
+	size     int64     // 当前文件大小
+	openTime time.Time // 当前文件所属周期内的时间，用于判断是否需要按时间切割
+
+	millMutex sync.Mutex     // 保证同一时间只有一个压缩、清理任务
+	millOnce  sync.Once      // 首次打开时清理上次运行遗留的历史文件
+	millGroup sync.WaitGroup // 正在后台进行的压缩、清理任务
+}
+
+func newRotateWriter(path string, setting config.LogRotateSetting) *rotateWriter {
+	return &rotateWriter{path: path, setting: setting}
+}
+
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+		w.millOnce.Do(w.startMill)
+	}
+	if w.shouldRotate(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// 关闭当前文件
+//
+// 等待后台的压缩、清理任务完成
+func (w *rotateWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	defer w.millGroup.Wait()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// 打开日志文件
+//
+// 文件已存在时以其修改时间作为所属周期，重启后仍能正确按时间切割
+func (w *rotateWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.size = info.Size()
+	w.openTime = time.Now()
+	if info.Size() > 0 {
+		w.openTime = info.ModTime()
+	}
+	return nil
+}
+
+// 判断写入 n 字节前是否需要切割
+func (w *rotateWriter) shouldRotate(n int64) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.setting.MaxSize > 0 && w.size+n > int64(w.setting.MaxSize)*1024*1024 {
+		return true
+	}
+	if w.setting.Interval > 0 && !periodStart(w.openTime, w.setting.Interval).Equal(periodStart(time.Now(), w.setting.Interval)) {
+		return true
+	}
+	return false
+}
+
+// 切割日志文件
+//
+// 将当前文件重命名为带时间戳的历史文件，并重新打开一个新文件
+func (w *rotateWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+
+	if err := os.Rename(w.path, w.backupPath(time.Now())); err != nil {
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.startMill()
+	return nil
+}
+
+// 历史日志文件路径
+//
+// logs/access.log -> logs/access-2006-01-02T15-04-05.000.log
+func (w *rotateWriter) backupPath(t time.Time) string {
+	ext := filepath.Ext(w.path)
+	return strings.TrimSuffix(w.path, ext) + "-" + t.Format(backupTimeFormat) + ext
+}
+
+// 在后台压缩并清理历史日志文件
+func (w *rotateWriter) startMill() {
+	w.millGroup.Add(1)
+	go func() {
+		defer w.millGroup.Done()
+		w.mill()
+	}()
+}
+
+// 压缩并清理历史日志文件
+func (w *rotateWriter) mill() {
+	w.millMutex.Lock()
+	defer w.millMutex.Unlock()
+
+	backups, err := w.backups()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "获取历史日志文件失败：", err)
+		return
+	}
+
+	var expired []string
+	if w.setting.MaxBackups > 0 && len(backups) > w.setting.MaxBackups {
+		expired = backups[:len(backups)-w.setting.MaxBackups]
+		backups = backups[len(backups)-w.setting.MaxBackups:]
+	}
+	if w.setting.MaxAge > 0 {
+		cutoff := time.Now().Add(-w.setting.MaxAge)
+		backups = slices.DeleteFunc(backups, func(backup string) bool {
+			info, err := os.Stat(backup)
+			if err == nil && info.ModTime().Before(cutoff) {
+				expired = append(expired, backup)
+				return true
+			}
+			return false
+		})
+	}
+
+	for _, backup := range expired {
+		if err := os.Remove(backup); err != nil {
+			fmt.Fprintln(os.Stderr, "删除历史日志文件失败：", err)
+		}
+	}
+	if w.setting.Compress {
+		for _, backup := range backups {
+			if strings.HasSuffix(backup, ".gz") {
+				continue
+			}
+			if err := compressFile(backup); err != nil {
+				fmt.Fprintln(os.Stderr, "压缩历史日志文件失败：", err)
+			}
+		}
+	}
+}
+
+// 获取所有历史日志文件
+//
+// 按时间从旧到新排序
+func (w *rotateWriter) backups() ([]string, error) {
+	ext := filepath.Ext(w.path)
+	prefix := strings.TrimSuffix(filepath.Base(w.path), ext) + "-"
+	entries, err := os.ReadDir(filepath.Dir(w.path))
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		timestamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz"), ext)
+		if _, err := time.ParseInLocation(backupTimeFormat, timestamp, time.Local); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(filepath.Dir(w.path), name))
+	}
+	slices.SortFunc(backups, func(a, b string) int { // 时间戳格式按字典序即为时间顺序
+		return strings.Compare(strings.TrimSuffix(a, ".gz"), strings.TrimSuffix(b, ".gz"))
+	})
+	return backups, nil
+}
+
+// 使用 gzip 压缩文件
+//
+// 压缩完成后删除原文件
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	gw := gzip.NewWriter(dst)
+	if _, err = io.Copy(gw, src); err == nil {
+		err = gw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
+
+// 得到 t 所属切割周期的起始时间
+//
+// 周期按本地时间对齐，如 24h 的周期从每天零点开始
+func periodStart(t time.Time, interval time.Duration) time.Time {
+	_, offset := t.Zone()
+	zone := time.Duration(offset) * time.Second
+	return t.Add(zone).Truncate(interval).Add(-zone)
+}
@@ -0,0 +1,213 @@
+package logging_test
+
+import (
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/logging"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 历史日志文件路径
+func backupPath(dir string, t time.Time) string {
+	return filepath.Join(dir, "access-"+t.Format(logging.BackupTimeFormat)+".log")
+}
+
+// 创建修改时间为 modTime 的文件
+func createFile(t *testing.T, path string, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 日志文件夹中除当前日志文件外的文件名
+func listBackups(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Name() != "access.log" {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func write(t *testing.T, w io.Writer, content string) {
+	t.Helper()
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	w := logging.NewRotateWriter(path, config.LogRotateSetting{MaxSize: 1})
+	first := strings.Repeat("a", 700*1024)
+	second := strings.Repeat("b", 700*1024)
+	write(t, w, first)
+	write(t, w, second)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups := listBackups(t, dir)
+	if len(backups) != 1 {
+		t.Fatalf("历史日志文件为 %v，期望 1 个", backups)
+	}
+	if readFile(t, filepath.Join(dir, backups[0])) != first {
+		t.Error("历史日志文件应为切割前写入的内容")
+	}
+	if readFile(t, path) != second {
+		t.Error("当前日志文件应只包含切割后写入的内容")
+	}
+}
+
+func TestRotateByInterval(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	createFile(t, path, "yesterday\n", time.Now().Add(-48*time.Hour))
+
+	w := logging.NewRotateWriter(path, config.LogRotateSetting{Interval: 24 * time.Hour})
+	write(t, w, "today\n")
+	write(t, w, "today\n")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups := listBackups(t, dir)
+	if len(backups) != 1 {
+		t.Fatalf("历史日志文件为 %v，期望 1 个", backups)
+	}
+	if got := readFile(t, filepath.Join(dir, backups[0])); got != "yesterday\n" {
+		t.Errorf("历史日志文件内容为 %q，期望上一周期的内容", got)
+	}
+	if got := readFile(t, path); got != "today\ntoday\n" {
+		t.Errorf("当前日志文件内容为 %q，同一周期内不应再次切割", got)
+	}
+}
+
+// 打开时即清理上次运行遗留的历史文件
+func TestMillOnOpen(t *testing.T) {
+	now := time.Now()
+
+	t.Run("保留数量", func(t *testing.T) {
+		dir := t.TempDir()
+		var want []string
+		for i := range 5 {
+			backup := backupPath(dir, now.Add(time.Duration(i-5)*time.Hour))
+			createFile(t, backup, "old\n", now)
+			if i >= 3 {
+				want = append(want, filepath.Base(backup))
+			}
+		}
+
+		w := logging.NewRotateWriter(filepath.Join(dir, "access.log"), config.LogRotateSetting{MaxBackups: 2})
+		write(t, w, "new\n")
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := listBackups(t, dir); !slices.Equal(got, want) {
+			t.Errorf("历史日志文件为 %v，期望保留最新的 %v", got, want)
+		}
+	})
+
+	t.Run("保留时间", func(t *testing.T) {
+		dir := t.TempDir()
+		expired := backupPath(dir, now.Add(-72*time.Hour))
+		createFile(t, expired, "old\n", now.Add(-72*time.Hour))
+		recent := backupPath(dir, now.Add(-time.Hour))
+		createFile(t, recent, "recent\n", now.Add(-time.Hour))
+		other := filepath.Join(dir, "access-notes.log") // 不是历史日志文件
+		createFile(t, other, "notes\n", now.Add(-72*time.Hour))
+
+		w := logging.NewRotateWriter(filepath.Join(dir, "access.log"), config.LogRotateSetting{MaxAge: 24 * time.Hour})
+		write(t, w, "new\n")
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{filepath.Base(recent), filepath.Base(other)}
+		if got := listBackups(t, dir); !slices.Equal(got, want) {
+			t.Errorf("剩余文件为 %v，期望 %v", got, want)
+		}
+	})
+
+	t.Run("压缩", func(t *testing.T) {
+		dir := t.TempDir()
+		backup := backupPath(dir, now.Add(-time.Hour))
+		createFile(t, backup, "old\n", now)
+
+		w := logging.NewRotateWriter(filepath.Join(dir, "access.log"), config.LogRotateSetting{Compress: true})
+		write(t, w, "new\n")
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := listBackups(t, dir); !slices.Equal(got, []string{filepath.Base(backup) + ".gz"}) {
+			t.Fatalf("历史日志文件为 %v，期望只有压缩后的文件", got)
+		}
+
+		file, err := os.Open(backup + ".gz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, err := io.ReadAll(reader); err != nil || string(data) != "old\n" {
+			t.Errorf("解压后的内容为 %q（%v），期望 %q", data, err, "old\n")
+		}
+	})
+}
+
+func TestRotateCompress(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	w := logging.NewRotateWriter(path, config.LogRotateSetting{MaxSize: 1, MaxBackups: 1, Compress: true})
+	for _, c := range "abc" {
+		write(t, w, strings.Repeat(string(c), 700*1024))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups := listBackups(t, dir)
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], ".gz") {
+		t.Fatalf("历史日志文件为 %v，期望只保留 1 个压缩后的文件", backups)
+	}
+	file, err := os.Open(filepath.Join(dir, backups[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(reader); string(data) != strings.Repeat("b", 700*1024) {
+		t.Error("应保留最新的历史日志文件")
+	}
+}
@@ -2,17 +2,17 @@ package logging
 
 import (
 	"MediaWarp/constants"
-	"MediaWarp/internal/config"
 	"MediaWarp/utils"
 	"bytes"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
-type serviceLoggerSetting struct{}
+type serviceLoggerSetting struct {
+	writer *rotateWriter // 服务日志文件
+}
 
 func (s *serviceLoggerSetting) Format(entry *logrus.Entry) ([]byte, error) {
 	// 根据日志级别设置颜色
@@ -59,20 +59,10 @@ func (s *serviceLoggerSetting) Levels() []logrus.Level {
 //
 // 将日志写入文件
 func (s *serviceLoggerSetting) Fire(entry *logrus.Entry) error {
-	if err := os.MkdirAll(config.LogDirWithDate(), os.ModePerm); err != nil {
-		return err
-	}
-
-	serviceLogFile, err := os.OpenFile(config.ServiceLogPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		return err
-	}
-	defer serviceLogFile.Close()
-
 	line, err := entry.String()
 	if err != nil {
 		return err
 	}
-	serviceLogFile.WriteString(utils.RemoveColorCodes(line))
-	return nil
+	_, err = s.writer.Write([]byte(utils.RemoveColorCodes(line)))
+	return err
 }
@@ -0,0 +1,160 @@
+package metrics
+
+import "slices"
+
+// gin 上下文中记录匹配路由名称的键
+const RouteContextKey = "MediaWarp-Route"
+
+var (
+	// 请求数量（按匹配的路由规则）
+	HTTPRequests = NewCounterVec(
+		"mediawarp_http_requests_total",
+		"按路由规则统计的请求数量",
+		"route", "method", "code",
+	)
+
+	// 请求耗时（按匹配的路由规则）
+	HTTPRequestDuration = NewHistogramVec(
+		"mediawarp_http_request_duration_seconds",
+		"按路由规则统计的请求耗时（秒）",
+		DefaultBuckets,
+		"route",
+	)
+
+	// Strm 重定向次数
+	StrmRedirects = NewCounterVec(
+		"mediawarp_strm_redirects_total",
+		"按 Strm 类型和来源统计的重定向次数",
+		"type", "source",
+	)
+
+	// Alist FsGet 耗时
+	AlistFsGetDuration = NewHistogramVec(
+		"mediawarp_alist_fsget_duration_seconds",
+		"按 Alist 服务器统计的 FsGet 请求耗时（秒）",
+		DefaultBuckets,
+		"server",
+	)
+
+	// Alist FsGet 错误次数
+	AlistFsGetErrors = NewCounterVec(
+		"mediawarp_alist_fsget_errors_total",
+		"按 Alist 服务器和错误原因统计的 FsGet 失败次数",
+		"server", "reason",
+	)
+
+	// 上游媒体服务器 API 耗时
+	UpstreamRequestDuration = NewHistogramVec(
+		"mediawarp_upstream_request_duration_seconds",
+		"按 API 统计的上游媒体服务器请求耗时（秒）",
+		DefaultBuckets,
+		"server", "api",
+	)
+
+	// 缓存查询次数
+	CacheRequests = NewCounterVec(
+		"mediawarp_cache_requests_total",
+		"按缓存名称统计的查询次数",
+		"cache",
+	)
+
+	// 缓存命中次数
+	CacheHits = NewCounterVec(
+		"mediawarp_cache_hits_total",
+		"按缓存名称统计的命中次数",
+		"cache",
+	)
+
+	// 缓存命中率
+	CacheHitRatio = NewGaugeFunc(
+		"mediawarp_cache_hit_ratio",
+		"按缓存名称统计的命中率",
+		cacheHitRatio,
+		"cache",
+	)
+
+	// 客户端过滤器拦截次数
+	ClientFilterBlocks = NewCounterVec(
+		"mediawarp_client_filter_blocks_total",
+		"按拦截原因（Rule、WhiteList、BlackList、Unknown）统计的客户端过滤器拦截次数",
+		"mode",
+	)
+
+	// IP 访问控制拦截次数
+	IPFilterBlocks = NewCounterVec(
+		"mediawarp_ip_filter_blocks_total",
+		"按请求类型（web、stream）统计的 IP 访问控制拦截次数",
+		"route",
+	)
+
+	// 已建立的 WebSocket 连接数量
+	WebSocketOpened = NewCounterVec(
+		"mediawarp_websocket_connections_opened_total",
+		"已建立的 WebSocket 连接数量",
+	)
+
+	// 已关闭的 WebSocket 连接数量
+	WebSocketClosed = NewCounterVec(
+		"mediawarp_websocket_connections_closed_total",
+		"按关闭原因（closed、idle_timeout、shutdown）统计的已关闭 WebSocket 连接数量",
+		"reason",
+	)
+
+	// 正在代理的 WebSocket 连接数量
+	WebSocketActive = NewGaugeFunc(
+		"mediawarp_websocket_connections",
+		"正在代理的 WebSocket 连接数量",
+		webSocketActive,
+	)
+
+	// WebSocket 消息数量
+	WebSocketMessages = NewCounterVec(
+		"mediawarp_websocket_messages_total",
+		"按方向（client、server）和消息类型统计的 WebSocket 文本消息数量",
+		"direction", "type",
+	)
+
+	// 媒体服务器地址请求失败次数
+	MediaServerFailovers = NewCounterVec(
+		"mediawarp_media_server_failovers_total",
+		"按地址统计的媒体服务器请求失败（切换至其他地址）次数",
+		"addr",
+	)
+
+	// 请求频率限制拦截次数
+	RateLimitBlocks = NewCounterVec(
+		"mediawarp_rate_limit_blocks_total",
+		"按拦截原因（global、ip、auth_ip、auth_username、banned）统计的请求频率限制拦截次数",
+		"reason",
+	)
+)
+
+// 根据已建立和已关闭的连接数量计算正在代理的 WebSocket 连接数量
+func webSocketActive() []LabeledValue {
+	var opened, closed float64
+	for _, v := range WebSocketOpened.snapshot() {
+		opened += v.value
+	}
+	for _, v := range WebSocketClosed.snapshot() {
+		closed += v.value
+	}
+	return []LabeledValue{{LabelValues: []string{}, Value: opened - closed}}
+}
+
+// 根据缓存查询次数和命中次数计算命中率
+func cacheHitRatio() []LabeledValue {
+	requests := CacheRequests.snapshot()
+	hits := CacheHits.snapshot()
+	result := make([]LabeledValue, 0, len(requests))
+	for _, key := range sortedKeys(requests) {
+		total := requests[key]
+		if total.value == 0 {
+			continue
+		}
+		result = append(result, LabeledValue{
+			LabelValues: slices.Clone(total.labelValues),
+			Value:       hits[key].value / total.value,
+		})
+	}
+	return result
+}
@@ -0,0 +1,302 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 指标收集器
+//
+// 以 Prometheus 文本格式输出指标
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMutex sync.Mutex
+	registry      []collector
+)
+
+// 注册指标收集器
+func register(c collector) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry = append(registry, c)
+}
+
+// 以 Prometheus 文本格式输出所有指标
+func WritePrometheus(w io.Writer) {
+	registryMutex.Lock()
+	collectors := slices.Clone(registry)
+	registryMutex.Unlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// 指标 HTTP 处理器
+//
+// Content-Type 为 Prometheus 文本格式 0.0.4
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WritePrometheus(w)
+	})
+}
+
+// 指标元信息
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d *desc) writeHeader(w io.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, metricType)
+}
+
+// 将标签值拼接为 map 的键
+func (d *desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("指标 %s 需要 %d 个标签值，实际为 %d 个", d.name, len(d.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// 格式化标签
+//
+// extra 为额外的标签（如直方图的 le），格式为 name="value"
+func formatLabels(names []string, values []string, extra string) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(values[i]))
+		b.WriteByte('"')
+	}
+	if extra != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extra)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// 按标签值排序输出，保证每次输出顺序一致
+func sortedKeys[T any](values map[string]T) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// ==========Counter==========
+
+// 带标签的计数器
+type CounterVec struct {
+	desc
+	mutex  sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labelValues []string
+	value       float64
+}
+
+// 创建并注册计数器
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{name: name, help: help, labels: labels},
+		values: make(map[string]*counterValue),
+	}
+	register(c)
+	return c
+}
+
+// 计数加一
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// 计数增加 value
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	v, ok := c.values[key]
+	if !ok {
+		v = &counterValue{labelValues: slices.Clone(labelValues)}
+		c.values[key] = v
+	}
+	v.value += value
+}
+
+// 获取当前所有标签组合的计数
+//
+// 键为使用 \xff 拼接的标签值
+func (c *CounterVec) snapshot() map[string]counterValue {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	result := make(map[string]counterValue, len(c.values))
+	for key, v := range c.values {
+		result[key] = *v
+	}
+	return result
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	values := c.snapshot()
+	c.writeHeader(w, "counter")
+	for _, key := range sortedKeys(values) {
+		v := values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, v.labelValues, ""), formatFloat(v.value))
+	}
+}
+
+// ==========Histogram==========
+
+// 默认的耗时直方图桶（秒）
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// 带标签的直方图
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mutex   sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labelValues []string
+	counts      []uint64 // 每个桶的计数（非累计）
+	count       uint64
+	sum         float64
+}
+
+// 创建并注册直方图
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, labels: labels},
+		buckets: slices.Sorted(slices.Values(buckets)),
+		values:  make(map[string]*histogramValue),
+	}
+	register(h)
+	return h
+}
+
+// 记录一个观测值
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogramValue{labelValues: slices.Clone(labelValues), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = v
+	}
+	if index, _ := slices.BinarySearch(h.buckets, value); index < len(h.buckets) {
+		v.counts[index]++
+	}
+	v.count++
+	v.sum += value
+}
+
+// 记录从 startTime 开始到现在的耗时（秒）
+func (h *HistogramVec) ObserveSince(startTime time.Time, labelValues ...string) {
+	h.Observe(time.Since(startTime).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mutex.Lock()
+	values := make(map[string]histogramValue, len(h.values))
+	for key, v := range h.values {
+		values[key] = histogramValue{labelValues: v.labelValues, counts: slices.Clone(v.counts), count: v.count, sum: v.sum}
+	}
+	h.mutex.Unlock()
+
+	h.writeHeader(w, "histogram")
+	for _, key := range sortedKeys(values) {
+		v := values[key]
+		var cumulative uint64
+		for i, upperBound := range h.buckets {
+			cumulative += v.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, v.labelValues, `le="`+formatFloat(upperBound)+`"`), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, v.labelValues, `le="+Inf"`), v.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, v.labelValues, ""), formatFloat(v.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, v.labelValues, ""), v.count)
+	}
+}
+
+// ==========Gauge==========
+
+// 带标签的采样值
+type LabeledValue struct {
+	LabelValues []string
+	Value       float64
+}
+
+// 在输出时通过函数计算的仪表盘指标
+type GaugeFunc struct {
+	desc
+	fn func() []LabeledValue
+}
+
+// 创建并注册仪表盘指标
+//
+// fn 在每次输出指标时调用
+func NewGaugeFunc(name string, help string, fn func() []LabeledValue, labels ...string) *GaugeFunc {
+	g := &GaugeFunc{
+		desc: desc{name: name, help: help, labels: labels},
+		fn:   fn,
+	}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	values := g.fn()
+	g.writeHeader(w, "gauge")
+	for _, v := range values {
+		g.key(v.LabelValues) // 校验标签数量
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, v.LabelValues, ""), formatFloat(v.Value))
+	}
+}
@@ -0,0 +1,46 @@
+package metrics_test
+
+import (
+	"MediaWarp/internal/metrics"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	counter := metrics.NewCounterVec("test_requests_total", "测试计数器", "route")
+	counter.Inc(`Videos"Handler`)
+	counter.Add(2, `Videos"Handler`)
+
+	histogram := metrics.NewHistogramVec("test_duration_seconds", "测试直方图", []float64{1, 0.1}, "route")
+	histogram.Observe(0.05, "a")
+	histogram.Observe(0.5, "a")
+	histogram.Observe(5, "a")
+
+	metrics.CacheRequests.Inc("test")
+	metrics.CacheRequests.Inc("test")
+	metrics.CacheHits.Inc("test")
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/MediaWarp/metrics", nil))
+	body := recorder.Body.String()
+
+	for _, want := range []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{route="Videos\"Handler"} 3`,
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{route="a",le="0.1"} 1`,
+		`test_duration_seconds_bucket{route="a",le="1"} 2`,
+		`test_duration_seconds_bucket{route="a",le="+Inf"} 3`,
+		`test_duration_seconds_sum{route="a"} 5.55`,
+		`test_duration_seconds_count{route="a"} 3`,
+		`mediawarp_cache_hit_ratio{cache="test"} 0.5`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("指标输出中缺少 %q，实际输出：\n%s", want, body)
+		}
+	}
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type 错误：%s", contentType)
+	}
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/logging"
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 管理后台认证
+//
+// 使用 HTTP Basic 认证，每次请求时读取配置，重新加载配置后新的账号密码立即生效
+func AdminAuth() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		username, password, ok := ctx.Request.BasicAuth()
+		if ok &&
+			subtle.ConstantTimeCompare([]byte(username), []byte(config.Get().Admin.Username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(password), []byte(config.Get().Admin.Password)) == 1 {
+			ctx.Set(logging.UserContextKey, username)
+			ctx.Next()
+			return
+		}
+		if ok {
+			logging.Warning("管理后台认证失败，用户名：", username, "，IP：", ctx.ClientIP())
+		}
+		ctx.Header("WWW-Authenticate", `Basic realm="MediaWarp"`)
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+	}
+}
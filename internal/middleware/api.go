@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/handler"
+	"MediaWarp/internal/logging"
+	"MediaWarp/utils"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 管理 API 认证
+//
+// 允许携带 API 密钥（X-API-Key 请求头）或媒体服务器管理员访问令牌（X-Emby-Token、api_key 等）的请求
+func APIAuth() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token := ctx.GetHeader("X-API-Key")
+		if token == "" {
+			token = utils.GetClientInfo(ctx.Request).Token
+		}
+		if config.Get().API.Key != "" && subtle.ConstantTimeCompare([]byte(token), []byte(config.Get().API.Key)) == 1 {
+			ctx.Set(logging.UserContextKey, "API Key")
+			ctx.Next()
+			return
+		}
+
+		user, err := handler.AuthenticateToken(token)
+		switch {
+		case errors.Is(err, handler.ErrTokenMissing), errors.Is(err, handler.ErrInvalidToken):
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			logging.Warning("验证访问令牌失败：", err)
+			ctx.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		case !user.IsAdministrator:
+			logging.Warning("非管理员用户尝试访问管理 API：", user.Name)
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "仅允许媒体服务器管理员访问"})
+			return
+		}
+		ctx.Set(logging.UserContextKey, user.Name)
+		ctx.Next()
+	}
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/handler"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 用户认证
+//
+// 启用 Auth 时要求请求携带媒体服务器用户的访问令牌（X-Emby-Token、api_key 等），Auth.PublicPaths 中的路径除外
+// 每次请求时读取配置，重新加载配置后立即生效
+func Auth() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !config.Get().Auth.Enable || isPublicPath(ctx.Request.URL.Path) {
+			ctx.Next()
+			return
+		}
+		if handler.RequireAuth(ctx) {
+			ctx.Next()
+		}
+	}
+}
+
+// 判断路径是否无需认证
+//
+// 按路径段匹配 Auth.PublicPaths 中的前缀
+func isPublicPath(path string) bool {
+	for _, prefix := range config.Get().Auth.PublicPaths {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
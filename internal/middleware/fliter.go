@@ -1,50 +1,55 @@
 package middleware
 
 import (
-	"MediaWarp/constants"
-	"MediaWarp/internal/config"
+	"MediaWarp/internal/clientfilter"
 	"MediaWarp/internal/logging"
+	"MediaWarp/internal/metrics"
+	"MediaWarp/utils"
+	"errors"
 	"net/http"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 // 客户端过滤器
+//
+// 规则和名单在处理请求时读取，重新加载配置后立即生效
 func ClientFilter() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		userAgent := ctx.Request.UserAgent()
-		var allowed bool
-		if userAgent == "" { // 开启了客户端过滤器后禁止所有未提供User-Agent的链接
-			allowed = false
-		} else {
-			switch config.ClientFilter.Mode {
-			case constants.WHITELIST: // 白名单模式
-				allowed = false
-				for _, ua := range config.ClientFilter.ClientList {
-					if strings.Contains(userAgent, ua) {
-						allowed = true
-						break
-					}
-				}
-			case constants.BLACKLIST: // 黑名单模式
-				allowed = true
-				for _, ua := range config.ClientFilter.ClientList {
-					if strings.Contains(userAgent, ua) {
-						allowed = false
-						break
-					}
-				}
-			}
+		filter := clientfilter.Get()
+		if filter == nil || isHealthPath(ctx.Request.URL.Path) { // 健康检查通常由 Docker、Kubernetes 等工具发起，不过滤
+			ctx.Next()
+			return
 		}
 
-		if !allowed {
+		userAgent := ctx.Request.UserAgent()
+		client := utils.GetClientInfo(ctx.Request)
+		if err := filter.Check(userAgent, client); err != nil {
 			ctx.AbortWithStatus(http.StatusForbidden) // 禁止访问
-			logging.Info("客户端过滤器拦截了请求，User-Agent: ", userAgent)
+			metrics.ClientFilterBlocks.Inc(blockReason(err))
+			logging.Infof("客户端过滤器拦截了请求：%s，User-Agent：%s，客户端：%s %s，设备：%s（%s）", err, userAgent, client.Client, client.Version, client.Device, client.DeviceID)
 			return
 		}
 		logging.Debug("客户端过滤器放行了请求，User-Agent: ", userAgent)
 		ctx.Next()
+	}
+}
+
+// 是否为健康检查路径
+func isHealthPath(path string) bool {
+	return path == "/MediaWarp/healthz" || path == "/MediaWarp/readyz"
+}
 
+// 拦截原因，用作指标标签
+func blockReason(err error) string {
+	switch {
+	case errors.Is(err, clientfilter.ErrRuleDenied):
+		return "Rule"
+	case errors.Is(err, clientfilter.ErrNotInWhiteList):
+		return "WhiteList"
+	case errors.Is(err, clientfilter.ErrInBlackList):
+		return "BlackList"
+	default:
+		return "Unknown"
 	}
 }
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/ipfilter"
+	"MediaWarp/internal/logging"
+	"MediaWarp/internal/metrics"
+	"net/http"
+	"net/netip"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IP 访问控制
+//
+// 视频流请求（/Videos、/Audio）使用 IPFilter.Stream 规则，其余请求使用 IPFilter.Web 规则
+// 客户端 IP 由 gin 根据可信代理解析 X-Forwarded-For 得到；健康检查不受限制
+func IPFilter() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		filter := ipfilter.Get()
+		if filter == nil || isHealthPath(ctx.Request.URL.Path) {
+			ctx.Next()
+			return
+		}
+
+		route := "web"
+		if constants.StreamRouteRegexp.MatchString(ctx.Request.URL.Path) {
+			route = "stream"
+		}
+		clientIP := ctx.ClientIP()
+		addr, err := netip.ParseAddr(clientIP)
+		if err == nil {
+			err = filter.Check(addr, route == "stream")
+		}
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusForbidden)
+			metrics.IPFilterBlocks.Inc(route)
+			logging.Infof("IP 访问控制拦截了请求：%s，IP：%s，原因：%s", ctx.Request.URL.Path, clientIP, err)
+			return
+		}
+		ctx.Next()
+	}
+}
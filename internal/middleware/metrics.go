@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"MediaWarp/internal/metrics"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 记录请求指标
+//
+// 路由名称优先使用正则路由处理器写入上下文的规则名称，其次使用 gin 注册的路由
+func Metrics() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		startTime := time.Now()
+		ctx.Next()
+
+		route := ctx.GetString(metrics.RouteContextKey)
+		if route == "" {
+			route = ctx.FullPath()
+		}
+		if route == "" {
+			route = "NoRoute"
+		}
+		metrics.HTTPRequests.Inc(route, ctx.Request.Method, strconv.Itoa(ctx.Writer.Status()))
+		metrics.HTTPRequestDuration.ObserveSince(startTime, route)
+	}
+}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/logging"
+	"MediaWarp/internal/metrics"
+	"MediaWarp/internal/ratelimit"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxAuthBodySize = 64 << 10 // 读取登录请求体的最大长度
+
+// 请求频率限制
+//
+// 拦截被封禁的 IP 和超过频率限制的请求，登录接口额外按 IP 和用户名限制频率
+// 登录接口的上游响应为 401 时记录一次登录失败，失败次数过多时临时封禁 IP；健康检查不受限制
+func RateLimit() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if isHealthPath(ctx.Request.URL.Path) {
+			ctx.Next()
+			return
+		}
+		limiter := ratelimit.Get()
+		clientIP := ctx.ClientIP()
+		retryAfter, err := limiter.Allow(clientIP)
+		isAuth := err == nil && constants.AuthRouteRegexp.MatchString(ctx.Request.URL.Path)
+		if isAuth {
+			err = limiter.AllowAuth(clientIP, authUsername(ctx))
+		}
+		if err != nil {
+			rejectRateLimited(ctx, clientIP, retryAfter, err)
+			return
+		}
+
+		ctx.Next()
+
+		if !isAuth {
+			return
+		}
+		switch status := ctx.Writer.Status(); {
+		case status == http.StatusUnauthorized:
+			limiter.Failed(clientIP)
+		case status >= 200 && status < 300:
+			limiter.Succeeded(clientIP)
+		}
+	}
+}
+
+// 拒绝超过频率限制或被封禁的请求
+func rejectRateLimited(ctx *gin.Context, clientIP string, retryAfter time.Duration, err error) {
+	status, reason := http.StatusTooManyRequests, "global"
+	switch {
+	case errors.Is(err, ratelimit.ErrBanned):
+		status, reason = http.StatusForbidden, "banned"
+	case errors.Is(err, ratelimit.ErrIPLimited):
+		reason = "ip"
+	case errors.Is(err, ratelimit.ErrAuthIPLimited):
+		reason = "auth_ip"
+	case errors.Is(err, ratelimit.ErrAuthUserLimited):
+		reason = "auth_username"
+	}
+	if retryAfter > 0 {
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	ctx.AbortWithStatus(status)
+	metrics.RateLimitBlocks.Inc(reason)
+	logging.Infof("请求频率限制拦截了请求：%s，IP：%s，原因：%s", ctx.Request.URL.Path, clientIP, err)
+}
+
+// 获取登录请求中的用户名
+//
+// /Users/AuthenticateByName 从 JSON 或表单请求体的 Username 字段获取，/Users/{Id}/Authenticate 使用用户 ID
+// 读取请求体后恢复，不影响转发至上游服务器
+func authUsername(ctx *gin.Context) string {
+	path := strings.TrimSuffix(ctx.Request.URL.Path, "/")
+	if !strings.HasSuffix(strings.ToLower(path), "/authenticatebyname") {
+		parts := strings.Split(path, "/")
+		return parts[len(parts)-2]
+	}
+	if username := ctx.Query("username"); username != "" {
+		return username
+	}
+	if ctx.Request.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(ctx.Request.Body, maxAuthBodySize))
+	if err != nil {
+		logging.Debug("读取登录请求体失败：", err)
+	}
+	ctx.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), ctx.Request.Body))
+
+	if strings.Contains(ctx.ContentType(), "json") {
+		var req struct {
+			Username string
+		}
+		if json.Unmarshal(body, &req) == nil {
+			return req.Username
+		}
+		return ""
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return ""
+	}
+	for key, value := range values {
+		if strings.EqualFold(key, "username") && len(value) > 0 {
+			return value[0]
+		}
+	}
+	return ""
+}
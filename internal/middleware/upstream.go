@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"MediaWarp/internal/handler"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 选择处理请求的上游媒体服务器
+//
+// 按 Upstreams 的配置匹配请求，未匹配时使用 MediaServer
+func SelectUpstream() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Request = handler.SelectMediaServer(ctx.Request)
+	}
+}
@@ -0,0 +1,269 @@
+package ratelimit
+
+import (
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/logging"
+	"cmp"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const pruneInterval = time.Minute // 清理空闲令牌桶和过期记录的间隔
+
+var (
+	ErrBanned          = errors.New("IP 地址因多次登录失败被临时封禁")
+	ErrGlobalLimited   = errors.New("超过全局请求频率限制")
+	ErrIPLimited       = errors.New("超过 IP 请求频率限制")
+	ErrAuthIPLimited   = errors.New("超过登录接口 IP 请求频率限制")
+	ErrAuthUserLimited = errors.New("超过登录接口用户名请求频率限制")
+)
+
+// 令牌桶
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// 规则是否限制请求频率
+func limited(rule config.RateLimitRule) bool {
+	return rule.Requests > 0 && rule.Period > 0
+}
+
+// 消耗一个令牌
+//
+// 令牌不足时返回 false
+func (b *bucket) take(rule config.RateLimitRule, now time.Time) bool {
+	capacity := float64(rule.Requests)
+	if b.last.IsZero() {
+		b.tokens = capacity
+	} else {
+		b.tokens = min(capacity, b.tokens+now.Sub(b.last).Seconds()*capacity/rule.Period.Seconds())
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// 按键区分的令牌桶
+type bucketSet map[string]*bucket
+
+func (set bucketSet) take(key string, rule config.RateLimitRule, now time.Time) bool {
+	b, ok := set[key]
+	if !ok {
+		b = &bucket{}
+		set[key] = b
+	}
+	return b.take(rule, now)
+}
+
+// 清理已补满的令牌桶
+func (set bucketSet) prune(rule config.RateLimitRule, now time.Time) {
+	for key, b := range set {
+		if now.Sub(b.last) >= rule.Period {
+			delete(set, key)
+		}
+	}
+}
+
+// 登录失败记录
+type failure struct {
+	count int
+	first time.Time
+}
+
+// 封禁记录
+type Ban struct {
+	IP       string    `json:"IP"`
+	Failures int       `json:"Failures"` // 封禁前的登录失败次数
+	Since    time.Time `json:"Since"`
+	Until    time.Time `json:"Until"`
+}
+
+// 请求频率限制器
+//
+// 规则在处理请求时读取，重新加载配置后立即生效，令牌桶和封禁记录保存在内存中，重启后重置
+type Limiter struct {
+	mutex           sync.Mutex
+	global          bucket
+	ipBuckets       bucketSet
+	authIPBuckets   bucketSet
+	authUserBuckets bucketSet
+	failures        map[string]*failure
+	bans            map[string]*Ban
+	lastPrune       time.Time
+}
+
+// 创建请求频率限制器
+func New() *Limiter {
+	return &Limiter{
+		ipBuckets:       make(bucketSet),
+		authIPBuckets:   make(bucketSet),
+		authUserBuckets: make(bucketSet),
+		failures:        make(map[string]*failure),
+		bans:            make(map[string]*Ban),
+	}
+}
+
+// 定期清理空闲令牌桶、过期的失败记录和封禁记录
+//
+// 调用方需持有锁
+func (limiter *Limiter) prune(now time.Time) {
+	if now.Sub(limiter.lastPrune) < pruneInterval {
+		return
+	}
+	limiter.lastPrune = now
+	setting := config.Get().RateLimit
+	limiter.ipBuckets.prune(setting.PerIP, now)
+	limiter.authIPBuckets.prune(setting.Auth.PerIP, now)
+	limiter.authUserBuckets.prune(setting.Auth.PerUsername, now)
+	for ip, f := range limiter.failures {
+		if now.Sub(f.first) > setting.Ban.Window {
+			delete(limiter.failures, ip)
+		}
+	}
+	for ip, ban := range limiter.bans {
+		if now.After(ban.Until) {
+			delete(limiter.bans, ip)
+		}
+	}
+}
+
+// 检查 IP 是否被封禁
+//
+// 调用方需持有锁
+func (limiter *Limiter) banned(ip string, now time.Time) (*Ban, bool) {
+	ban, ok := limiter.bans[ip]
+	if !ok {
+		return nil, false
+	}
+	if now.After(ban.Until) {
+		delete(limiter.bans, ip)
+		return nil, false
+	}
+	return ban, true
+}
+
+// 检查请求是否允许通过
+//
+// 依次检查封禁、全局频率限制、IP 频率限制
+// 被封禁时同时返回剩余封禁时长
+func (limiter *Limiter) Allow(ip string) (time.Duration, error) {
+	now := time.Now()
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.prune(now)
+
+	if ban, ok := limiter.banned(ip, now); ok {
+		return ban.Until.Sub(now), ErrBanned
+	}
+	setting := config.Get().RateLimit
+	if limited(setting.Global) && !limiter.global.take(setting.Global, now) {
+		return 0, ErrGlobalLimited
+	}
+	if limited(setting.PerIP) && !limiter.ipBuckets.take(ip, setting.PerIP, now) {
+		return 0, ErrIPLimited
+	}
+	return 0, nil
+}
+
+// 检查登录请求是否允许通过
+//
+// username 为空时不检查用户名频率限制，用户名不区分大小写
+func (limiter *Limiter) AllowAuth(ip string, username string) error {
+	now := time.Now()
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	setting := config.Get().RateLimit.Auth
+	if limited(setting.PerIP) && !limiter.authIPBuckets.take(ip, setting.PerIP, now) {
+		return ErrAuthIPLimited
+	}
+	if username != "" && limited(setting.PerUsername) && !limiter.authUserBuckets.take(strings.ToLower(username), setting.PerUsername, now) {
+		return ErrAuthUserLimited
+	}
+	return nil
+}
+
+// 记录登录失败
+//
+// 时间窗口内失败次数达到上限时封禁 IP，返回是否因此次失败被封禁
+func (limiter *Limiter) Failed(ip string) bool {
+	setting := config.Get().RateLimit.Ban
+	if setting.MaxFailures <= 0 {
+		return false
+	}
+	now := time.Now()
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	f, ok := limiter.failures[ip]
+	if !ok || now.Sub(f.first) > setting.Window {
+		f = &failure{first: now}
+		limiter.failures[ip] = f
+	}
+	f.count++
+	if f.count < setting.MaxFailures {
+		return false
+	}
+	delete(limiter.failures, ip)
+	limiter.bans[ip] = &Ban{IP: ip, Failures: f.count, Since: now, Until: now.Add(setting.Duration)}
+	logging.Warningf("IP %s 在 %s 内登录失败 %d 次，封禁 %s", ip, setting.Window, f.count, setting.Duration)
+	return true
+}
+
+// 记录登录成功
+//
+// 清空 IP 的登录失败次数
+func (limiter *Limiter) Succeeded(ip string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	delete(limiter.failures, ip)
+}
+
+// 获取所有封禁记录
+//
+// 按解封时间从晚到早排序
+func (limiter *Limiter) Bans() []Ban {
+	now := time.Now()
+	limiter.mutex.Lock()
+	bans := make([]Ban, 0, len(limiter.bans))
+	for ip := range limiter.bans {
+		if ban, ok := limiter.banned(ip, now); ok {
+			bans = append(bans, *ban)
+		}
+	}
+	limiter.mutex.Unlock()
+
+	slices.SortFunc(bans, func(a, b Ban) int {
+		return cmp.Or(b.Until.Compare(a.Until), strings.Compare(a.IP, b.IP))
+	})
+	return bans
+}
+
+// 解除 IP 的封禁
+//
+// IP 未被封禁时返回 false
+func (limiter *Limiter) Unban(ip string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	delete(limiter.failures, ip)
+	if _, ok := limiter.bans[ip]; !ok {
+		return false
+	}
+	delete(limiter.bans, ip)
+	logging.Info("已解除 IP 的封禁：", ip)
+	return true
+}
+
+var defaultLimiter = New() // 全局请求频率限制器
+
+// 获取全局请求频率限制器
+func Get() *Limiter {
+	return defaultLimiter
+}
@@ -0,0 +1,71 @@
+package ratelimit_test
+
+import (
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/ratelimit"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	config.Set(&config.Settings{RateLimit: config.RateLimitSetting{
+		PerIP: config.RateLimitRule{Requests: 2, Period: time.Hour},
+		Auth: config.AuthRateLimitRule{
+			PerUsername: config.RateLimitRule{Requests: 1, Period: time.Hour},
+		},
+	}})
+	limiter := ratelimit.New()
+
+	for i := range 2 {
+		if _, err := limiter.Allow("192.168.1.10"); err != nil {
+			t.Fatalf("第 %d 次请求应放行，实际为 %v", i+1, err)
+		}
+	}
+	if _, err := limiter.Allow("192.168.1.10"); !errors.Is(err, ratelimit.ErrIPLimited) {
+		t.Errorf("超过 IP 频率限制时应返回 ErrIPLimited，实际为 %v", err)
+	}
+	if _, err := limiter.Allow("192.168.1.11"); err != nil {
+		t.Errorf("不同 IP 应分别限制，实际为 %v", err)
+	}
+
+	if err := limiter.AllowAuth("192.168.1.10", "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.AllowAuth("192.168.1.11", "alice"); !errors.Is(err, ratelimit.ErrAuthUserLimited) {
+		t.Errorf("用户名不区分大小写，应返回 ErrAuthUserLimited，实际为 %v", err)
+	}
+}
+
+func TestLimiterBan(t *testing.T) {
+	config.Set(&config.Settings{RateLimit: config.RateLimitSetting{
+		Ban: config.BanSetting{MaxFailures: 3, Window: time.Minute, Duration: time.Hour},
+	}})
+	limiter := ratelimit.New()
+
+	limiter.Failed("192.168.1.10")
+	limiter.Failed("192.168.1.10")
+	limiter.Succeeded("192.168.1.10") // 登录成功后重新计数
+	limiter.Failed("192.168.1.10")
+	limiter.Failed("192.168.1.10")
+	if _, err := limiter.Allow("192.168.1.10"); err != nil {
+		t.Fatalf("未达到失败次数上限时不应封禁，实际为 %v", err)
+	}
+	if !limiter.Failed("192.168.1.10") {
+		t.Fatal("达到失败次数上限时应封禁")
+	}
+	retryAfter, err := limiter.Allow("192.168.1.10")
+	if !errors.Is(err, ratelimit.ErrBanned) || retryAfter <= 0 {
+		t.Errorf("被封禁的 IP 应返回 ErrBanned 和剩余封禁时长，实际为 %v、%s", err, retryAfter)
+	}
+	if bans := limiter.Bans(); len(bans) != 1 || bans[0].IP != "192.168.1.10" || bans[0].Failures != 3 {
+		t.Errorf("封禁记录错误：%+v", bans)
+	}
+
+	if !limiter.Unban("192.168.1.10") {
+		t.Fatal("解除封禁失败")
+	}
+	if _, err := limiter.Allow("192.168.1.10"); err != nil {
+		t.Errorf("解除封禁后应放行，实际为 %v", err)
+	}
+}
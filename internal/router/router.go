@@ -5,6 +5,7 @@ import (
 	"MediaWarp/internal/config"
 	"MediaWarp/internal/handler"
 	"MediaWarp/internal/logging"
+	"MediaWarp/internal/metrics"
 	"MediaWarp/internal/middleware"
 	"MediaWarp/static"
 	"net/http"
@@ -16,12 +17,29 @@ func InitRouter() *gin.Engine {
 	ginR := gin.New()
 	ginR.Use(
 		middleware.Logger(),
+		middleware.Metrics(),
 		middleware.Recovery(),
 		middleware.QueryCaseInsensitive(),
 		middleware.SetRefererPolicy(constants.SameOrigin),
 	)
 
-	if config.ClientFilter.Enable {
+	if err := ginR.SetTrustedProxies(config.Get().Forwarded.TrustedProxies); err != nil { // 未设置可信代理时不信任任何 X-Forwarded-For 请求头
+		logging.Warning("设置可信代理失败：", err)
+	}
+	if len(config.Get().Upstreams) > 0 {
+		ginR.Use(middleware.SelectUpstream())
+		logging.Infof("已配置 %d 个上游媒体服务器", len(config.Get().Upstreams))
+	}
+	if config.Get().IPFilter.Enable {
+		ginR.Use(middleware.IPFilter())
+		logging.Info("IP 访问控制中间件已启用")
+	}
+	if config.Get().RateLimit.Enable {
+		ginR.Use(middleware.RateLimit())
+		logging.Info("请求频率限制中间件已启用")
+	}
+
+	if config.Get().ClientFilter.Enable {
 		ginR.Use(middleware.ClientFilter())
 		logging.Info("客户端过滤中间件已启用")
 	} else {
@@ -30,36 +48,107 @@ func InitRouter() *gin.Engine {
 
 	mediawarpRouter := ginR.Group("/MediaWarp")
 	{
-		mediawarpRouter.Any("/version", func(ctx *gin.Context) {
-			ctx.JSON(http.StatusOK, config.Version())
-		})
-		if config.Web.Enable { // 启用 Web 页面修改相关设置
-			mediawarpRouter.StaticFS("/static", http.FS(static.EmbeddedStaticAssets))
-			if config.Web.Custom { // 用户自定义静态资源目录
-				mediawarpRouter.Static("/custom", config.CostomDir())
+		mediawarpRouter.GET("/healthz", handler.HealthzHandler) // 健康检查无需认证
+		mediawarpRouter.GET("/readyz", handler.ReadyzHandler)
+		mediawarpRouter.GET("/metrics", middleware.APIAuth(), gin.WrapH(metrics.Handler())) // 与管理 API 使用相同的认证
+
+		authRouter := mediawarpRouter.Group("", middleware.Auth()) // 启用用户认证时需携带媒体服务器用户的访问令牌
+		{
+			authRouter.Any("/version", func(ctx *gin.Context) {
+				ctx.JSON(http.StatusOK, config.Version())
+			})
+
+			strmRouter := authRouter.Group("/strm")
+			{
+				strmRouter.GET("/report", func(ctx *gin.Context) { // 页面通过管理 API 获取扫描报告
+					ctx.FileFromFS("mediawarp/strm-scan.html", http.FS(static.EmbeddedStaticAssets))
+				})
+			}
+
+			if webEnabled(func(web config.WebSetting) bool { return web.Enable }) { // 启用 Web 页面修改相关设置
+				authRouter.StaticFS("/static", http.FS(static.EmbeddedStaticAssets))
+				if webEnabled(func(web config.WebSetting) bool { return web.Enable && web.Custom }) { // 用户自定义静态资源目录
+					authRouter.Static("/custom", config.CostomDir())
+				}
 			}
 		}
+
+		apiRouter := mediawarpRouter.Group("/api", middleware.APIAuth()) // 管理 API，需 API 密钥或媒体服务器管理员访问令牌
+		{
+			apiRouter.GET("/sessions", handler.SessionsHandler)
+			apiRouter.GET("/history", handler.HistoryHandler)
+			apiRouter.GET("/history/stats", handler.HistoryStatsHandler)
+			apiRouter.GET("/quota", handler.QuotaHandler)
+			apiRouter.GET("/bans", handler.ListBansHandler)
+			apiRouter.DELETE("/bans", handler.UnbanHandler)
+			apiRouter.GET("/alist", handler.ListAlistHandler)
+			apiRouter.POST("/alist", handler.AddAlistHandler)
+			apiRouter.DELETE("/alist", handler.RemoveAlistHandler)
+			apiRouter.PUT("/alist/prefixes", handler.UpdateAlistPrefixesHandler)
+			apiRouter.GET("/httpstrm/prefixes", handler.GetHTTPStrmPrefixesHandler)
+			apiRouter.PUT("/httpstrm/prefixes", handler.UpdateHTTPStrmPrefixesHandler)
+			apiRouter.GET("/clientfilter", handler.GetClientFilterHandler)
+			apiRouter.PUT("/clientfilter", handler.UpdateClientFilterHandler)
+			apiRouter.DELETE("/cache", handler.FlushCacheHandler)
+			apiRouter.GET("/rewrite", handler.StrmRewriteDryRunHandler)
+			apiRouter.GET("/resolver", handler.FinalURLResolverStatsHandler)
+			apiRouter.DELETE("/resolver", handler.FinalURLResolverFlushHandler)
+			apiRouter.GET("/scan", handler.StrmScanReportHandler)
+			apiRouter.POST("/scan", handler.StrmScanStartHandler)
+			apiRouter.POST("/reload", handler.AdminReloadHandler)
+		}
+
+		if config.Get().Admin.Enable { // 管理后台
+			adminRouter := mediawarpRouter.Group("/admin", middleware.AdminAuth())
+			{
+				adminRouter.GET("", func(ctx *gin.Context) {
+					ctx.FileFromFS("mediawarp/admin.html", http.FS(static.EmbeddedStaticAssets))
+				})
+				adminRouter.GET("/api/config", handler.AdminConfigHandler)
+				adminRouter.POST("/api/reload", handler.AdminReloadHandler)
+				adminRouter.GET("/api/alist", handler.AdminAlistHandler)
+				adminRouter.GET("/api/logs", handler.AdminLogsHandler)
+				adminRouter.GET("/api/cache", handler.AdminCacheHandler)
+				adminRouter.GET("/api/resolve", handler.AdminResolveHandler)
+			}
+			logging.Info("管理后台已启用：/MediaWarp/admin")
+		}
 	}
 
 	ginR.NoRoute(RegexpRouterHandler)
 	return ginR
 }
 
+// 判断 MediaServer 或任一上游媒体服务器的 Web 页面修改设置是否满足条件
+func webEnabled(fn func(config.WebSetting) bool) bool {
+	if fn(config.Get().Web) {
+		return true
+	}
+	for _, upstream := range config.Get().Upstreams {
+		if upstream.Web != nil && fn(*upstream.Web) {
+			return true
+		}
+	}
+	return false
+}
+
 // 正则表达式路由处理器
 //
-// 从媒体服务器处理结构体中获取正则路由规则
+// 从处理该请求的媒体服务器处理结构体中获取正则路由规则
 // 依次匹配请求, 找到对应的处理器
 func RegexpRouterHandler(ctx *gin.Context) {
-	mediaServerHandler := handler.GetMediaServer()
+	mediaServerHandler := handler.MediaServerOf(ctx.Request)
 
 	for _, rule := range mediaServerHandler.GetRegexpRouteRules() {
 		if rule.Regexp.MatchString(ctx.Request.URL.Path) { // 不带查询参数的字符串：/emby/Items/54/Images/Primary
 			logging.Debugf("URL: %s 匹配成功 -> %s", ctx.Request.URL.Path, rule.Regexp.String())
+			ctx.Set(metrics.RouteContextKey, rule.Name)
 			rule.Handler(ctx)
 			return
 		}
 	}
 
 	// 未匹配路由
+	ctx.Set(metrics.RouteContextKey, "ReverseProxy")
 	mediaServerHandler.ReverseProxy(ctx.Writer, ctx.Request)
 }
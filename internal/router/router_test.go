@@ -0,0 +1,120 @@
+package router_test
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/handler"
+	"MediaWarp/internal/router"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 返回服务器名称的媒体服务器，/web 重定向至 /web/index.html
+func newMediaServer(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/web", func(rw http.ResponseWriter, req *http.Request) {
+		http.Redirect(rw, req, "/web/index.html", http.StatusFound)
+	})
+	mux.HandleFunc("/", func(rw http.ResponseWriter, req *http.Request) {
+		io.WriteString(rw, name+" "+req.URL.Path)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestUpstreamPathPrefix(t *testing.T) {
+	emby := newMediaServer(t, "emby")
+	jellyfin := newMediaServer(t, "jellyfin")
+	config.Set(&config.Settings{
+		MediaServer: config.MediaServerSetting{Type: constants.EMBY, ADDR: emby.URL},
+		Upstreams: []config.UpstreamSetting{{
+			Name:               "jellyfin",
+			PathPrefix:         "/jellyfin",
+			MediaServerSetting: config.MediaServerSetting{Type: constants.JELLYFIN, ADDR: jellyfin.URL},
+		}},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := handler.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	mediaWarp := httptest.NewServer(router.InitRouter())
+	defer mediaWarp.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	cases := []struct {
+		path     string
+		body     string
+		location string
+	}{
+		{path: "/System/Info", body: "emby /System/Info"},
+		{path: "/web", location: "/web/index.html"},
+		{path: "/jellyfin/System/Info", body: "jellyfin /System/Info"},
+		{path: "/JellyFin/System/Info", body: "jellyfin /System/Info"},
+		{path: "/jellyfin/web", location: "/jellyfin/web/index.html"},
+		{path: "/JellyFin/web", location: "/JellyFin/web/index.html"},
+	}
+	for _, c := range cases {
+		resp, err := client.Get(mediaWarp.URL + c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if c.location != "" {
+			if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != c.location {
+				t.Errorf("%s 响应 %d，Location 为 %q，期望重定向至 %s", c.path, resp.StatusCode, resp.Header.Get("Location"), c.location)
+			}
+			continue
+		}
+		if string(body) != c.body {
+			t.Errorf("%s 响应 %q，期望 %q", c.path, body, c.body)
+		}
+	}
+}
+
+// 管理 API 和 Prometheus 指标需携带 API 密钥
+func TestAPIAuth(t *testing.T) {
+	emby := newMediaServer(t, "emby")
+	config.Set(&config.Settings{
+		MediaServer: config.MediaServerSetting{Type: constants.EMBY, ADDR: emby.URL},
+		API:         config.APISetting{Key: "api-key"},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := handler.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	mediaWarp := httptest.NewServer(router.InitRouter())
+	defer mediaWarp.Close()
+
+	for _, path := range []string{"/MediaWarp/api/scan", "/MediaWarp/api/resolver", "/MediaWarp/api/rewrite?path=/media/a.strm&target=/a.mkv", "/MediaWarp/metrics"} {
+		resp, err := http.Get(mediaWarp.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s 未携带密钥响应 %d，期望 %d", path, resp.StatusCode, http.StatusUnauthorized)
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, mediaWarp.URL+path, nil)
+		req.Header.Set("X-API-Key", "api-key")
+		if resp, err = http.DefaultClient.Do(req); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s 使用正确的密钥响应 %d，期望 %d", path, resp.StatusCode, http.StatusOK)
+		}
+	}
+}
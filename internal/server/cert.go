@@ -0,0 +1,90 @@
+package server
+
+import (
+	"MediaWarp/internal/logging"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const certCheckInterval = 10 * time.Second // 检查证书文件是否修改的最短间隔
+
+// 证书文件加载器
+//
+// 在 TLS 握手时检查证书文件和私钥文件的修改时间，修改后重新加载
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // 已加载的证书文件和私钥文件中较晚的修改时间
+	checked time.Time // 上次检查修改时间的时间
+}
+
+// 创建证书文件加载器
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := reloader.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err = reloader.load(modTime); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// 获取证书文件和私钥文件中较晚的修改时间
+func (reloader *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{reloader.certFile, reloader.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("读取证书文件失败：%w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// 加载证书
+//
+// 调用方需持有锁（初始化时除外）
+func (reloader *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return fmt.Errorf("加载证书失败：%w", err)
+	}
+	reloader.cert = &cert
+	reloader.modTime = modTime
+	return nil
+}
+
+// tls.Config.GetCertificate
+//
+// 重新加载失败时继续使用已加载的证书
+func (reloader *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+
+	if now := time.Now(); now.Sub(reloader.checked) >= certCheckInterval {
+		reloader.checked = now
+		modTime, err := reloader.latestModTime()
+		switch {
+		case err != nil:
+			logging.Warning("检查证书文件失败，继续使用已加载的证书：", err)
+		case !modTime.Equal(reloader.modTime):
+			if err = reloader.load(modTime); err != nil {
+				logging.Warning("重新加载证书失败，继续使用已加载的证书：", err)
+			} else {
+				logging.Info("证书文件已修改，已重新加载证书：", reloader.certFile)
+			}
+		}
+	}
+	return reloader.cert, nil
+}
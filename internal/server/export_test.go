@@ -0,0 +1,12 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+)
+
+var ReadProxyHeader = readProxyHeader
+
+func NewProxyProtocolListener(listener net.Listener, trusted []netip.Prefix) net.Listener {
+	return &proxyProtocolListener{Listener: listener, trusted: trusted}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"MediaWarp/internal/logging"
+	"MediaWarp/utils"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	proxyHeaderTimeout = 5 * time.Second // 等待 PROXY protocol 头的超时时间
+	proxyV1MaxLength   = 107             // v1 头的最大长度（包括 \r\n）
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n") // v2 头的签名
+
+var ErrInvalidProxyHeader = errors.New("错误的 PROXY protocol 头")
+
+// 支持 PROXY protocol 的监听器
+//
+// 来自可信代理（未设置可信代理时为所有地址）的连接必须以 PROXY protocol 头开始，连接的来源地址替换为头中的客户端地址
+// 其余连接原样返回，发送 PROXY protocol 头时会因无法解析 HTTP 请求而被关闭
+type proxyProtocolListener struct {
+	net.Listener
+	trusted []netip.Prefix
+}
+
+func (listener *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if len(listener.trusted) > 0 {
+		addr, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+		if err != nil || !utils.ContainsAddr(listener.trusted, addr.Addr().Unmap()) {
+			return conn, nil
+		}
+	}
+	return &proxyProtocolConn{Conn: conn}, nil
+}
+
+// 以 PROXY protocol 头开始的连接
+//
+// 在第一次读取或获取来源地址时解析头，避免阻塞 Accept
+// 记录调用方（如 http.Server）设置的读取截止时间，解析头后恢复
+type proxyProtocolConn struct {
+	net.Conn
+	once          sync.Once
+	reader        *bufio.Reader
+	remoteAddr    net.Addr
+	err           error
+	deadlineMutex sync.Mutex
+	readDeadline  time.Time // 调用方设置的读取截止时间
+}
+
+func (conn *proxyProtocolConn) SetDeadline(t time.Time) error {
+	conn.deadlineMutex.Lock()
+	defer conn.deadlineMutex.Unlock()
+	conn.readDeadline = t
+	return conn.Conn.SetDeadline(t)
+}
+
+func (conn *proxyProtocolConn) SetReadDeadline(t time.Time) error {
+	conn.deadlineMutex.Lock()
+	defer conn.deadlineMutex.Unlock()
+	conn.readDeadline = t
+	return conn.Conn.SetReadDeadline(t)
+}
+
+// 解析 PROXY protocol 头
+//
+// 等待头的时间不超过 proxyHeaderTimeout 和调用方设置的读取截止时间
+func (conn *proxyProtocolConn) init() {
+	conn.once.Do(func() {
+		conn.reader = bufio.NewReader(conn.Conn)
+		conn.deadlineMutex.Lock()
+		deadline := time.Now().Add(proxyHeaderTimeout)
+		if !conn.readDeadline.IsZero() && conn.readDeadline.Before(deadline) {
+			deadline = conn.readDeadline
+		}
+		conn.Conn.SetReadDeadline(deadline)
+		conn.deadlineMutex.Unlock()
+
+		addr, err := readProxyHeader(conn.reader)
+
+		conn.deadlineMutex.Lock()
+		conn.Conn.SetReadDeadline(conn.readDeadline)
+		conn.deadlineMutex.Unlock()
+		if err != nil {
+			logging.Warningf("读取 %s 的 PROXY protocol 头失败：%s", conn.Conn.RemoteAddr(), err)
+			conn.err = err
+			conn.Conn.Close()
+			return
+		}
+		conn.remoteAddr = addr
+	})
+}
+
+func (conn *proxyProtocolConn) Read(p []byte) (int, error) {
+	conn.init()
+	if conn.err != nil {
+		return 0, conn.err
+	}
+	return conn.reader.Read(p)
+}
+
+func (conn *proxyProtocolConn) RemoteAddr() net.Addr {
+	conn.init()
+	if conn.remoteAddr != nil {
+		return conn.remoteAddr
+	}
+	return conn.Conn.RemoteAddr()
+}
+
+// 读取 PROXY protocol 头
+//
+// 支持 v1（文本）和 v2（二进制）格式，返回头中的客户端地址；LOCAL 命令、UNKNOWN 或非 TCP 协议返回 nil，使用连接的来源地址
+func readProxyHeader(reader *bufio.Reader) (net.Addr, error) {
+	signature, err := reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(signature, proxyV2Signature) {
+		return readProxyHeaderV2(reader)
+	}
+	if bytes.HasPrefix(signature, []byte("PROXY ")) {
+		return readProxyHeaderV1(reader)
+	}
+	return nil, ErrInvalidProxyHeader
+}
+
+// 读取 v1 头
+//
+// 格式：PROXY TCP4 客户端地址 代理地址 客户端端口 代理端口\r\n
+func readProxyHeaderV1(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidProxyHeader
+	}
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
+
+// 读取 v2 头
+//
+// 格式：12 字节签名、版本和命令、地址族和协议、2 字节地址长度、地址（及 TLV）
+func readProxyHeaderV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, ErrInvalidProxyHeader
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+
+	switch header[12] & 0x0F {
+	case 0x0: // LOCAL：代理自身的连接（如健康检查）
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+	switch header[13] >> 4 {
+	case 0x1: // IPv4
+		if len(payload) < 12 {
+			return nil, ErrInvalidProxyHeader
+		}
+		addr := netip.AddrFrom4([4]byte(payload[0:4]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(payload[8:10]))), nil
+	case 0x2: // IPv6
+		if len(payload) < 36 {
+			return nil, ErrInvalidProxyHeader
+		}
+		addr := netip.AddrFrom16([16]byte(payload[0:16])).Unmap()
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(payload[32:34]))), nil
+	default: // UNSPEC、UNIX
+		return nil, nil
+	}
+}
@@ -0,0 +1,179 @@
+package server_test
+
+import (
+	"MediaWarp/internal/server"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const requestLine = "GET / HTTP/1.1\r\n"
+
+var v2Signature = "\r\n\r\n\x00\r\nQUIT\n"
+
+// 构造 v2 头
+//
+// length 为头中声明的地址长度，payload 可以短于 length 以模拟截断
+func proxyHeaderV2(command byte, family byte, length int, payload []byte) string {
+	header := []byte(v2Signature)
+	header = append(header, command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(length))
+	return string(append(header, payload...))
+}
+
+// v2 头中的地址
+func v2Addr(src string, srcPort uint16, dst string, dstPort uint16) []byte {
+	payload := netip.MustParseAddr(src).AsSlice()
+	payload = append(payload, netip.MustParseAddr(dst).AsSlice()...)
+	payload = binary.BigEndian.AppendUint16(payload, srcPort)
+	return binary.BigEndian.AppendUint16(payload, dstPort)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	cases := []struct {
+		name    string
+		header  string
+		want    string // 客户端地址，为空表示使用连接的来源地址
+		wantErr bool
+	}{
+		{name: "v1 TCP4", header: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", want: "192.0.2.1:56324"},
+		{name: "v1 TCP6", header: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", want: "[2001:db8::1]:56324"},
+		{name: "v1 UNKNOWN", header: "PROXY UNKNOWN\r\n"},
+		{name: "v1 UNKNOWN 带地址", header: "PROXY UNKNOWN 192.0.2.1 198.51.100.1 56324 443\r\n"},
+		{name: "v1 缺少 \\r", header: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n", wantErr: true},
+		{name: "v1 超长", header: "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n", wantErr: true},
+		{name: "v1 截断", header: "PROXY TCP4 192.0.2.1 198.51", wantErr: true},
+		{name: "v1 缺少字段", header: "PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n", wantErr: true},
+		{name: "v1 未知协议", header: "PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n", wantErr: true},
+		{name: "v1 错误的地址", header: "PROXY TCP4 example.com 198.51.100.1 56324 443\r\n", wantErr: true},
+		{name: "v1 错误的端口", header: "PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n", wantErr: true},
+		{name: "v2 PROXY IPv4", header: proxyHeaderV2(0x21, 0x11, 12, v2Addr("192.0.2.1", 56324, "198.51.100.1", 443)), want: "192.0.2.1:56324"},
+		{name: "v2 PROXY IPv6", header: proxyHeaderV2(0x21, 0x21, 36, v2Addr("2001:db8::1", 56324, "2001:db8::2", 443)), want: "[2001:db8::1]:56324"},
+		{name: "v2 PROXY 带 TLV", header: proxyHeaderV2(0x21, 0x11, 16, append(v2Addr("192.0.2.1", 56324, "198.51.100.1", 443), 0x04, 0x00, 0x01, 0x00)), want: "192.0.2.1:56324"},
+		{name: "v2 LOCAL", header: proxyHeaderV2(0x20, 0x00, 0, nil)},
+		{name: "v2 LOCAL 带地址", header: proxyHeaderV2(0x20, 0x11, 12, v2Addr("192.0.2.1", 56324, "198.51.100.1", 443))},
+		{name: "v2 UNSPEC", header: proxyHeaderV2(0x21, 0x00, 0, nil)},
+		{name: "v2 错误的版本", header: proxyHeaderV2(0x11, 0x11, 12, v2Addr("192.0.2.1", 56324, "198.51.100.1", 443)), wantErr: true},
+		{name: "v2 未知命令", header: proxyHeaderV2(0x22, 0x11, 12, v2Addr("192.0.2.1", 56324, "198.51.100.1", 443)), wantErr: true},
+		{name: "v2 IPv4 地址过短", header: proxyHeaderV2(0x21, 0x11, 4, []byte{192, 0, 2, 1}), wantErr: true},
+		{name: "v2 IPv6 地址过短", header: proxyHeaderV2(0x21, 0x21, 12, v2Addr("192.0.2.1", 56324, "198.51.100.1", 443)), wantErr: true},
+		{name: "v2 地址截断", header: proxyHeaderV2(0x21, 0x11, 12, []byte{192, 0, 2, 1}), wantErr: true},
+		{name: "v2 头截断", header: v2Signature + "\x21", wantErr: true},
+		{name: "签名截断", header: "PROX", wantErr: true},
+		{name: "HTTP 请求", header: requestLine, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := c.header
+			if !c.wantErr {
+				data += requestLine
+			}
+			reader := bufio.NewReader(strings.NewReader(data))
+			addr, err := server.ReadProxyHeader(reader)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("应返回错误，实际返回地址 %v", addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != c.want {
+				t.Errorf("客户端地址为 %q，期望 %q", got, c.want)
+			}
+			if rest, _ := io.ReadAll(reader); string(rest) != requestLine {
+				t.Errorf("头之后的数据为 %q，期望 %q", rest, requestLine)
+			}
+		})
+	}
+}
+
+// 通过监听器建立连接并发送 data，返回服务端的连接
+func acceptWith(t *testing.T, trusted []netip.Prefix, data string) net.Conn {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { inner.Close() })
+	listener := server.NewProxyProtocolListener(inner, trusted)
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if _, err := io.WriteString(client, data); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	header := "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+
+	t.Run("可信代理", func(t *testing.T) {
+		conn := acceptWith(t, []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}, header+requestLine)
+		if got := conn.RemoteAddr().String(); got != "192.0.2.1:56324" {
+			t.Errorf("来源地址为 %s，期望 192.0.2.1:56324", got)
+		}
+		buf := make([]byte, len(requestLine))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != requestLine {
+			t.Errorf("读取到 %q（%v），期望 %q", buf, err, requestLine)
+		}
+	})
+
+	t.Run("不可信的来源地址", func(t *testing.T) {
+		conn := acceptWith(t, []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, header+requestLine)
+		if addr, _ := netip.ParseAddrPort(conn.RemoteAddr().String()); addr.Addr() != netip.MustParseAddr("127.0.0.1") {
+			t.Errorf("来源地址为 %s，期望连接的来源地址", conn.RemoteAddr())
+		}
+		buf := make([]byte, len(header))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != header {
+			t.Errorf("不可信的连接应原样读取，读取到 %q（%v）", buf, err)
+		}
+	})
+
+	t.Run("错误的头", func(t *testing.T) {
+		conn := acceptWith(t, nil, requestLine)
+		if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, server.ErrInvalidProxyHeader) {
+			t.Errorf("读取错误为 %v，期望 %v", err, server.ErrInvalidProxyHeader)
+		}
+	})
+
+	t.Run("保留读取截止时间", func(t *testing.T) {
+		conn := acceptWith(t, nil, header)
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		done := make(chan error, 1)
+		go func() {
+			_, err := conn.Read(make([]byte, 1))
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Errorf("读取错误为 %v，期望超时", err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("解析头后读取截止时间被清除")
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"MediaWarp/constants"
+	"MediaWarp/internal/config"
+	"MediaWarp/internal/logging"
+	"MediaWarp/utils"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var ready atomic.Bool // 服务器是否就绪
+
+// 服务器是否就绪
+//
+// 所有地址均监听成功后就绪，开始退出后未就绪
+func Ready() bool {
+	return ready.Load()
+}
+
+// MediaWarp 服务器
+//
+// 按 Listeners 设置同时监听多个地址，每个地址可分别使用 HTTP 或 HTTPS
+type Server struct {
+	servers       []*http.Server
+	proxyProtocol []bool             // 各地址是否接受 PROXY protocol 头
+	trusted       []netip.Prefix     // 可信代理，仅要求来自这些地址的连接发送 PROXY protocol 头
+	baseCtx       context.Context    // 所有请求上下文的父上下文
+	cancel        context.CancelFunc // 取消所有请求的上下文
+}
+
+// 创建服务器
+func New(handler http.Handler) (*Server, error) {
+	settings := config.ListenerSettings()
+	var (
+		tlsConfig   *tls.Config
+		httpHandler = handler // HTTP 监听使用的处理器
+	)
+	if slices.ContainsFunc(settings, func(setting config.ListenerSetting) bool { return setting.TLS }) {
+		if config.Get().TLS.ACME.Enable {
+			manager := newACMEManager()
+			tlsConfig = manager.TLSConfig()
+			if config.Get().TLS.ACME.Challenge == constants.ACMEHTTP01 { // HTTP 监听响应 /.well-known/acme-challenge/ 验证请求
+				httpHandler = manager.HTTPHandler(handler)
+				if !slices.ContainsFunc(settings, func(setting config.ListenerSetting) bool { return !setting.TLS }) {
+					logging.Warning("ACME 验证方式为 HTTP-01，但未设置 HTTP 监听，无法完成验证")
+				}
+			}
+			logging.Info("已启用 ACME 自动申请证书，域名：", config.Get().TLS.ACME.Domains)
+		} else {
+			reloader, err := newCertReloader(config.Get().TLS.CertFile, config.Get().TLS.KeyFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig = &tls.Config{GetCertificate: reloader.GetCertificate}
+		}
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+
+	trusted, err := utils.ParsePrefixes(config.Get().Forwarded.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("可信代理：%w", err)
+	}
+	server := &Server{servers: make([]*http.Server, 0, len(settings)), trusted: trusted}
+	server.baseCtx, server.cancel = context.WithCancel(context.Background())
+	for _, setting := range settings {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		srv := &http.Server{
+			Addr:        setting.Addr,
+			Handler:     httpHandler,
+			Protocols:   protocols,
+			BaseContext: func(net.Listener) context.Context { return server.baseCtx },
+		}
+		if setting.TLS {
+			srv.Handler = handler
+			srv.TLSConfig = tlsConfig.Clone()
+			protocols.SetHTTP2(true)
+		} else if setting.H2C {
+			protocols.SetUnencryptedHTTP2(true)
+		}
+		server.servers = append(server.servers, srv)
+		server.proxyProtocol = append(server.proxyProtocol, setting.ProxyProtocol)
+	}
+	return server, nil
+}
+
+// 创建 ACME 证书管理器
+func newACMEManager() *autocert.Manager {
+	setting := config.Get().TLS.ACME
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(setting.Domains...),
+		Cache:      autocert.DirCache(config.ACMECacheDir()),
+		Email:      setting.Email,
+	}
+	if setting.DirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: setting.DirectoryURL}
+	}
+	return manager
+}
+
+// 启动服务器
+//
+// 所有地址均监听成功后在后台处理请求，处理请求过程中出现的错误发送至 errChan
+func (server *Server) Start(errChan chan<- error) error {
+	listeners := make([]net.Listener, 0, len(server.servers))
+	for _, srv := range server.servers {
+		listener, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return fmt.Errorf("监听 %s 失败：%w", srv.Addr, err)
+		}
+		if server.proxyProtocol[len(listeners)] {
+			listener = &proxyProtocolListener{Listener: listener, trusted: server.trusted}
+		}
+		listeners = append(listeners, listener)
+	}
+
+	for i, srv := range server.servers {
+		scheme := "HTTP"
+		if srv.TLSConfig != nil {
+			scheme = "HTTPS"
+		}
+		if server.proxyProtocol[i] {
+			scheme += "，PROXY protocol"
+		}
+		logging.Infof("MediaWarp 监听地址：%s（%s）", srv.Addr, scheme)
+		go func() {
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ServeTLS(listeners[i], "", "")
+			} else {
+				err = srv.Serve(listeners[i])
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errChan <- fmt.Errorf("%s：%w", srv.Addr, err)
+			}
+		}()
+	}
+	ready.Store(true)
+	return nil
+}
+
+// 优雅退出
+//
+// 先将就绪状态设置为未就绪并等待 Shutdown.Delay，再停止接受新连接并等待正在处理的请求完成
+// 请求完成、超过 Shutdown.DrainTimeout 或 ctx 取消后取消所有请求的上下文，中断仍在代理的视频流和 WebSocket 连接
+func (server *Server) Shutdown(ctx context.Context) error {
+	ready.Store(false)
+	if delay := config.Get().Shutdown.Delay; delay > 0 {
+		logging.Infof("已设置为未就绪，%s 后停止接受新连接", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.Get().Shutdown.DrainTimeout)
+	defer cancel()
+	logging.Infof("停止接受新连接，最多等待 %s 让正在处理的请求完成", config.Get().Shutdown.DrainTimeout)
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(server.servers))
+	)
+	for i, srv := range server.servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = srv.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+	server.cancel() // 已劫持的连接（WebSocket）不受 http.Server.Shutdown 管理，通过取消请求上下文关闭
+
+	if err := errors.Join(errs...); err != nil {
+		for _, srv := range server.servers {
+			srv.Close()
+		}
+		return fmt.Errorf("等待请求完成失败，已强制关闭连接：%w", err)
+	}
+	return nil
+}
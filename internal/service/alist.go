@@ -5,6 +5,8 @@ import (
 	"MediaWarp/internal/service/alist"
 	"MediaWarp/utils"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 )
 
@@ -13,19 +15,44 @@ var (
 )
 
 // 初始化 Alist 服务器
+//
+// 包括上游媒体服务器单独设置的 AlistStrm 中的服务器
+// 重新加载配置时先注册新的服务器，再移除配置中已删除的服务器，避免正在进行的请求找不到服务器
 func InitAlistSerer() {
-	if config.AlistStrm.Enable {
-		for _, alist := range config.AlistStrm.List {
-			registerAlistServer(alist.ADDR, alist.Username, alist.Password, alist.Token)
+	settings := []config.AlistStrmSetting{config.Get().AlistStrm}
+	for _, upstream := range config.Get().Upstreams {
+		if upstream.AlistStrm != nil {
+			settings = append(settings, *upstream.AlistStrm)
+		}
+	}
+
+	registered := make(map[string]struct{})
+	for _, setting := range settings {
+		if !setting.Enable {
+			continue
+		}
+		for _, alist := range setting.List {
+			metaPasswords := make(map[string]string, len(alist.MetaPasswords))
+			for _, metaPassword := range alist.MetaPasswords {
+				metaPasswords[metaPassword.Path] = metaPassword.Password
+			}
+			registerAlistServer(alist.ADDR, alist.Username, alist.Password, alist.Token, metaPasswords)
+			registered[utils.GetEndpoint(alist.ADDR)] = struct{}{}
 		}
 	}
+	alistSeverMap.Range(func(endpoint, _ any) bool {
+		if _, ok := registered[endpoint.(string)]; !ok {
+			alistSeverMap.Delete(endpoint)
+		}
+		return true
+	})
 }
 
 // 注册Alist服务器
 //
 // 将Alist服务器注册到全局Map中
-func registerAlistServer(addr string, username string, password string, token *string) {
-	alistServer := alist.New(addr, username, password, token)
+func registerAlistServer(addr string, username string, password string, token *string, metaPasswords map[string]string) {
+	alistServer := alist.New(addr, username, password, token, metaPasswords)
 	alistSeverMap.Store(alistServer.GetEndpoint(), alistServer)
 }
 
@@ -40,3 +67,18 @@ func GetAlistServer(addr string) (*alist.AlistServer, error) {
 	}
 	return nil, fmt.Errorf("%s 未注册到 Alist 服务器列表中", endpoint)
 }
+
+// 获取所有已注册的Alist服务器
+//
+// 按服务器地址排序
+func ListAlistServers() []*alist.AlistServer {
+	var servers []*alist.AlistServer
+	alistSeverMap.Range(func(_, server any) bool {
+		servers = append(servers, server.(*alist.AlistServer))
+		return true
+	})
+	slices.SortFunc(servers, func(a, b *alist.AlistServer) int {
+		return strings.Compare(a.GetEndpoint(), b.GetEndpoint())
+	})
+	return servers
+}
@@ -1,7 +1,10 @@
 package alist
 
 import (
+	"MediaWarp/internal/metrics"
 	"MediaWarp/utils"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,16 +15,25 @@ import (
 	"time"
 )
 
+const (
+	tokenDuration  = 2*24*time.Hour - 5*time.Minute // Token 有效期为 2 天，提前 5 分钟刷新
+	requestTimeout = 15 * time.Second               // 请求 Alist API 的超时时间
+	pingTimeout    = 5 * time.Second                // 检测 Alist 服务器是否可用的超时时间
+)
+
 type alistToken struct {
-	value    string       // 令牌 Token
-	expireAt time.Time    // 令牌过期时间
-	mutex    sync.RWMutex // 令牌锁
+	value      string       // 令牌 Token
+	expireAt   time.Time    // 令牌过期时间
+	mutex      sync.RWMutex // 令牌锁
+	loginMutex sync.Mutex   // 登录锁，保证同一时间只有一个请求在登录
 }
 type AlistServer struct {
-	endpoint string // 服务器入口 URL
-	username string // 用户名
-	password string // 密码
-	token    alistToken
+	endpoint      string            // 服务器入口 URL
+	username      string            // 用户名
+	password      string            // 密码
+	metaPasswords map[string]string // 路径 -> 元信息密码
+	token         alistToken
+	client        *http.Client
 }
 
 // 得到服务器入口
@@ -38,21 +50,62 @@ func (alistServer *AlistServer) GetUsername() string {
 	return alistServer.username
 }
 
+// 得到路径对应的元信息密码
+//
+// 按路径前缀匹配，存在多个匹配时使用最长（最具体）的路径
+func (alistServer *AlistServer) getMetaPassword(path string) string {
+	var (
+		matched  string
+		password string
+	)
+	for metaPath, metaPassword := range alistServer.metaPasswords {
+		if isSubPath(path, metaPath) && len(metaPath) > len(matched) {
+			matched, password = metaPath, metaPassword
+		}
+	}
+	return password
+}
+
+// 判断 path 是否为 parent 本身或其子路径
+func isSubPath(path string, parent string) bool {
+	parent = strings.TrimSuffix(parent, "/")
+	return path == parent || strings.HasPrefix(path, parent+"/") || parent == ""
+}
+
 // 得到一个可用的 Token
 //
-// 先从缓存池中读取，若过期或者未找到则重新生成
+// 先从缓存池中读取，若过期或者未找到则重新登录
 func (alistServer *AlistServer) getToken() (string, error) {
-	var tokenDuration = 2*24*time.Hour - 5*time.Minute // Token 有效期为 2 天，提前 5 分钟刷新
-
 	alistServer.token.mutex.RLock()
-	if alistServer.token.value != "" && (alistServer.token.expireAt.IsZero() || time.Now().Before(alistServer.token.expireAt)) {
-		// 零值表示永不过期
-		defer alistServer.token.mutex.RUnlock()
-		return alistServer.token.value, nil
+	token := alistServer.token.value
+	valid := token != "" && (alistServer.token.expireAt.IsZero() || time.Now().Before(alistServer.token.expireAt)) // 零值表示永不过期
+	alistServer.token.mutex.RUnlock()
+	if valid {
+		return token, nil
 	}
+	return alistServer.refreshToken(token)
+}
 
-	token, err := alistServer.authLogin() // 重新生成一个token
+// 刷新 Token
+//
+// staleToken 为调用方认为已失效的 Token
+// 同一时间只允许一个请求登录，其余请求等待登录完成后直接复用新的 Token
+func (alistServer *AlistServer) refreshToken(staleToken string) (string, error) {
+	alistServer.token.loginMutex.Lock()
+	defer alistServer.token.loginMutex.Unlock()
+
+	alistServer.token.mutex.RLock()
+	current := alistServer.token.value
 	alistServer.token.mutex.RUnlock()
+	if current != "" && current != staleToken { // 等待期间其他请求已完成登录
+		return current, nil
+	}
+
+	if alistServer.username == "" { // 仅配置了 Token，无法重新登录
+		return "", fmt.Errorf("%w：Token 已失效且未配置用户名和密码", ErrUnauthorized)
+	}
+
+	token, err := alistServer.authLogin()
 	if err != nil {
 		return "", err
 	}
@@ -60,122 +113,153 @@ func (alistServer *AlistServer) getToken() (string, error) {
 	alistServer.token.mutex.Lock()
 	defer alistServer.token.mutex.Unlock()
 	alistServer.token.value = token
-	alistServer.token.expireAt = time.Now().Add(tokenDuration) // Token 有效期为30分钟
-
+	alistServer.token.expireAt = time.Now().Add(tokenDuration)
 	return token, nil
 }
 
-// ==========Alist API(v3) 相关操作==========
-
-// 登录Alist（获取一个新的Token）
-func (alistServer *AlistServer) authLogin() (string, error) {
-	var (
-		funcInfo          = "Alist登录"
-		url               = alistServer.GetEndpoint() + "/api/auth/login"
-		method            = http.MethodPost
-		payload           = strings.NewReader(fmt.Sprintf(`{"username": "%s","password": "%s"}`, alistServer.GetUsername(), alistServer.password))
-		authLoginResponse AlistResponse[AuthLoginData]
-	)
+// 请求 Alist API
+//
+// 将 payload 序列化为 JSON 作为请求体，解析响应中的 data 字段
+// token 为空时不携带 Authorization 头
+func request[T any](alistServer *AlistServer, funcInfo string, api string, payload any, token string) (T, error) {
+	var alistResponse AlistResponse[T]
 
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, payload)
+	body, err := json.Marshal(payload)
 	if err != nil {
-		err = fmt.Errorf("创建 %s 请求失败: %w", funcInfo, err)
-		return "", err
+		return alistResponse.Data, fmt.Errorf("序列化 %s 请求体失败: %w", funcInfo, err)
 	}
-	req.Header.Add("Content-Type", "application/json")
 
-	res, err := client.Do(req)
+	req, err := http.NewRequest(http.MethodPost, alistServer.GetEndpoint()+api, bytes.NewReader(body))
 	if err != nil {
-		err = fmt.Errorf("请求 %s 失败: %w", funcInfo, err)
-		return "", err
+		return alistResponse.Data, fmt.Errorf("创建 %s 请求失败: %w", funcInfo, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
 	}
 
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
+	res, err := alistServer.client.Do(req)
 	if err != nil {
-		err = fmt.Errorf("读取 %s 响应体失败: %w", funcInfo, err)
-		return "", err
+		return alistResponse.Data, fmt.Errorf("%w：请求 %s 失败: %v", ErrUnavailable, funcInfo, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return alistResponse.Data, &APIError{Code: http.StatusUnauthorized, Message: res.Status}
+	}
+	if res.StatusCode >= http.StatusInternalServerError {
+		return alistResponse.Data, fmt.Errorf("%w：%s 响应状态码 %d", ErrUnavailable, funcInfo, res.StatusCode)
 	}
 
-	err = json.Unmarshal(body, &authLoginResponse)
+	resBody, err := io.ReadAll(res.Body)
 	if err != nil {
-		err = fmt.Errorf("解析 %s 响应体失败: %w", funcInfo, err)
-		return "", err
+		return alistResponse.Data, fmt.Errorf("%w：读取 %s 响应体失败: %v", ErrUnavailable, funcInfo, err)
 	}
-	if authLoginResponse.Code != 200 {
-		err = errors.New(authLoginResponse.Message)
-		return "", err
+	if err = json.Unmarshal(resBody, &alistResponse); err != nil {
+		return alistResponse.Data, fmt.Errorf("%w：解析 %s 响应体失败: %v", ErrUnavailable, funcInfo, err)
 	}
-
-	return authLoginResponse.Data.Token, nil
+	if alistResponse.Code != http.StatusOK {
+		return alistResponse.Data, &APIError{Code: alistResponse.Code, Message: alistResponse.Message}
+	}
+	return alistResponse.Data, nil
 }
 
-// 获取某个文件/目录信息
-func (alistServer *AlistServer) FsGet(path string) (FsGetData, error) {
-	var (
-		fsGetDataResponse AlistResponse[FsGetData]
-		token             string
-		funcInfo          = "Alist获取某个文件/目录信息"
-		url               = alistServer.GetEndpoint() + "/api/fs/get"
-		method            = "POST"
-		payload           = strings.NewReader(fmt.Sprintf(`{"path": "%s","password": "","page": 1,"per_page": 0,"refresh": false}`, path))
-	)
-
-	// 未从缓存池中读取到数据
+// 携带 Token 请求 Alist API
+//
+// Token 被 Alist 拒绝时（如被提前吊销）重新登录并重试一次
+func authRequest[T any](alistServer *AlistServer, funcInfo string, api string, payload any) (T, error) {
+	var zero T
 	token, err := alistServer.getToken()
 	if err != nil {
-		return fsGetDataResponse.Data, nil
+		return zero, err
 	}
 
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, payload)
+	data, err := request[T](alistServer, funcInfo, api, payload, token)
+	if !errors.Is(err, ErrUnauthorized) {
+		return data, err
+	}
 
+	if token, err = alistServer.refreshToken(token); err != nil {
+		return zero, err
+	}
+	return request[T](alistServer, funcInfo, api, payload, token)
+}
+
+// ==========Alist API(v3) 相关操作==========
+
+// 登录Alist（获取一个新的Token）
+func (alistServer *AlistServer) authLogin() (string, error) {
+	data, err := request[AuthLoginData](
+		alistServer,
+		"Alist登录",
+		"/api/auth/login",
+		AuthLoginRequest{Username: alistServer.GetUsername(), Password: alistServer.password},
+		"",
+	)
 	if err != nil {
-		err = fmt.Errorf("创建 %s 请求失败: %w", funcInfo, err)
-		return fsGetDataResponse.Data, err
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Code != http.StatusInternalServerError { // 用户名或密码错误
+			return "", fmt.Errorf("%w：%s", ErrUnauthorized, apiErr.Message)
+		}
+		return "", err
 	}
-	req.Header.Add("Authorization", token)
-	req.Header.Add("Content-Type", "application/json")
+	return data.Token, nil
+}
 
-	res, err := client.Do(req)
+// 获取某个文件/目录信息
+func (alistServer *AlistServer) FsGet(path string) (FsGetData, error) {
+	startTime := time.Now()
+	data, err := authRequest[FsGetData](
+		alistServer,
+		"Alist获取某个文件/目录信息",
+		"/api/fs/get",
+		FsGetRequest{Path: path, Password: alistServer.getMetaPassword(path), Page: 1, PerPage: 0, Refresh: false},
+	)
+	metrics.AlistFsGetDuration.ObserveSince(startTime, alistServer.GetEndpoint())
 	if err != nil {
-		err = fmt.Errorf("请求 %s 信息失败: %w", funcInfo, err)
-		return fsGetDataResponse.Data, err
+		metrics.AlistFsGetErrors.Inc(alistServer.GetEndpoint(), errorReason(err))
 	}
+	return data, err
+}
 
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
+// 检测 Alist 服务器是否可用
+//
+// 请求 /ping 接口，返回响应耗时
+func (alistServer *AlistServer) Ping() (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, alistServer.GetEndpoint()+"/ping", nil)
 	if err != nil {
-		err = fmt.Errorf("读取 %s 响应体失败: %w", funcInfo, err)
-		return fsGetDataResponse.Data, err
+		return 0, err
 	}
 
-	err = json.Unmarshal(body, &fsGetDataResponse)
+	startTime := time.Now()
+	res, err := alistServer.client.Do(req)
 	if err != nil {
-		err = fmt.Errorf("解析 %s 响应体失败: %w", funcInfo, err)
-		return fsGetDataResponse.Data, err
+		return 0, fmt.Errorf("%w：%v", ErrUnavailable, err)
 	}
-	if fsGetDataResponse.Code != 200 {
-		err = errors.New(fsGetDataResponse.Message)
-		return fsGetDataResponse.Data, err
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%w：/ping 响应状态码 %d", ErrUnavailable, res.StatusCode)
 	}
-
-	return fsGetDataResponse.Data, nil
+	return time.Since(startTime), nil
 }
 
 // 获得AlistServer实例
-func New(addr string, username string, password string, token *string) *AlistServer {
+//
+// metaPasswords 为受密码保护的路径及其元信息密码
+func New(addr string, username string, password string, token *string, metaPasswords map[string]string) *AlistServer {
 	s := AlistServer{
-		endpoint: utils.GetEndpoint(addr),
-		username: username,
-		password: password,
+		endpoint:      utils.GetEndpoint(addr),
+		username:      username,
+		password:      password,
+		metaPasswords: metaPasswords,
+		client:        &http.Client{Timeout: requestTimeout},
 	}
 	if token != nil {
-		s.token = alistToken{
-			value:    *token,
-			expireAt: time.Time{},
-		}
+		s.token.value = *token
+		s.token.expireAt = time.Time{}
 	}
 	return &s
 }
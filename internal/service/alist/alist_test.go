@@ -0,0 +1,130 @@
+package alist_test
+
+import (
+	"MediaWarp/internal/service/alist"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// 模拟 Alist 服务器
+//
+// validToken 为当前有效的 Token，每次登录都会签发一个新的 Token
+type mockAlist struct {
+	loginCount atomic.Int64
+	mutex      sync.Mutex
+	validToken string
+}
+
+func (m *mockAlist) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/auth/login":
+		n := m.loginCount.Add(1)
+		m.mutex.Lock()
+		m.validToken = "token-" + strconv.FormatInt(n, 10)
+		token := m.validToken
+		m.mutex.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"code": 200, "message": "success", "data": map[string]string{"token": token}})
+	case "/api/fs/get":
+		var req alist.FsGetRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		m.mutex.Lock()
+		valid := r.Header.Get("Authorization") == m.validToken
+		m.mutex.Unlock()
+		switch {
+		case !valid:
+			json.NewEncoder(w).Encode(map[string]any{"code": 401, "message": "token is invalidated"})
+		case strings.HasPrefix(req.Path, "/protected/") && req.Password != "secret":
+			json.NewEncoder(w).Encode(map[string]any{"code": 403, "message": "password is incorrect or you have no permission"})
+		case req.Path == "/missing.mkv":
+			json.NewEncoder(w).Encode(map[string]any{"code": 500, "message": "failed get objs: object not found"})
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"code": 200, "message": "success", "data": map[string]any{"name": "movie.mkv", "size": 1024}})
+		}
+	}
+}
+
+func TestFsGetRelogin(t *testing.T) {
+	mock := &mockAlist{}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	revoked := "revoked-token"
+	alistServer := alist.New(server.URL, "admin", "password", &revoked, nil)
+	data, err := alistServer.FsGet("/movie.mkv")
+	if err != nil {
+		t.Fatalf("Token 失效后应重新登录并重试，实际错误：%v", err)
+	}
+	if data.Size != 1024 {
+		t.Errorf("文件大小错误。期望: 1024, 实际: %d", data.Size)
+	}
+	if mock.loginCount.Load() != 1 {
+		t.Errorf("登录次数错误。期望: 1, 实际: %d", mock.loginCount.Load())
+	}
+}
+
+func TestFsGetSingleFlightLogin(t *testing.T) {
+	mock := &mockAlist{}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	alistServer := alist.New(server.URL, "admin", "password", nil, nil)
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := alistServer.FsGet("/movie.mkv"); err != nil {
+				t.Errorf("请求 FsGet 失败：%v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if mock.loginCount.Load() != 1 {
+		t.Errorf("并发请求登录次数错误。期望: 1, 实际: %d", mock.loginCount.Load())
+	}
+}
+
+func TestFsGetErrors(t *testing.T) {
+	mock := &mockAlist{}
+	server := httptest.NewServer(mock)
+
+	alistServer := alist.New(server.URL, "admin", "password", nil, nil)
+	if _, err := alistServer.FsGet("/missing.mkv"); !errors.Is(err, alist.ErrNotFound) {
+		t.Errorf("期望 ErrNotFound，实际：%v", err)
+	}
+
+	revoked := "revoked-token"
+	tokenOnlyServer := alist.New(server.URL, "", "", &revoked, nil)
+	if _, err := tokenOnlyServer.FsGet("/movie.mkv"); !errors.Is(err, alist.ErrUnauthorized) {
+		t.Errorf("期望 ErrUnauthorized，实际：%v", err)
+	}
+
+	server.Close()
+	if _, err := alistServer.FsGet("/movie.mkv"); !errors.Is(err, alist.ErrUnavailable) {
+		t.Errorf("期望 ErrUnavailable，实际：%v", err)
+	}
+}
+
+func TestFsGetMetaPassword(t *testing.T) {
+	mock := &mockAlist{}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	alistServer := alist.New(server.URL, "admin", "password", nil, map[string]string{
+		"/protected":       "secret",
+		"/protected/other": "wrong",
+	})
+	if _, err := alistServer.FsGet(`/protected/电影 "引号".mkv`); err != nil {
+		t.Errorf("受密码保护的路径应携带元信息密码，实际错误：%v", err)
+	}
+	if _, err := alistServer.FsGet("/protected/other/movie.mkv"); !errors.Is(err, alist.ErrForbidden) {
+		t.Errorf("应使用最长匹配路径的密码，期望 ErrForbidden，实际：%v", err)
+	}
+}
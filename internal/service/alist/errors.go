@@ -0,0 +1,63 @@
+package alist
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrNotFound     = errors.New("Alist 文件/目录不存在")
+	ErrUnauthorized = errors.New("Alist 认证失败")
+	ErrForbidden    = errors.New("Alist 拒绝访问")
+	ErrUnavailable  = errors.New("Alist 服务器不可用")
+)
+
+// Alist API 返回的错误
+//
+// Code 为响应体中的 code 字段
+// 可通过 errors.Is 判断错误类别（ErrNotFound、ErrUnauthorized、ErrForbidden、ErrUnavailable）
+type APIError struct {
+	Code    int64
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("Alist API 错误（%d）：%s", e.Code, e.Message)
+}
+
+// 根据状态码和错误信息对错误进行归类
+func (e *APIError) Unwrap() error {
+	switch e.Code {
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusInternalServerError: // Alist 找不到对象时返回 500，如 "object not found"、"storage not found"
+		if strings.Contains(strings.ToLower(e.Message), "not found") {
+			return ErrNotFound
+		}
+	}
+	return nil
+}
+
+// 得到错误类别的简短描述
+//
+// 用于指标标签
+func errorReason(err error) string {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, ErrForbidden):
+		return "forbidden"
+	case errors.Is(err, ErrUnavailable):
+		return "unavailable"
+	default:
+		return "other"
+	}
+}
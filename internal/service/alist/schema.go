@@ -27,3 +27,16 @@ type FsGetData struct {
 	Thumb    string      `json:"thumb"` // 缩略图
 	Type     int64       `json:"type"`  // 类型
 }
+
+type AuthLoginRequest struct {
+	Username string `json:"username"` // 用户名
+	Password string `json:"password"` // 密码
+}
+
+type FsGetRequest struct {
+	Path     string `json:"path"`     // 路径
+	Password string `json:"password"` // 元信息密码
+	Page     int64  `json:"page"`
+	PerPage  int64  `json:"per_page"`
+	Refresh  bool   `json:"refresh"` // 是否强制刷新
+}
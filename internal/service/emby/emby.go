@@ -2,17 +2,24 @@ package emby
 
 import (
 	"MediaWarp/constants"
+	"MediaWarp/internal/metrics"
 	"MediaWarp/utils"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 )
 
+var ErrUnauthorized = errors.New("Emby 访问令牌无效")
+
 type EmbyServer struct {
 	endpoint string
-	apiKey   string // 认证方式：APIKey；获取方式：Emby控制台 -> 高级 -> API密钥
+	client   *http.Client // 请求媒体服务器 API 使用的客户端
+	apiKey   string       // 认证方式：APIKey；获取方式：Emby控制台 -> 高级 -> API密钥
 }
 
 // 获取媒体服务器类型
@@ -36,6 +43,8 @@ func (embyServer *EmbyServer) GetAPIKey() string {
 // ItemsService
 // /Items
 func (embyServer *EmbyServer) ItemsServiceQueryItem(ids string, limit int, fields string) (*EmbyResponse, error) {
+	defer metrics.UpstreamRequestDuration.ObserveSince(time.Now(), string(embyServer.GetType()), "ItemsServiceQueryItem")
+
 	var (
 		params       = url.Values{}
 		itemResponse = &EmbyResponse{}
@@ -46,7 +55,7 @@ func (embyServer *EmbyServer) ItemsServiceQueryItem(ids string, limit int, field
 	params.Add("Recursive","true")
 	params.Add("api_key", embyServer.GetAPIKey())
 	api := embyServer.GetEndpoint() + "/Items?" + params.Encode()
-	resp, err := http.Get(api)
+	resp, err := embyServer.client.Get(api)
 	if err != nil {
 		return nil, err
 	}
@@ -64,9 +73,104 @@ func (embyServer *EmbyServer) ItemsServiceQueryItem(ids string, limit int, field
 	return itemResponse, nil
 }
 
+// ItemsService
+// /Items
+//
+// 分页查询媒体库中所有的视频条目（电影、剧集、视频）
+func (embyServer *EmbyServer) ItemsServiceQueryVideoItems(startIndex int, limit int, fields string) (*EmbyResponse, error) {
+	var (
+		params       = url.Values{}
+		itemResponse = &EmbyResponse{}
+	)
+	params.Add("Recursive", "true")
+	params.Add("IncludeItemTypes", "Movie,Episode,Video")
+	params.Add("StartIndex", strconv.Itoa(startIndex))
+	params.Add("Limit", strconv.Itoa(limit))
+	params.Add("Fields", fields)
+	params.Add("api_key", embyServer.GetAPIKey())
+
+	resp, err := embyServer.client.Get(embyServer.GetEndpoint() + "/Items?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(body, itemResponse); err != nil {
+		return nil, err
+	}
+	return itemResponse, nil
+}
+
+// LibraryService
+// /Library/VirtualFolders
+//
+// 获取所有媒体库及其对应的文件夹路径
+func (embyServer *EmbyServer) LibraryServiceGetVirtualFolders() ([]VirtualFolderInfo, error) {
+	var (
+		params         = url.Values{}
+		virtualFolders []VirtualFolderInfo
+	)
+	params.Add("api_key", embyServer.GetAPIKey())
+
+	resp, err := embyServer.client.Get(embyServer.GetEndpoint() + "/Library/VirtualFolders?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(body, &virtualFolders); err != nil {
+		return nil, err
+	}
+	return virtualFolders, nil
+}
+
+// UserService
+// /Users/Me
+//
+// 使用客户端的访问令牌获取当前用户，令牌无效时返回 ErrUnauthorized
+func (embyServer *EmbyServer) UsersServiceGetMe(token string) (*UserDto, error) {
+	req, err := http.NewRequest(http.MethodGet, embyServer.GetEndpoint()+"/Users/Me", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Emby-Token", token)
+	resp, err := embyServer.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, ErrUnauthorized
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("请求 /Users/Me 失败，响应状态码：%d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &UserDto{}
+	if err = json.Unmarshal(body, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 // 获取index.html内容 API：/web/index.html
 func (embyServer *EmbyServer) GetIndexHtml() ([]byte, error) {
-	resp, err := http.Get(embyServer.GetEndpoint() + "/web/index.html")
+	resp, err := embyServer.client.Get(embyServer.GetEndpoint() + "/web/index.html")
 	if err != nil {
 		return nil, err
 	}
@@ -80,9 +184,15 @@ func (embyServer *EmbyServer) GetIndexHtml() ([]byte, error) {
 }
 
 // 获取EmbyServer实例
-func New(addr string, apiKey string) *EmbyServer {
+//
+// client 为空时使用 http.DefaultClient
+func New(addr string, apiKey string, client *http.Client) *EmbyServer {
+	if client == nil {
+		client = http.DefaultClient
+	}
 	emby := &EmbyServer{
 		endpoint: utils.GetEndpoint(addr),
+		client:   client,
 		apiKey:   apiKey,
 	}
 	return emby
@@ -5,6 +5,14 @@ type EmbyResponse struct {
 	TotalRecordCount *int64        `json:"TotalRecordCount,omitempty"`
 }
 
+// /Library/VirtualFolders 的响应
+type VirtualFolderInfo struct {
+	CollectionType *string  `json:"CollectionType,omitempty"`
+	ItemID         *string  `json:"ItemId,omitempty"`
+	Locations      []string `json:"Locations,omitempty"`
+	Name           *string  `json:"Name,omitempty"`
+}
+
 // /Items/:itemID/PlaybackInfo的响应
 type PlaybackInfoResponse struct {
 	ErrorCode     *PlaybackErrorCode `json:"ErrorCode,omitempty"`
@@ -329,6 +337,19 @@ type MediaURL struct {
 	URL  *string `json:"Url,omitempty"`
 }
 
+// /Users/Me的响应
+type UserDto struct {
+	ID     *string     `json:"Id,omitempty"`
+	Name   *string     `json:"Name,omitempty"`
+	Policy *UserPolicy `json:"Policy,omitempty"`
+}
+
+// UserPolicy
+type UserPolicy struct {
+	IsAdministrator bool `json:"IsAdministrator"`
+	IsDisabled      bool `json:"IsDisabled"`
+}
+
 // UserItemDataDto
 type UserItemDataDto struct {
 	IsFavorite            *bool    `json:"IsFavorite,omitempty"`
@@ -0,0 +1,85 @@
+package wsproxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// WebSocket 帧操作码（RFC 6455）
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+)
+
+var ErrFrameTooLarge = errors.New("WebSocket 帧长度超出范围")
+
+// 帧头
+type frameHeader struct {
+	fin    bool
+	opcode byte
+	masked bool
+	mask   [4]byte
+	length int64 // 负载长度
+}
+
+// 是否为数据帧（文本、二进制、延续帧）
+func (header frameHeader) isData() bool {
+	return header.opcode == opContinuation || header.opcode == opText || header.opcode == opBinary
+}
+
+// 读取帧头
+//
+// 同时返回帧头的原始字节，用于原样转发
+func readFrameHeader(r *bufio.Reader) (frameHeader, []byte, error) {
+	var (
+		header frameHeader
+		buf    = make([]byte, 2, 14)
+	)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return header, nil, err
+	}
+	header.fin = buf[0]&0x80 != 0
+	header.opcode = buf[0] & 0x0F
+	header.masked = buf[1]&0x80 != 0
+
+	switch length := buf[1] & 0x7F; length {
+	case 126:
+		buf = buf[:4]
+		if _, err := io.ReadFull(r, buf[2:4]); err != nil {
+			return header, nil, err
+		}
+		header.length = int64(binary.BigEndian.Uint16(buf[2:4]))
+	case 127:
+		buf = buf[:10]
+		if _, err := io.ReadFull(r, buf[2:10]); err != nil {
+			return header, nil, err
+		}
+		length := binary.BigEndian.Uint64(buf[2:10])
+		if length > 1<<63-1 {
+			return header, nil, ErrFrameTooLarge
+		}
+		header.length = int64(length)
+	default:
+		header.length = int64(length)
+	}
+
+	if header.masked {
+		start := len(buf)
+		buf = buf[:start+4]
+		if _, err := io.ReadFull(r, buf[start:]); err != nil {
+			return header, nil, err
+		}
+		copy(header.mask[:], buf[start:])
+	}
+	return header, buf, nil
+}
+
+// 对负载进行掩码运算（掩码和去除掩码相同）
+func maskBytes(mask [4]byte, payload []byte) {
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+}
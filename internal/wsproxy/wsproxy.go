@@ -0,0 +1,336 @@
+package wsproxy
+
+import (
+	"MediaWarp/internal/logging"
+	"MediaWarp/internal/metrics"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	dialTimeout    = 10 * time.Second // 连接上游服务器的超时时间
+	maxMessageSize = 1 << 20          // 交给消息钩子检查的最大消息长度，超过时仅转发不检查
+)
+
+var ErrNotWebSocket = errors.New("不是 WebSocket 握手请求")
+
+// 消息方向
+type Direction string
+
+const (
+	ClientToServer Direction = "client" // 客户端发送至上游服务器
+	ServerToClient Direction = "server" // 上游服务器发送至客户端
+)
+
+// 关闭原因，用作指标标签
+const (
+	closeReasonClosed   = "closed"       // 任一方关闭连接或出错
+	closeReasonIdle     = "idle_timeout" // 空闲超时
+	closeReasonShutdown = "shutdown"     // 请求上下文取消（MediaWarp 正在退出）
+)
+
+// 消息钩子
+//
+// 在转发消息的协程中同步调用，不应阻塞；message 为完整的文本消息（已合并分片并去除掩码），调用结束后不可继续使用
+type MessageHook func(conn *Conn, direction Direction, message []byte)
+
+// 判断是否为 WebSocket 握手请求
+func IsWebSocketRequest(req *http.Request) bool {
+	return headerContains(req.Header, "Connection", "upgrade") && headerContains(req.Header, "Upgrade", "websocket")
+}
+
+func headerContains(header http.Header, key string, token string) bool {
+	for _, value := range header.Values(key) {
+		for item := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WebSocket 反向代理
+//
+// 帧原样转发，文本消息额外交给消息钩子检查
+type Proxy struct {
+	Target      *url.URL                // 上游服务器地址
+	Director    func(req *http.Request) // 修改转发至上游服务器的请求，通常使用 httputil.ReverseProxy 的 Director
+	IdleTimeout func() time.Duration    // 两个方向均无数据时关闭连接的时间，为 0 时不限制，在建立连接时读取
+
+	hooks  []MessageHook
+	nextID atomic.Uint64
+}
+
+// 注册消息钩子
+//
+// 需在开始处理请求前注册
+func (proxy *Proxy) OnMessage(hook MessageHook) {
+	proxy.hooks = append(proxy.hooks, hook)
+}
+
+// 调用消息钩子
+func (proxy *Proxy) dispatch(conn *Conn, direction Direction, message []byte) {
+	for _, hook := range proxy.hooks {
+		hook(conn, direction, message)
+	}
+}
+
+// 连接上游服务器
+func (proxy *Proxy) dial(ctx context.Context, target *url.URL) (net.Conn, error) {
+	host := target.Host
+	if target.Port() == "" {
+		port := "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(target.Hostname(), port)
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if target.Scheme == "https" {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: target.Hostname()}}
+		return tlsDialer.DialContext(ctx, "tcp", host)
+	}
+	return dialer.DialContext(ctx, "tcp", host)
+}
+
+// 处理 WebSocket 握手请求
+//
+// 上游服务器同意升级协议后接管客户端连接并双向转发，否则将上游服务器的响应返回给客户端
+func (proxy *Proxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !IsWebSocketRequest(req) {
+		http.Error(rw, ErrNotWebSocket.Error(), http.StatusBadRequest)
+		return
+	}
+
+	outReq := req.Clone(req.Context())
+	outReq.URL.Scheme, outReq.URL.Host = proxy.Target.Scheme, proxy.Target.Host
+	if proxy.Director != nil {
+		proxy.Director(outReq)
+	}
+	outReq.Header.Del("Sec-WebSocket-Extensions")                          // 不协商压缩扩展，以便检查消息内容
+	if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil { // 与 httputil.ReverseProxy 相同，在 Director 之后追加 X-Forwarded-For
+		if prior := outReq.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		outReq.Header.Set("X-Forwarded-For", clientIP)
+	}
+	outReq.Close = false
+
+	upstreamConn, err := proxy.dial(req.Context(), outReq.URL)
+	if err != nil {
+		logging.Warning("连接上游 WebSocket 失败：", err)
+		http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	if err = outReq.Write(upstreamConn); err != nil {
+		upstreamConn.Close()
+		logging.Warning("发送 WebSocket 握手请求失败：", err)
+		http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	upstreamReader := bufio.NewReader(upstreamConn)
+	resp, err := http.ReadResponse(upstreamReader, outReq)
+	if err != nil {
+		upstreamConn.Close()
+		logging.Warning("读取 WebSocket 握手响应失败：", err)
+		http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols { // 上游服务器拒绝升级（如认证失败）
+		defer upstreamConn.Close()
+		defer resp.Body.Close()
+		for key, values := range resp.Header {
+			rw.Header()[key] = values
+		}
+		rw.WriteHeader(resp.StatusCode)
+		io.Copy(rw, resp.Body)
+		return
+	}
+
+	clientConn, clientBuf, err := http.NewResponseController(rw).Hijack()
+	if err != nil {
+		upstreamConn.Close()
+		logging.Warning("接管 WebSocket 客户端连接失败：", err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(clientBuf, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(clientBuf)
+	clientBuf.WriteString("\r\n")
+	if err = clientBuf.Flush(); err != nil {
+		clientConn.Close()
+		upstreamConn.Close()
+		return
+	}
+
+	conn := &Conn{
+		ID:       proxy.nextID.Add(1),
+		Request:  req,
+		OpenedAt: time.Now(),
+		client:   clientConn,
+		upstream: upstreamConn,
+	}
+	var idleTimeout time.Duration
+	if proxy.IdleTimeout != nil {
+		idleTimeout = proxy.IdleTimeout()
+	}
+	conn.serve(req.Context(), proxy, buffered(clientBuf.Reader, clientConn, conn), buffered(upstreamReader, upstreamConn, conn), idleTimeout)
+}
+
+// 创建读取器：先读取已缓冲的数据，再从连接读取
+func buffered(reader *bufio.Reader, netConn net.Conn, conn *Conn) *bufio.Reader {
+	data, _ := reader.Peek(reader.Buffered())
+	return bufio.NewReader(io.MultiReader(bytes.NewReader(bytes.Clone(data)), &activityReader{reader: netConn, conn: conn}))
+}
+
+// 记录最后活动时间的读取器
+type activityReader struct {
+	reader io.Reader
+	conn   *Conn
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.conn.lastActive.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// 正在代理的 WebSocket 连接
+type Conn struct {
+	ID       uint64        // 连接编号
+	Request  *http.Request // 客户端的握手请求
+	OpenedAt time.Time     // 建立连接的时间
+
+	client      net.Conn
+	upstream    net.Conn
+	lastActive  atomic.Int64 // 最后活动时间（Unix 纳秒）
+	closeReason atomic.Value
+	closeOnce   sync.Once
+}
+
+// 关闭连接
+//
+// 仅记录第一次关闭的原因
+func (conn *Conn) close(reason string) {
+	conn.closeOnce.Do(func() {
+		conn.closeReason.Store(reason)
+		conn.client.Close()
+		conn.upstream.Close()
+	})
+}
+
+// 双向转发直到任一方关闭连接、空闲超时或 ctx 取消
+func (conn *Conn) serve(ctx context.Context, proxy *Proxy, clientReader *bufio.Reader, upstreamReader *bufio.Reader, idleTimeout time.Duration) {
+	conn.lastActive.Store(time.Now().UnixNano())
+	metrics.WebSocketOpened.Inc()
+	logging.Debugf("WebSocket 连接 #%d 已建立：%s", conn.ID, conn.Request.URL.Path)
+
+	done := make(chan struct{})
+	go func() {
+		var tick <-chan time.Time
+		if idleTimeout > 0 {
+			ticker := time.NewTicker(max(idleTimeout/4, time.Second))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				conn.close(closeReasonShutdown)
+				return
+			case <-tick:
+				if time.Since(time.Unix(0, conn.lastActive.Load())) > idleTimeout {
+					conn.close(closeReasonIdle)
+					return
+				}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		conn.relay(proxy, conn.upstream, clientReader, ClientToServer)
+		conn.close(closeReasonClosed)
+	}()
+	go func() {
+		defer wg.Done()
+		conn.relay(proxy, conn.client, upstreamReader, ServerToClient)
+		conn.close(closeReasonClosed)
+	}()
+	wg.Wait()
+	close(done)
+
+	reason := conn.closeReason.Load().(string)
+	metrics.WebSocketClosed.Inc(reason)
+	logging.Debugf("WebSocket 连接 #%d 已关闭（%s），持续 %s", conn.ID, reason, time.Since(conn.OpenedAt).Round(time.Second))
+}
+
+// 转发一个方向的帧
+//
+// 帧头和负载原样转发，未分片或分片的文本消息在收齐后交给消息钩子
+func (conn *Conn) relay(proxy *Proxy, dst io.Writer, src *bufio.Reader, direction Direction) error {
+	var (
+		message    []byte
+		inspecting bool // 当前消息是否需要交给消息钩子
+	)
+	for {
+		header, raw, err := readFrameHeader(src)
+		if err != nil {
+			return err
+		}
+		if _, err = dst.Write(raw); err != nil {
+			return err
+		}
+
+		if header.opcode == opText || header.opcode == opBinary { // 新消息的第一帧
+			message = message[:0]
+			inspecting = header.opcode == opText && len(proxy.hooks) > 0
+		}
+		collect := header.isData() && inspecting
+		if collect && int64(len(message))+header.length > maxMessageSize {
+			inspecting, collect = false, false
+		}
+
+		if !collect {
+			if _, err = io.CopyN(dst, src, header.length); err != nil {
+				return err
+			}
+			continue
+		}
+		start := len(message)
+		message = append(message, make([]byte, header.length)...)
+		payload := message[start:]
+		if _, err = io.ReadFull(src, payload); err != nil {
+			return err
+		}
+		if _, err = dst.Write(payload); err != nil {
+			return err
+		}
+		if header.masked {
+			maskBytes(header.mask, payload)
+		}
+		if header.fin {
+			proxy.dispatch(conn, direction, message)
+			inspecting = false
+		}
+	}
+}
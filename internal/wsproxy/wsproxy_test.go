@@ -0,0 +1,132 @@
+package wsproxy_test
+
+import (
+	"MediaWarp/internal/wsproxy"
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 回显服务器：将客户端发送的文本帧去除掩码后原样返回
+func newEchoServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Sec-WebSocket-Extensions") != "" {
+			t.Error("不应向上游服务器转发 Sec-WebSocket-Extensions")
+		}
+		conn, buf, err := http.NewResponseController(rw).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		buf.Flush()
+
+		header := make([]byte, 6)
+		if _, err = io.ReadFull(buf, header); err != nil {
+			t.Error(err)
+			return
+		}
+		payload := make([]byte, header[1]&0x7F)
+		io.ReadFull(buf, payload)
+		for i := range payload {
+			payload[i] ^= header[2+i%4]
+		}
+		conn.Write(append([]byte{0x81, byte(len(payload))}, payload...))
+	}))
+}
+
+func TestProxy(t *testing.T) {
+	upstream := newEchoServer(t)
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	var (
+		mutex    sync.Mutex
+		messages []string
+	)
+	proxy := &wsproxy.Proxy{Target: target}
+	proxy.OnMessage(func(conn *wsproxy.Conn, direction wsproxy.Direction, message []byte) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		messages = append(messages, string(direction)+":"+string(message))
+	})
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /embywebsocket HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Extensions: permessage-deflate\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("握手响应状态码 %d，期望 101", resp.StatusCode)
+	}
+
+	text := []byte(`{"MessageType":"KeepAlive"}`)
+	mask := [4]byte{1, 2, 3, 4}
+	frame := append([]byte{0x81, 0x80 | byte(len(text))}, mask[:]...)
+	for i, b := range text {
+		frame = append(frame, b^mask[i%4])
+	}
+	conn.Write(frame)
+
+	echo := make([]byte, 2+len(text))
+	if _, err = io.ReadFull(reader, echo); err != nil {
+		t.Fatal(err)
+	}
+	if string(echo[2:]) != string(text) {
+		t.Errorf("回显消息为 %q，期望 %q", echo[2:], text)
+	}
+
+	deadline := time.Now().Add(time.Second) // 消息钩子在转发消息之后调用
+	for {
+		mutex.Lock()
+		if len(messages) >= 2 || time.Now().After(deadline) {
+			break
+		}
+		mutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer mutex.Unlock()
+	want := []string{"client:" + string(text), "server:" + string(text)}
+	if len(messages) != 2 || messages[0] != want[0] || messages[1] != want[1] {
+		t.Errorf("消息钩子收到 %q，期望 %q", messages, want)
+	}
+}
+
+func TestProxyRejected(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+	server := httptest.NewServer(&wsproxy.Proxy{Target: target})
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/embywebsocket", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("上游服务器拒绝升级时应返回上游的状态码，实际为 %d", resp.StatusCode)
+	}
+}
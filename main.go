@@ -2,16 +2,23 @@ package main
 
 import (
 	"MediaWarp/constants"
+	"MediaWarp/internal/clientfilter"
 	"MediaWarp/internal/config"
+	"MediaWarp/internal/forwarded"
 	"MediaWarp/internal/handler"
+	"MediaWarp/internal/history"
+	"MediaWarp/internal/ipfilter"
 	"MediaWarp/internal/logging"
 	"MediaWarp/internal/router"
+	"MediaWarp/internal/server"
 	"MediaWarp/internal/service"
 	"MediaWarp/utils"
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"encoding/json"
@@ -45,11 +52,6 @@ func main() {
 
 	gin.SetMode(gin.ReleaseMode)
 
-	if isDebug {
-		logging.SetLevel(logrus.DebugLevel)
-		fmt.Println("已启用调试模式")
-	}
-
 	signChan := make(chan os.Signal, 1)
 	errChan := make(chan error, 1)
 	signal.Notify(signChan, syscall.SIGINT, syscall.SIGTERM)
@@ -61,22 +63,59 @@ func main() {
 		fmt.Println("配置初始化失败：", err)
 		return
 	}
-	logging.Init()                                                                           // 初始化日志
-	logging.Infof("上游媒体服务器类型：%s，服务器地址：%s", config.MediaServer.Type, config.MediaServer.ADDR) // 日志打印
-	service.InitAlistSerer()                                                                 // 初始化Alist服务器
-	if err := handler.Init(); err != nil {                                                   // 初始化媒体服务器处理器
+	if err := logging.Init(); err != nil { // 初始化日志
+		fmt.Println("日志初始化失败：", err)
+		return
+	}
+	defer logging.Close()
+	if isDebug { // -debug 参数优先于配置文件中的日志级别
+		logging.SetLevel(logrus.DebugLevel)
+		fmt.Println("已启用调试模式")
+	}
+	logging.Infof("上游媒体服务器类型：%s，服务器地址：%s", config.Get().MediaServer.Type, config.Get().MediaServer.ADDR) // 日志打印
+	if len(config.Get().MediaServer.Backups) > 0 {
+		logging.Infof("上游媒体服务器备用地址：%s，选择策略：%s", strings.Join(config.Get().MediaServer.Backups, "、"), config.Get().MediaServer.Balance)
+	}
+	for _, upstream := range config.Get().Upstreams {
+		logging.Infof("上游媒体服务器 %s 类型：%s，服务器地址：%s", upstream.Name, upstream.Type, upstream.ADDR)
+	}
+	backgroundCtx, stopBackground := context.WithCancel(context.Background()) // 退出时停止后台任务
+	defer stopBackground()
+	service.InitAlistSerer()                            // 初始化Alist服务器
+	if err := handler.Init(backgroundCtx); err != nil { // 初始化媒体服务器处理器
 		logging.Error("媒体服务器处理器初始化失败：", err)
 		return
 	}
+	if err := history.Init(); err != nil { // 初始化播放历史
+		logging.Error("播放历史初始化失败：", err)
+		return
+	}
+	defer history.Close()
+	if err := forwarded.Init(); err != nil { // 初始化转发请求头处理器
+		logging.Error("转发请求头设置初始化失败：", err)
+		return
+	}
+	if err := ipfilter.Init(); err != nil { // 初始化 IP 访问控制
+		logging.Error("IP 访问控制初始化失败：", err)
+		return
+	}
+	defer ipfilter.Close()
+	if err := clientfilter.Init(); err != nil { // 初始化客户端过滤器
+		logging.Error("客户端过滤器初始化失败：", err)
+		return
+	}
 
-	logging.Info("MediaWarp 监听端口：", config.Port)
 	ginR := router.InitRouter() // 路由初始化
+	srv, err := server.New(ginR)
+	if err != nil {
+		logging.Error("MediaWarp 服务器初始化失败：", err)
+		return
+	}
+	if err := srv.Start(errChan); err != nil {
+		logging.Error("MediaWarp 启动失败：", err)
+		return
+	}
 	logging.Info("MediaWarp 启动成功")
-	go func() {
-		if err := ginR.Run(config.ListenAddr()); err != nil {
-			errChan <- err
-		}
-	}()
 
 	select {
 	case sig := <-signChan:
@@ -84,4 +123,19 @@ func main() {
 	case err := <-errChan:
 		logging.Error("MediaWarp 运行出错：", err)
 	}
+
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { // 再次收到退出信号时不再等待请求完成
+		<-signChan
+		logging.Warning("再次收到退出信号，立即关闭所有连接")
+		cancel()
+	}()
+	err = srv.Shutdown(shutdownCtx)
+	stopBackground() // 请求处理完成后停止健康检查等后台任务
+	if err != nil {
+		logging.Warning(err)
+		return
+	}
+	logging.Info("所有请求已处理完成")
 }
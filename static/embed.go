@@ -24,4 +24,6 @@ import (
 //go:embed jellyfin-crx/static/js/jquery-3.6.0.min.js
 //go:embed jellyfin-crx/static/js/md5.min.js
 //go:embed jellyfin-crx/content/main.js
+//go:embed mediawarp/strm-scan.html
+//go:embed mediawarp/admin.html
 var EmbeddedStaticAssets embed.FS
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 签名 URL 中常见的过期时间查询参数（值为 Unix 时间戳）
+var urlExpiryParams = []string{"expires", "expire", "x-oss-expires", "deadline", "e", "exp"}
+
+// 从签名 URL 的查询参数中解析过期时间
+//
+// 支持常见的 Unix 时间戳参数（秒或毫秒）、AWS S3 V4 签名（X-Amz-Date + X-Amz-Expires）、Alist 签名（sign=xxx:过期时间戳）
+// 存在多个提示时返回最早的过期时间
+func ParseURLExpiry(u *url.URL) (time.Time, bool) {
+	var (
+		expireAt time.Time
+		found    bool
+	)
+	update := func(t time.Time) {
+		if !found || t.Before(expireAt) {
+			expireAt, found = t, true
+		}
+	}
+
+	query := u.Query()
+	for key, values := range query {
+		if len(values) == 0 {
+			continue
+		}
+		lowerKey := strings.ToLower(key)
+		for _, param := range urlExpiryParams {
+			if lowerKey == param {
+				if t, ok := parseUnixTimestamp(values[0]); ok {
+					update(t)
+				}
+			}
+		}
+		if lowerKey == "sign" { // Alist 签名：xxx:过期时间戳，0 表示永不过期
+			if index := strings.LastIndexByte(values[0], ':'); index != -1 {
+				if t, ok := parseUnixTimestamp(values[0][index+1:]); ok {
+					update(t)
+				}
+			}
+		}
+	}
+
+	if amzDate, amzExpires := query.Get("X-Amz-Date"), query.Get("X-Amz-Expires"); amzDate != "" && amzExpires != "" {
+		signedAt, err := time.Parse("20060102T150405Z", amzDate)
+		seconds, convErr := strconv.ParseInt(amzExpires, 10, 64)
+		if err == nil && convErr == nil {
+			update(signedAt.Add(time.Duration(seconds) * time.Second))
+		}
+	}
+	return expireAt, found
+}
+
+// 解析 Unix 时间戳（秒或毫秒）
+//
+// 过小的数值可能是相对时间或其他含义的参数，不作为过期时间
+func parseUnixTimestamp(s string) (time.Time, bool) {
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch {
+	case value >= 1e9 && value < 1e11: // 秒
+		return time.Unix(value, 0), true
+	case value >= 1e12 && value < 1e14: // 毫秒
+		return time.UnixMilli(value), true
+	}
+	return time.Time{}, false
+}
+
+// 从 HTTP 响应头中解析缓存过期时间
+//
+// 优先使用 Cache-Control 的 max-age，其次使用 Expires
+// 第二个返回值表示是否找到过期时间，第三个返回值表示响应禁止缓存（no-store、no-cache）
+func ParseCacheExpiry(header http.Header, now time.Time) (time.Time, bool, bool) {
+	if cacheControl := header.Get("Cache-Control"); cacheControl != "" {
+		for _, directive := range strings.Split(cacheControl, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			switch {
+			case directive == "no-store" || directive == "no-cache":
+				return time.Time{}, false, true
+			case strings.HasPrefix(directive, "max-age="):
+				if seconds, err := strconv.ParseInt(strings.TrimPrefix(directive, "max-age="), 10, 64); err == nil {
+					return now.Add(time.Duration(seconds) * time.Second), true, false
+				}
+			}
+		}
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		if t, err := http.ParseTime(expires); err == nil {
+			return t, true, false
+		}
+	}
+	return time.Time{}, false, false
+}
@@ -0,0 +1,79 @@
+package utils_test
+
+import (
+	"MediaWarp/utils"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseURLExpiry(t *testing.T) {
+	type TestCase struct {
+		URL      string
+		ExpireAt time.Time
+		Found    bool
+	}
+	testCases := map[string]TestCase{
+		"Unix 时间戳": {
+			"https://cdn.example.com/a.mkv?expires=1700000000&sign=abc",
+			time.Unix(1700000000, 0),
+			true,
+		},
+		"毫秒时间戳": {
+			"https://cdn.example.com/a.mkv?Expires=1700000000000",
+			time.UnixMilli(1700000000000),
+			true,
+		},
+		"AWS S3 V4": {
+			"https://bucket.s3.amazonaws.com/a.mkv?X-Amz-Date=20231114T221320Z&X-Amz-Expires=3600&X-Amz-Signature=xxx",
+			time.Date(2023, 11, 14, 23, 13, 20, 0, time.UTC),
+			true,
+		},
+		"Alist 签名": {
+			"http://alist.example.com/d/a.mkv?sign=i6Tb5jDYzf_P6MmfaVQ7I3D2hHcWx3mFLvifyj3tMSY=:1700000000",
+			time.Unix(1700000000, 0),
+			true,
+		},
+		"取最早的过期时间": {
+			"https://cdn.example.com/a.mkv?expires=1800000000&deadline=1700000000",
+			time.Unix(1700000000, 0),
+			true,
+		},
+		"无过期提示": {
+			"https://cdn.example.com/a.mkv?e=30&sign=abc",
+			time.Time{},
+			false,
+		},
+	}
+	for caseName, testCase := range testCases {
+		t.Run(caseName, func(t *testing.T) {
+			u, _ := url.Parse(testCase.URL)
+			expireAt, found := utils.ParseURLExpiry(u)
+			if found != testCase.Found || !expireAt.Equal(testCase.ExpireAt) {
+				t.Errorf("%s 解析错误。期望: %s %t, 实际: %s %t", caseName, testCase.ExpireAt, testCase.Found, expireAt, found)
+			}
+		})
+	}
+}
+
+func TestParseCacheExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	header := http.Header{}
+	header.Set("Cache-Control", "public, max-age=600")
+	header.Set("Expires", "Mon, 01 Jan 2024 01:00:00 GMT")
+	if expireAt, found, noStore := utils.ParseCacheExpiry(header, now); !found || noStore || !expireAt.Equal(now.Add(10*time.Minute)) {
+		t.Errorf("max-age 解析错误：%s %t %t", expireAt, found, noStore)
+	}
+
+	header.Del("Cache-Control")
+	if expireAt, found, _ := utils.ParseCacheExpiry(header, now); !found || !expireAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expires 解析错误：%s %t", expireAt, found)
+	}
+
+	header.Set("Cache-Control", "no-store")
+	if _, _, noStore := utils.ParseCacheExpiry(header, now); !noStore {
+		t.Error("no-store 解析错误")
+	}
+}
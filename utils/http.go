@@ -3,6 +3,7 @@ package utils
 import (
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -46,3 +47,56 @@ func GetRespBody(ctx *gin.Context, target string, api_key string) (body []byte,
 	}
 	return
 }
+
+// 客户端信息
+//
+// 从 Emby/Jellyfin 客户端请求的认证头、请求头和查询参数中解析
+type ClientInfo struct {
+	UserID   string // 用户 ID
+	Client   string // 客户端名称
+	Device   string // 设备名称
+	DeviceID string // 设备 ID
+	Version  string // 客户端版本
+	Token    string // 访问令牌
+}
+
+// 解析请求中的客户端信息
+//
+// 优先级：X-Emby-Authorization（Authorization）请求头 > X-Emby-* 请求头 > 查询参数
+func GetClientInfo(req *http.Request) ClientInfo {
+	authHeader := req.Header.Get("X-Emby-Authorization")
+	if authHeader == "" {
+		authHeader = req.Header.Get("Authorization")
+	}
+	auth := ParseEmbyAuthorization(authHeader)
+	query := req.URL.Query()
+
+	// 依次从认证头字段、请求头、查询参数中获取第一个非空值
+	lookup := func(authKey string, headerKeys ...string) string {
+		if value := auth[authKey]; value != "" {
+			return value
+		}
+		for _, key := range headerKeys {
+			if value := req.Header.Get(key); value != "" {
+				return value
+			}
+		}
+		for _, key := range headerKeys {
+			for queryKey, values := range query {
+				if strings.EqualFold(queryKey, key) && values[0] != "" {
+					return values[0]
+				}
+			}
+		}
+		return ""
+	}
+
+	return ClientInfo{
+		UserID:   lookup("UserId", "UserId"),
+		Client:   lookup("Client", "X-Emby-Client"),
+		Device:   lookup("Device", "X-Emby-Device-Name"),
+		DeviceID: lookup("DeviceId", "X-Emby-Device-Id", "DeviceId"),
+		Version:  lookup("Version", "X-Emby-Client-Version"),
+		Token:    lookup("Token", "X-Emby-Token", "X-MediaBrowser-Token", "api_key"),
+	}
+}
@@ -0,0 +1,12 @@
+package utils
+
+// 获取指针指向的值
+//
+// 指针为 nil 时返回零值
+func Deref[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+	return *p
+}
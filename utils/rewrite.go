@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// 字符串重写规则
+type RewriteRule interface {
+	Rewrite(s string) string
+}
+
+// 前缀替换规则
+type prefixRewriteRule struct {
+	from string
+	to   string
+}
+
+func (rule *prefixRewriteRule) Rewrite(s string) string {
+	if strings.HasPrefix(s, rule.from) {
+		return rule.to + strings.TrimPrefix(s, rule.from)
+	}
+	return s
+}
+
+// 正则替换规则
+type regexpRewriteRule struct {
+	regexp *regexp.Regexp
+	to     string
+}
+
+func (rule *regexpRewriteRule) Rewrite(s string) string {
+	return rule.regexp.ReplaceAllString(s, rule.to)
+}
+
+// URL 解码规则
+type urlDecodeRewriteRule struct{}
+
+func (rule *urlDecodeRewriteRule) Rewrite(s string) string {
+	if decoded, err := url.PathUnescape(s); err == nil {
+		return decoded
+	}
+	return s
+}
+
+// 创建前缀替换规则
+//
+// 将 s 的前缀 from 替换为 to，不以 from 开头则保持不变
+func NewPrefixRewriteRule(from string, to string) RewriteRule {
+	return &prefixRewriteRule{from: from, to: to}
+}
+
+// 创建正则替换规则
+//
+// to 中可以使用 $1、${name} 引用捕获组
+func NewRegexpRewriteRule(expr string, to string) (RewriteRule, error) {
+	reg, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &regexpRewriteRule{regexp: reg, to: to}, nil
+}
+
+// 创建 URL 解码规则
+//
+// 解码失败时保持不变
+func NewURLDecodeRewriteRule() RewriteRule {
+	return &urlDecodeRewriteRule{}
+}
+
+// 重写规则列表
+//
+// 按顺序依次应用每一条规则
+type RewriteRules []RewriteRule
+
+func (rules RewriteRules) Rewrite(s string) string {
+	for _, rule := range rules {
+		s = rule.Rewrite(s)
+	}
+	return s
+}
+
+// 依次应用每一条规则并记录每一步的结果
+//
+// 返回值第一个元素为原始字符串
+func (rules RewriteRules) Trace(s string) []string {
+	steps := make([]string, 0, len(rules)+1)
+	steps = append(steps, s)
+	for _, rule := range rules {
+		s = rule.Rewrite(s)
+		steps = append(steps, s)
+	}
+	return steps
+}
@@ -0,0 +1,53 @@
+package utils_test
+
+import (
+	"MediaWarp/utils"
+	"testing"
+)
+
+func TestRewriteRules(t *testing.T) {
+	regexpRule, err := utils.NewRegexpRewriteRule(`^/mnt/(\w+)/`, "/$1-drive/")
+	if err != nil {
+		t.Fatalf("编译正则规则失败：%v", err)
+	}
+	rules := utils.RewriteRules{
+		utils.NewURLDecodeRewriteRule(),
+		utils.NewPrefixRewriteRule("/strm/115", "/115"),
+		regexpRule,
+	}
+
+	type TestCase struct {
+		Input  string
+		Result string
+	}
+	testCases := map[string]TestCase{
+		"URL 解码并替换前缀": {
+			"/strm/115/%E7%94%B5%E5%BD%B1/Movie%20(2024).mkv",
+			"/115/电影/Movie (2024).mkv",
+		},
+		"正则替换": {
+			"/mnt/aliyun/Movie.mkv",
+			"/aliyun-drive/Movie.mkv",
+		},
+		"不匹配任何规则": {
+			"/media/Movie.mkv",
+			"/media/Movie.mkv",
+		},
+		"非法编码保持不变": {
+			"/strm/115/100%.mkv",
+			"/115/100%.mkv",
+		},
+	}
+
+	for caseName, testCase := range testCases {
+		t.Run(caseName, func(t *testing.T) {
+			if result := rules.Rewrite(testCase.Input); result != testCase.Result {
+				t.Errorf("%s 重写错误。期望: %s, 实际: %s", caseName, testCase.Result, result)
+			}
+		})
+	}
+
+	if steps := rules.Trace("/strm/115/a.mkv"); len(steps) != len(rules)+1 || steps[0] != "/strm/115/a.mkv" || steps[len(steps)-1] != "/115/a.mkv" {
+		t.Errorf("重写过程记录错误：%v", steps)
+	}
+}
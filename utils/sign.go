@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// HMAC-SHA256 签名
+//
+// 签名内容为 "{path}:{expires}"，签名结果以十六进制编码
+// 将签名和过期时间（Unix 时间戳）写入 signParam、expireParam 查询参数
+func SignURLHMAC(u *url.URL, secret string, expires int64, signParam string, expireParam string) {
+	expiresStr := strconv.FormatInt(expires, 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(u.Path + ":" + expiresStr))
+
+	query := u.Query()
+	query.Set(expireParam, expiresStr)
+	query.Set(signParam, hex.EncodeToString(mac.Sum(nil)))
+	u.RawQuery = query.Encode()
+}
+
+// Nginx secure_link 签名
+//
+// 对应 Nginx 配置：
+// secure_link $arg_md5,$arg_expires;
+// secure_link_md5 "$secure_link_expires$uri secret";
+func SignURLNginxSecureLink(u *url.URL, secret string, expires int64) {
+	expiresStr := strconv.FormatInt(expires, 10)
+	sum := md5.Sum([]byte(expiresStr + u.Path + " " + secret))
+
+	query := u.Query()
+	query.Set("md5", base64.RawURLEncoding.EncodeToString(sum[:]))
+	query.Set("expires", expiresStr)
+	u.RawQuery = query.Encode()
+}
+
+// Alist 签名
+//
+// 与 Alist 的 sign 算法一致：base64url(HMAC-SHA256(token, "{path}:{expires}")) + ":" + expires
+// 路径为 Alist 上的文件路径，会去除 URL 中的 /d、/p 前缀；expires 为 0 表示永不过期
+func SignURLAlist(u *url.URL, token string, expires int64) {
+	filePath := u.Path
+	for _, prefix := range []string{"/d/", "/p/"} {
+		if strings.HasPrefix(filePath, prefix) {
+			filePath = strings.TrimPrefix(filePath, prefix[:2])
+			break
+		}
+	}
+
+	expiresStr := strconv.FormatInt(expires, 10)
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(filePath + ":" + expiresStr))
+
+	query := u.Query()
+	query.Set("sign", base64.URLEncoding.EncodeToString(mac.Sum(nil))+":"+expiresStr)
+	u.RawQuery = query.Encode()
+}
@@ -0,0 +1,37 @@
+package utils_test
+
+import (
+	"MediaWarp/utils"
+	"net/url"
+	"testing"
+)
+
+func TestSignURL(t *testing.T) {
+	type TestCase struct {
+		Sign   func(u *url.URL)
+		Result string
+	}
+	testCases := map[string]TestCase{
+		"HMAC": {
+			func(u *url.URL) { utils.SignURLHMAC(u, "secret", 1700000000, "sign", "expires") },
+			"https://cdn.example.com/d/movie/%E7%94%B5%E5%BD%B1.mkv?expires=1700000000&sign=7db4c862ebb089ef8ff361219cf4f7a8123c018ff952b0438a154f66e1073622",
+		},
+		"NginxSecureLink": {
+			func(u *url.URL) { utils.SignURLNginxSecureLink(u, "secret", 1700000000) },
+			"https://cdn.example.com/d/movie/%E7%94%B5%E5%BD%B1.mkv?expires=1700000000&md5=Kn2CjQ9LW4EkUHXOtSEDug",
+		},
+		"Alist": {
+			func(u *url.URL) { utils.SignURLAlist(u, "alist-token", 0) }, // 签名内容为去除 /d 前缀后的路径
+			"https://cdn.example.com/d/movie/%E7%94%B5%E5%BD%B1.mkv?sign=i6Tb5jDYzf_P6MmfaVQ7I3D2hHcWx3mFLvifyj3tMSY%3D%3A0",
+		},
+	}
+	for caseName, testCase := range testCases {
+		t.Run(caseName, func(t *testing.T) {
+			u, _ := url.Parse("https://cdn.example.com/d/movie/电影.mkv")
+			testCase.Sign(u)
+			if u.String() != testCase.Result {
+				t.Errorf("%s 签名错误。期望: %s, 实际: %s", caseName, testCase.Result, u.String())
+			}
+		})
+	}
+}
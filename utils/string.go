@@ -106,6 +106,44 @@ func ResolveEmbyAPIKVPairs(urlString string) (string, error) {
 	return "", nil
 }
 
+// 解析 Emby/Jellyfin 的认证请求头
+//
+// 示例：MediaBrowser Client="Emby Web", Device="Chrome", DeviceId="xxx", Version="4.8.0.0", Token="xxx"
+// 返回键值对，值会进行 URL 解码（Jellyfin 客户端会对值进行编码）
+func ParseEmbyAuthorization(header string) map[string]string {
+	result := make(map[string]string)
+	header = strings.TrimSpace(header)
+	if scheme, rest, ok := strings.Cut(header, " "); ok && !strings.Contains(scheme, "=") { // 去除 MediaBrowser、Emby 等认证方案前缀
+		header = rest
+	}
+
+	for header != "" {
+		var key, value string
+		key, header, _ = strings.Cut(header, "=")
+		key = strings.Trim(strings.TrimSpace(key), ",")
+		header = strings.TrimLeft(header, " ")
+		if strings.HasPrefix(header, `"`) { // 带引号的值，可能包含逗号
+			end := strings.IndexByte(header[1:], '"')
+			if end == -1 {
+				value, header = header[1:], ""
+			} else {
+				value, header = header[1:end+1], header[end+2:]
+			}
+		} else {
+			value, header, _ = strings.Cut(header, ",")
+		}
+		header = strings.TrimLeft(header, ", ")
+		if key == "" {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		result[key] = strings.TrimSpace(value)
+	}
+	return result
+}
+
 // 判断字符串是否为整型数字
 func isInt[T ~[]byte | ~[]rune | ~string](s T) bool {
 	_, err := strconv.Atoi(string(s))
@@ -133,3 +133,37 @@ func BenchmarkMethod2(b *testing.B) {
 		buf.Write(newLine)
 	}
 }
+
+func TestParseEmbyAuthorization(t *testing.T) {
+	testCases := map[string]struct {
+		Header string
+		Result map[string]string
+	}{
+		"Emby": {
+			`MediaBrowser Client="Emby Web", Device="Chrome", DeviceId="b5f997a7", Version="4.8.0.0", Token="e12acc08"`,
+			map[string]string{"Client": "Emby Web", "Device": "Chrome", "DeviceId": "b5f997a7", "Version": "4.8.0.0", "Token": "e12acc08"},
+		},
+		"Jellyfin": {
+			`MediaBrowser Client="Jellyfin%20Web", Device="Firefox", DeviceId="TW96aWxsYQ%3D%3D", Version="10.10.0", UserId="a1b2"`,
+			map[string]string{"Client": "Jellyfin Web", "Device": "Firefox", "DeviceId": "TW96aWxsYQ==", "Version": "10.10.0", "UserId": "a1b2"},
+		},
+		"逗号与无引号": {
+			`Emby UserId=a1b2, Client="Infuse, Direct", Device=iPhone`,
+			map[string]string{"UserId": "a1b2", "Client": "Infuse, Direct", "Device": "iPhone"},
+		},
+		"空": {"", map[string]string{}},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result := utils.ParseEmbyAuthorization(testCase.Header)
+			if len(result) != len(testCase.Result) {
+				t.Fatalf("期望：%v，实际：%v", testCase.Result, result)
+			}
+			for key, value := range testCase.Result {
+				if result[key] != value {
+					t.Errorf("%s 期望：%q，实际：%q", key, value, result[key])
+				}
+			}
+		})
+	}
+}